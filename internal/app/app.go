@@ -0,0 +1,289 @@
+// Package app wires the balance microservice together and runs it until it is stopped
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/eugenshima/balance/internal/auth"
+	cfgrtn "github.com/eugenshima/balance/internal/config"
+	"github.com/eugenshima/balance/internal/events"
+	"github.com/eugenshima/balance/internal/gateway"
+	"github.com/eugenshima/balance/internal/handlers"
+	"github.com/eugenshima/balance/internal/migrations"
+	"github.com/eugenshima/balance/internal/repository"
+	"github.com/eugenshima/balance/internal/service"
+	proto "github.com/eugenshima/balance/proto"
+
+	"github.com/go-playground/validator"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+// App is the balance microservice: the gRPC server, the metrics endpoint and the background workers
+type App struct {
+	cfg *cfgrtn.Config
+}
+
+// New constructor for App
+func New(cfg *cfgrtn.Config) *App {
+	return &App{cfg: cfg}
+}
+
+// Run starts the service and blocks until ctx is done or the gRPC server fails. On shutdown the health status turns
+// NOT_SERVING, streams are canceled, in-flight RPCs get up to ShutdownTimeout to finish, then the metrics server
+// and the background workers are stopped, the pool is closed and the remaining spans are flushed.
+func (a *App) Run(ctx context.Context) error {
+	cfg := a.cfg
+	shutdownTracing, err := NewTracerProvider(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("NewTracerProvider: %w", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		err := shutdownTracing(ctx)
+		if err != nil {
+			logrus.Errorf("shutdownTracing: %v", err)
+		}
+	}()
+
+	pool, err := NewDBPsql(ctx, cfg.PgxDBAddr, cfg.DBConnectTimeout)
+	if err != nil {
+		return fmt.Errorf("NewDBPsql: %w", err)
+	}
+	defer pool.Close()
+	if cfg.DBMigrate {
+		migrator, err := migrations.NewMigrator(pool, cfg.DBLegacyCurrency)
+		if err != nil {
+			return fmt.Errorf("NewMigrator: %w", err)
+		}
+		_, err = migrator.Up(ctx)
+		if err != nil {
+			return fmt.Errorf("Up: %w", err)
+		}
+	}
+
+	// Workers run until the gRPC server has stopped, so that RPCs drained during shutdown can still use them
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	startWorker := func(run func(ctx context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run(workerCtx)
+		}()
+	}
+	defer func() {
+		stopWorkers()
+		workers.Wait()
+	}()
+
+	pgx := repository.NewPsqlConnection(pool)
+	watcher := repository.NewBalanceWatcher(pool)
+	startWorker(watcher.Run)
+	var publisher events.EventPublisher = events.LogPublisher{}
+	if cfg.KafkaRESTAddr != "" {
+		publisher = events.NewKafkaRESTPublisher(&http.Client{Timeout: 10 * time.Second}, cfg.KafkaRESTAddr, cfg.EventTopic)
+	}
+	startWorker(events.NewRelay(pgx, publisher).Run)
+	startWorker(func(ctx context.Context) {
+		events.RunOutboxRetention(ctx, pgx, cfg.OutboxRetention)
+	})
+	startWorker(func(ctx context.Context) {
+		handlers.RunIdempotencyKeyRetention(ctx, pgx, cfg.IdempotencyKeyRetention)
+	})
+	srv := service.NewBalanceService(pgx, watcher)
+	hndl := handlers.NewBalancehandler(srv, validator.New())
+
+	rpcMetrics := handlers.NewRPCMetrics()
+	registry := prometheus.NewRegistry()
+	for _, collector := range []prometheus.Collector{rpcMetrics, repository.NewPoolCollector(pool), pgx} {
+		err = registry.Register(collector)
+		if err != nil {
+			return fmt.Errorf("Register: %w", err)
+		}
+	}
+	mux := http.NewServeMux()
+	// a collector that fails is logged and left out of the response instead of failing the scrape
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{ErrorLog: metricsErrorLog{}, ErrorHandling: promhttp.ContinueOnError}))
+	stopMetrics, err := serveHTTP(&http.Server{Addr: cfg.MetricsAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}, cfg.ShutdownTimeout)
+	if err != nil {
+		return fmt.Errorf("serveHTTP: %w", err)
+	}
+	defer stopMetrics()
+
+	tlsConfig, err := a.tlsConfig(startWorker)
+	if err != nil {
+		return err
+	}
+	// Streams are canceled as soon as shutdown begins, they would otherwise hold up the graceful stop
+	streamCtx, stopStreams := context.WithCancel(context.Background())
+	defer stopStreams()
+	unaryInterceptors, streamInterceptors, err := a.interceptors(streamCtx, pgx, rpcMetrics)
+	if err != nil {
+		return err
+	}
+	serverOptions := []grpc.ServerOption{
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+	}
+	if tlsConfig != nil {
+		serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	server := grpc.NewServer(serverOptions...)
+	proto.RegisterBalanceServiceServer(server, hndl)
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	monitor := handlers.NewHealthMonitor(pool, healthServer)
+	startWorker(func(ctx context.Context) {
+		monitor.Run(ctx, cfg.HealthCheckInterval)
+	})
+	if cfg.ReflectionEnabled {
+		reflection.Register(server)
+	}
+
+	if cfg.HTTPAddr != "" {
+		stopGateway, err := serveHTTP(&http.Server{
+			Addr:              cfg.HTTPAddr,
+			Handler:           gateway.NewGateway(hndl, unaryInterceptors...),
+			TLSConfig:         tlsConfig,
+			ReadHeaderTimeout: 10 * time.Second,
+		}, cfg.ShutdownTimeout)
+		if err != nil {
+			return fmt.Errorf("serveHTTP: %w", err)
+		}
+		defer stopGateway()
+	}
+
+	lis, err := net.Listen("tcp", cfg.GRPCAddr)
+	if err != nil {
+		return fmt.Errorf("net.Listen: %w", err)
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(lis)
+	}()
+	logrus.WithFields(logrus.Fields{"addr": cfg.GRPCAddr}).Info("serving gRPC")
+
+	select {
+	case err = <-serveErr:
+		return fmt.Errorf("Serve: %w", err)
+	case <-ctx.Done():
+	}
+	logrus.Info("shutting down")
+	healthServer.Shutdown()
+	stopStreams()
+	gracefulStop(server, cfg.ShutdownTimeout)
+	return nil
+}
+
+// interceptors returns the unary and stream interceptors of the gRPC server, the unary ones are shared with the gateway.
+// Streams are canceled once streamCtx is done.
+func (a *App) interceptors(streamCtx context.Context, store handlers.IdempotencyStore, rpcMetrics *handlers.RPCMetrics) (
+	[]grpc.UnaryServerInterceptor, []grpc.StreamServerInterceptor, error) {
+	cfg := a.cfg
+	unaryInterceptors := []grpc.UnaryServerInterceptor{handlers.NewIdempotencyInterceptor(store, cfg.IdempotencyKeyLease)}
+	streamInterceptors := []grpc.StreamServerInterceptor{handlers.NewShutdownStreamInterceptor(streamCtx)}
+	if cfg.AuthDisabled {
+		logrus.Warn("authentication is disabled")
+	} else {
+		verifier, err := NewTokenVerifier(cfg)
+		if err != nil {
+			return nil, nil, fmt.Errorf("NewTokenVerifier: %w", err)
+		}
+		certRoles, err := auth.NewCertificateRoles(cfg.TLSClientRoles)
+		if err != nil {
+			return nil, nil, fmt.Errorf("NewCertificateRoles: %w", err)
+		}
+		unaryInterceptors = append([]grpc.UnaryServerInterceptor{handlers.NewAuthInterceptor(verifier, certRoles)}, unaryInterceptors...)
+		streamInterceptors = append(streamInterceptors, handlers.NewAuthStreamInterceptor(verifier, certRoles))
+	}
+	unaryInterceptors = append([]grpc.UnaryServerInterceptor{rpcMetrics.UnaryInterceptor()}, unaryInterceptors...)
+	streamInterceptors = append([]grpc.StreamServerInterceptor{rpcMetrics.StreamInterceptor()}, streamInterceptors...)
+	return unaryInterceptors, streamInterceptors, nil
+}
+
+// tlsConfig returns the TLS configuration of the gRPC server and the gateway, or nil when TLS is not configured.
+// The certificate reloader is started with startWorker.
+func (a *App) tlsConfig(startWorker func(run func(ctx context.Context))) (*tls.Config, error) {
+	cfg := a.cfg
+	if cfg.TLSCertFile == "" {
+		logrus.Warn("TLS is not configured, serving plaintext")
+		return nil, nil
+	}
+	reloader, err := auth.NewCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSClientCAFile, cfg.TLSRequireClientCert)
+	if err != nil {
+		return nil, fmt.Errorf("NewCertReloader: %w", err)
+	}
+	startWorker(func(ctx context.Context) {
+		reloader.Run(ctx, cfg.TLSReloadInterval)
+	})
+	return reloader.TLSConfig(), nil
+}
+
+// serveHTTP listens on the address of server and serves it in the background, over TLS when server has a TLS
+// configuration. The returned function shuts the server down, waiting up to timeout for requests in flight.
+func serveHTTP(server *http.Server, timeout time.Duration) (func(), error) {
+	lis, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("net.Listen: %w", err)
+	}
+	if server.TLSConfig != nil {
+		lis = tls.NewListener(lis, server.TLSConfig)
+	}
+	go func() {
+		err := server.Serve(lis)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.WithFields(logrus.Fields{"addr": server.Addr}).Errorf("Serve: %v", err)
+		}
+	}()
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		err := server.Shutdown(ctx)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"addr": server.Addr}).Errorf("Shutdown: %v", err)
+		}
+	}, nil
+}
+
+// gracefulStop stops the server after in-flight RPCs have finished, RPCs still running after timeout are canceled
+func gracefulStop(server *grpc.Server, timeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-stopped:
+	case <-timer.C:
+		logrus.WithFields(logrus.Fields{"timeout": timeout}).Warn("RPCs still running, stopping the server")
+		server.Stop()
+		<-stopped
+	}
+}
+
+// metricsErrorLog logs the errors of metrics scrapes with logrus
+type metricsErrorLog struct{}
+
+// Println logs v as an error
+func (metricsErrorLog) Println(v ...interface{}) {
+	logrus.Error(v...)
+}
@@ -0,0 +1,125 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eugenshima/balance/internal/auth"
+	cfgrtn "github.com/eugenshima/balance/internal/config"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+)
+
+// Delays between the attempts of NewDBPsql to connect, the delay doubles after every failed attempt
+const (
+	connectInitialDelay = 500 * time.Millisecond
+	connectMaxDelay     = 5 * time.Second
+)
+
+// NewDBPsql function provides Connection with PostgreSQL database. The database may still be starting up,
+// so connecting is retried with backoff until it answers a ping or timeout has passed.
+func NewDBPsql(ctx context.Context, env string, timeout time.Duration) (*pgxpool.Pool, error) {
+	// Initialization a connect configuration for a PostgreSQL using pgx driver
+	config, err := pgxpool.ParseConfig(env)
+	if err != nil {
+		return nil, fmt.Errorf("error connection to PostgreSQL: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	delay := connectInitialDelay
+	for attempt := 1; ; attempt++ {
+		// Establishing a new connection to a PostgreSQL database using the pgx driver
+		pool, err := pgxpool.ConnectConfig(ctx, config)
+		if err == nil {
+			err = pool.Ping(ctx)
+			if err == nil {
+				logrus.WithFields(logrus.Fields{"attempt": attempt}).Info("connected to PostgreSQL")
+				return pool, nil
+			}
+			pool.Close()
+		}
+		logrus.WithFields(logrus.Fields{"attempt": attempt, "retryIn": delay}).Warnf("connect to PostgreSQL: %v", err)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("error connection to PostgreSQL after %d attempts: %w", attempt, err)
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > connectMaxDelay {
+			delay = connectMaxDelay
+		}
+	}
+}
+
+// NewTokenVerifier function creates a JWT verifier from the keys in the configuration
+func NewTokenVerifier(cfg *cfgrtn.Config) (*auth.Verifier, error) {
+	var keys []auth.Key
+	if cfg.JWTHMACSecret != "" {
+		keys = append(keys, auth.Key{Key: []byte(cfg.JWTHMACSecret)})
+	}
+	if cfg.JWTPublicKeyFile != "" {
+		key, err := auth.LoadPublicKey(cfg.JWTPublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("LoadPublicKey: %w", err)
+		}
+		keys = append(keys, auth.Key{Key: key})
+	}
+	if cfg.JWTJWKSFile != "" {
+		set, err := auth.LoadJWKS(cfg.JWTJWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("LoadJWKS: %w", err)
+		}
+		keys = append(keys, set...)
+	}
+	if len(keys) == 0 && cfg.TLSClientCAFile == "" {
+		return nil, errors.New("no JWT keys or client CA configured, set JWT_HMAC_SECRET, JWT_PUBLIC_KEY_FILE, JWT_JWKS_FILE or TLS_CLIENT_CA_FILE")
+	}
+	return auth.NewVerifier(cfg.JWTIssuer, cfg.JWTAudience, keys), nil
+}
+
+// NewTracerProvider function installs the global tracer provider and the W3C trace context propagator,
+// spans are exported as configured by TracingExporter. The returned function flushes and stops the exporter.
+func NewTracerProvider(ctx context.Context, cfg *cfgrtn.Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	var exporter sdktrace.SpanExporter
+	switch cfg.TracingExporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		stdout, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, fmt.Errorf("stdouttrace.New: %w", err)
+		}
+		exporter = stdout
+	case "otlp":
+		options := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.OTLPEndpoint)}
+		if cfg.OTLPInsecure {
+			options = append(options, otlptracegrpc.WithInsecure())
+		}
+		otlp, err := otlptracegrpc.New(ctx, options...)
+		if err != nil {
+			return nil, fmt.Errorf("otlptracegrpc.New: %w", err)
+		}
+		exporter = otlp
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q, use none, stdout or otlp", cfg.TracingExporter)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TracingSampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName("balance"))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
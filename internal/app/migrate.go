@@ -0,0 +1,73 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	cfgrtn "github.com/eugenshima/balance/internal/config"
+	"github.com/eugenshima/balance/internal/migrations"
+)
+
+// Migrate runs the migrate subcommand: "up" applies the pending migrations, "down [steps]" reverts the last steps
+// migrations, one by default, and "status" lists every migration with when it was applied. The result goes to out.
+func Migrate(ctx context.Context, cfg *cfgrtn.Config, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up | down [steps] | status")
+	}
+	if args[0] != "up" && args[0] != "down" && args[0] != "status" {
+		return fmt.Errorf("unknown migrate command %q, use up, down or status", args[0])
+	}
+	steps := 1
+	switch {
+	case args[0] == "down" && len(args) == 2:
+		var err error
+		steps, err = strconv.Atoi(args[1])
+		if err != nil || steps <= 0 {
+			return fmt.Errorf("steps must be a positive number, got %q", args[1])
+		}
+	case len(args) > 1:
+		return fmt.Errorf("usage: migrate up | down [steps] | status")
+	}
+	pool, err := NewDBPsql(ctx, cfg.PgxDBAddr, cfg.DBConnectTimeout)
+	if err != nil {
+		return fmt.Errorf("NewDBPsql: %w", err)
+	}
+	defer pool.Close()
+	migrator, err := migrations.NewMigrator(pool, cfg.DBLegacyCurrency)
+	if err != nil {
+		return fmt.Errorf("NewMigrator: %w", err)
+	}
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return fmt.Errorf("Up: %w", err)
+		}
+		for _, migration := range applied {
+			fmt.Fprintf(out, "applied  %04d_%s\n", migration.Version, migration.Name)
+		}
+	case "down":
+		reverted, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return fmt.Errorf("Down: %w", err)
+		}
+		for _, migration := range reverted {
+			fmt.Fprintf(out, "reverted %04d_%s\n", migration.Version, migration.Name)
+		}
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return fmt.Errorf("Status: %w", err)
+		}
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			fmt.Fprintf(out, "%04d_%-40s %s\n", status.Version, status.Name, appliedAt)
+		}
+	}
+	return nil
+}
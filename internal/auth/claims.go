@@ -0,0 +1,55 @@
+// Package auth authenticates the callers of the service
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Roles that may call every RPC for every profile, callers without them are end users
+const (
+	RoleAdmin   = "admin"
+	RoleService = "service"
+)
+
+// Claims struct represents the authenticated caller of a request.
+// ProfileID is the profile an end user owns, it is uuid.Nil for callers that are not end users.
+type Claims struct {
+	Subject   string
+	ProfileID uuid.UUID
+	Roles     []string
+	ExpiresAt time.Time
+}
+
+// HasRole reports whether the caller has any of roles
+func (c *Claims) HasRole(roles ...string) bool {
+	for _, have := range c.Roles {
+		for _, role := range roles {
+			if have == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Privileged reports whether the caller may act on every profile
+func (c *Claims) Privileged() bool {
+	return c.HasRole(RoleAdmin, RoleService)
+}
+
+// claimsKey is the context key of the caller's claims
+type claimsKey struct{}
+
+// NewContext returns a copy of ctx carrying claims
+func NewContext(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// FromContext returns the claims of the caller stored in ctx
+func FromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eugenshima/balance/internal/model"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// signingMethods are the JWS algorithms a token may be signed with, "none" is deliberately missing
+var signingMethods = []string{
+	"HS256", "HS384", "HS512",
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+}
+
+// clockSkew is how far exp and nbf may be off to allow for clocks that are not in sync
+const clockSkew = 30 * time.Second
+
+// errNoKey is returned when no key may verify a token
+var errNoKey = errors.New("no key matches the token")
+
+// Key struct represents a verification key. Key is a []byte secret for HMAC, a *rsa.PublicKey for RSA
+// or a *ecdsa.PublicKey for ECDSA. A token whose header names a kid is only checked against the key with that ID.
+type Key struct {
+	ID  string
+	Key interface{}
+}
+
+// Verifier validates JWTs signed with one of its keys. Tokens must not be expired, and must have the configured
+// issuer and audience when they are set. Parsing and signature verification are left to github.com/golang-jwt/jwt.
+type Verifier struct {
+	keys   []Key
+	parser *jwt.Parser
+}
+
+// NewVerifier constructor for Verifier, an empty issuer or audience is not checked
+func NewVerifier(issuer, audience string, keys []Key) *Verifier {
+	return newVerifier(issuer, audience, keys, time.Now)
+}
+
+// newVerifier creates a Verifier reading the time from now
+func newVerifier(issuer, audience string, keys []Key, now func() time.Time) *Verifier {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(signingMethods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(clockSkew),
+		jwt.WithTimeFunc(now),
+	}
+	if issuer != "" {
+		options = append(options, jwt.WithIssuer(issuer))
+	}
+	if audience != "" {
+		options = append(options, jwt.WithAudience(audience))
+	}
+	return &Verifier{keys: keys, parser: jwt.NewParser(options...)}
+}
+
+// tokenClaims are the registered and private claims read from a token
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	ProfileID string   `json:"profile_id"`
+	Roles     []string `json:"roles"`
+}
+
+// Verify checks the signature and claims of a compact serialized JWT and returns its claims.
+// ProfileID is taken from the profile_id claim, or from sub when it is a UUID and profile_id is missing.
+// Every failure wraps model.ErrUnauthenticated.
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	var raw tokenClaims
+	_, err := v.parser.ParseWithClaims(token, &raw, v.verificationKeys)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", err, model.ErrUnauthenticated)
+	}
+	claims, err := raw.claims()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", err, model.ErrUnauthenticated)
+	}
+	return claims, nil
+}
+
+// verificationKeys returns the keys that may have signed token, the ones with the kid of its header if it names one.
+// A key of the wrong type for the algorithm never verifies a token.
+func (v *Verifier) verificationKeys(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	var set jwt.VerificationKeySet
+	for _, key := range v.keys {
+		if kid == "" || key.ID == kid {
+			set.Keys = append(set.Keys, key.Key)
+		}
+	}
+	if len(set.Keys) == 0 {
+		return nil, errNoKey
+	}
+	return set, nil
+}
+
+// claims converts the claims of a verified token into Claims
+func (raw *tokenClaims) claims() (*Claims, error) {
+	claims := &Claims{Subject: raw.Subject, Roles: raw.Roles, ExpiresAt: raw.ExpiresAt.Time}
+	profileID := raw.ProfileID
+	if profileID == "" {
+		if _, err := uuid.Parse(raw.Subject); err == nil {
+			profileID = raw.Subject
+		}
+	}
+	if profileID != "" {
+		parsed, err := uuid.Parse(profileID)
+		if err != nil {
+			return nil, fmt.Errorf("profile_id: %w", err)
+		}
+		claims.ProfileID = parsed
+	}
+	return claims, nil
+}
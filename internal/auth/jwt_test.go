@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/balance/internal/model"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// signToken signs claims into a compact JWT, key is a []byte secret, *rsa.PrivateKey or *ecdsa.PrivateKey
+func signToken(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(alg), jwt.MapClaims(claims))
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+// validClaims returns claims the verifiers in these tests accept
+func validClaims(profileID uuid.UUID) map[string]interface{} {
+	return map[string]interface{}{
+		"sub": profileID.String(),
+		"iss": "https://issuer.example",
+		"aud": []string{"balance"},
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+// TestVerifySigningAlgorithms tests that tokens signed with HMAC, RSA and ECDSA keys are accepted
+func TestVerifySigningAlgorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	verifier := NewVerifier("https://issuer.example", "balance", []Key{{Key: secret}, {ID: "rsa", Key: &rsaKey.PublicKey}, {ID: "ec", Key: &ecKey.PublicKey}})
+	profileID := uuid.New()
+
+	for _, tc := range []struct {
+		alg string
+		kid string
+		key interface{}
+	}{
+		{"HS256", "", secret},
+		{"HS512", "", secret},
+		{"RS256", "rsa", rsaKey},
+		{"RS384", "", rsaKey},
+		{"ES256", "ec", ecKey},
+	} {
+		claims, err := verifier.Verify(signToken(t, tc.alg, tc.kid, tc.key, validClaims(profileID)))
+		require.NoError(t, err, tc.alg)
+		require.Equal(t, profileID, claims.ProfileID, tc.alg)
+		require.False(t, claims.Privileged(), tc.alg)
+	}
+}
+
+// TestVerifyRejectsInvalidTokens tests that forged, expired and misaddressed tokens are rejected
+func TestVerifyRejectsInvalidTokens(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	verifier := NewVerifier("https://issuer.example", "balance", []Key{{ID: "hmac", Key: secret}, {ID: "rsa", Key: &rsaKey.PublicKey}})
+	profileID := uuid.New()
+	with := func(name string, value interface{}) map[string]interface{} {
+		claims := validClaims(profileID)
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+	payload, err := json.Marshal(validClaims(profileID))
+	require.NoError(t, err)
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+	hmacSigned := signToken(t, "HS256", "", secret, validClaims(profileID))
+	// an HMAC signature presented as RSA must not be checked with the HMAC key
+	switchAlg := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+
+	for name, token := range map[string]string{
+		"malformed":         "not-a-jwt",
+		"alg none":          unsigned,
+		"wrong secret":      signToken(t, "HS256", "", []byte("another secret"), validClaims(profileID)),
+		"unknown kid":       signToken(t, "HS256", "other", secret, validClaims(profileID)),
+		"kid of other key":  signToken(t, "HS256", "rsa", secret, validClaims(profileID)),
+		"expired":           signToken(t, "HS256", "", secret, with("exp", time.Now().Add(-time.Hour).Unix())),
+		"no expiry":         signToken(t, "HS256", "", secret, with("exp", nil)),
+		"not valid yet":     signToken(t, "HS256", "", secret, with("nbf", time.Now().Add(time.Hour).Unix())),
+		"wrong issuer":      signToken(t, "HS256", "", secret, with("iss", "https://evil.example")),
+		"wrong audience":    signToken(t, "HS256", "", secret, with("aud", "ledger")),
+		"invalid profileID": signToken(t, "HS256", "", secret, with("profile_id", "42")),
+		"exp not a number":  signToken(t, "HS256", "", secret, with("exp", "tomorrow")),
+		"alg switched":      switchAlg + hmacSigned[strings.Index(hmacSigned, "."):],
+		"extra segment":     hmacSigned + ".e30",
+		"empty signature":   hmacSigned[:strings.LastIndex(hmacSigned, ".")+1],
+		"bad base64":        "eyJhbGciOiJIUzI1NiJ9.!!!." + base64.RawURLEncoding.EncodeToString([]byte("sig")),
+	} {
+		_, err := verifier.Verify(token)
+		require.ErrorIs(t, err, model.ErrUnauthenticated, name)
+	}
+
+	// an RSA public key must not be usable as an HMAC secret
+	publicKey := &rsaKey.PublicKey
+	_, err = verifier.Verify(signToken(t, "HS256", "rsa", publicKey.N.Bytes(), validClaims(profileID)))
+	require.ErrorIs(t, err, model.ErrUnauthenticated)
+}
+
+// TestVerifyReadsRoles tests that roles are read and a non-UUID subject has no profile
+func TestVerifyReadsRoles(t *testing.T) {
+	secret := []byte("secret")
+	verifier := NewVerifier("", "", []Key{{Key: secret}})
+	token := signToken(t, "HS256", "", secret, map[string]interface{}{
+		"sub": "billing-service", "roles": []string{RoleService}, "aud": "anything", "exp": time.Now().Add(time.Minute).Unix(),
+	})
+	claims, err := verifier.Verify(token)
+	require.NoError(t, err)
+	require.Equal(t, "billing-service", claims.Subject)
+	require.Equal(t, uuid.Nil, claims.ProfileID)
+	require.True(t, claims.Privileged())
+}
+
+// TestLoadJWKS tests that every signing key of a JWKS file verifies its tokens
+func TestLoadJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	encode := func(n *big.Int) string { return base64.RawURLEncoding.EncodeToString(n.Bytes()) }
+	set := map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": encode(rsaKey.N), "e": encode(big.NewInt(int64(rsaKey.E)))},
+		{"kty": "EC", "kid": "ec-1", "crv": "P-384", "x": encode(ecKey.X), "y": encode(ecKey.Y)},
+		{"kty": "oct", "kid": "hmac-1", "k": base64.RawURLEncoding.EncodeToString([]byte("secret"))},
+		{"kty": "RSA", "kid": "enc-1", "use": "enc", "n": encode(rsaKey.N), "e": "AQAB"},
+	}}
+	data, err := json.Marshal(set)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	keys, err := LoadJWKS(path)
+	require.NoError(t, err)
+	require.Len(t, keys, 3)
+	verifier := NewVerifier("", "", keys)
+	profileID := uuid.New()
+	for _, tc := range []struct {
+		alg string
+		kid string
+		key interface{}
+	}{{"RS256", "rsa-1", rsaKey}, {"ES384", "ec-1", ecKey}, {"HS256", "hmac-1", []byte("secret")}} {
+		claims, err := verifier.Verify(signToken(t, tc.alg, tc.kid, tc.key, validClaims(profileID)))
+		require.NoError(t, err, tc.alg)
+		require.Equal(t, profileID, claims.ProfileID)
+	}
+	// ES256 needs a P-256 key, the P-384 key with the kid does not verify it
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, err = verifier.Verify(signToken(t, "ES256", "ec-1", otherKey, validClaims(profileID)))
+	require.ErrorIs(t, err, model.ErrUnauthenticated)
+}
+
+// TestVerifyAllowsClockSkew tests that exp and nbf are checked with a leeway of clockSkew
+func TestVerifyAllowsClockSkew(t *testing.T) {
+	secret := []byte("secret")
+	issued := time.Now()
+	token := signToken(t, "HS256", "", secret, map[string]interface{}{
+		"sub": "billing-service", "nbf": issued.Unix(), "exp": issued.Add(time.Minute).Unix(),
+	})
+	for _, tc := range []struct {
+		now   time.Time
+		valid bool
+	}{
+		{issued.Add(-clockSkew / 2), true},
+		{issued.Add(-2 * clockSkew), false},
+		{issued.Add(time.Minute + clockSkew/2), true},
+		{issued.Add(time.Minute + 2*clockSkew), false},
+	} {
+		verifier := newVerifier("", "", []Key{{Key: secret}}, func() time.Time { return tc.now })
+		_, err := verifier.Verify(token)
+		if tc.valid {
+			require.NoError(t, err, tc.now)
+		} else {
+			require.ErrorIs(t, err, model.ErrUnauthenticated, tc.now)
+		}
+	}
+}
+
+// FuzzVerify tests that arbitrary input never panics and is either accepted with claims or rejected as unauthenticated
+func FuzzVerify(f *testing.F) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	valid := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": uuid.NewString(), "exp": time.Now().Add(time.Hour).Unix()})
+	signed, err := valid.SignedString(secret)
+	require.NoError(f, err)
+	for _, seed := range []string{signed, "", ".", "..", "a.b.c", signed + ".", signed[:len(signed)-2], "eyJhbGciOiJub25lIn0.e30."} {
+		f.Add(seed)
+	}
+	verifier := NewVerifier("", "", []Key{{Key: secret}})
+	f.Fuzz(func(t *testing.T, token string) {
+		claims, err := verifier.Verify(token)
+		if err != nil {
+			require.ErrorIs(t, err, model.ErrUnauthenticated)
+			require.Nil(t, claims)
+			return
+		}
+		require.NotNil(t, claims)
+		require.False(t, claims.ExpiresAt.IsZero())
+	})
+}
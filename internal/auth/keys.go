@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// LoadPublicKey reads an RSA or ECDSA public key from a PEM file holding a PUBLIC KEY, RSA PUBLIC KEY or CERTIFICATE block
+func LoadPublicKey(path string) (interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ReadFile: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM block", path)
+	}
+	var key interface{}
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("%s: unexpected PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("%s: %T keys are not supported", path, key)
+}
+
+// jsonWebKey is a key of a JSON Web Key Set, only the members of RSA, EC and oct keys are read
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+	K       string `json:"k"`
+}
+
+// jwkCurves maps the crv member of EC keys onto curves
+var jwkCurves = map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+
+// LoadJWKS reads the RSA, EC and oct signing keys of a JSON Web Key Set file, keys meant for encryption are skipped
+func LoadJWKS(path string) ([]Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ReadFile: %w", err)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	err = json.Unmarshal(data, &set)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	keys := make([]Key, 0, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use == "enc" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %q: %w", path, jwk.KeyID, err)
+		}
+		keys = append(keys, Key{ID: jwk.KeyID, Key: key})
+	}
+	return keys, nil
+}
+
+// publicKey converts the JWK into a key Verifier accepts
+func (jwk *jsonWebKey) publicKey() (interface{}, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("n: %w", err)
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, fmt.Errorf("e: %w", err)
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("e is too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curve, ok := jwkCurves[jwk.Curve]
+		if !ok {
+			return nil, fmt.Errorf("curve %q is not supported", jwk.Curve)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, fmt.Errorf("x: %w", err)
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, fmt.Errorf("y: %w", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(jwk.K)
+		if err != nil {
+			return nil, fmt.Errorf("k: %w", err)
+		}
+		return secret, nil
+	}
+	return nil, fmt.Errorf("key type %q is not supported", jwk.KeyType)
+}
+
+// decodeBigInt decodes a base64url encoded big-endian unsigned integer
+func decodeBigInt(encoded string) (*big.Int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(decoded), nil
+}
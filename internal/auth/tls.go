@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CertReloader holds the server certificate and the client CA pool read from disk,
+// Run reloads them when one of the files changes so rotated certificates are served without a restart
+type CertReloader struct {
+	certFile          string
+	keyFile           string
+	clientCAFile      string
+	requireClientCert bool
+
+	mu       sync.RWMutex
+	config   *tls.Config
+	modTimes []time.Time
+}
+
+// NewCertReloader constructor for CertReloader, it fails if the files cannot be loaded.
+// Client certificates are verified against clientCAFile when it is set and required if requireClientCert is set.
+func NewCertReloader(certFile, keyFile, clientCAFile string, requireClientCert bool) (*CertReloader, error) {
+	if requireClientCert && clientCAFile == "" {
+		return nil, errors.New("client certificates can only be required with a client CA file")
+	}
+	r := &CertReloader{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile, requireClientCert: requireClientCert}
+	err := r.reload()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns the server TLS configuration, every handshake uses the files loaded last
+func (r *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.config, nil
+		},
+	}
+}
+
+// Run checks the files every interval and reloads them after a change until ctx is done.
+// A change that cannot be loaded, e.g. a certificate written before its key, is logged and retried on the next tick.
+func (r *CertReloader) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		modTimes, err := r.fileModTimes()
+		if err != nil {
+			logrus.Errorf("fileModTimes: %v", err)
+			continue
+		}
+		if r.unchanged(modTimes) {
+			continue
+		}
+		err = r.reload()
+		if err != nil {
+			logrus.Errorf("reload: %v", err)
+			continue
+		}
+		logrus.WithFields(logrus.Fields{"cert": r.certFile}).Info("reloaded TLS certificates")
+	}
+}
+
+// reload reads the files and replaces the served configuration
+func (r *CertReloader) reload() error {
+	modTimes, err := r.fileModTimes()
+	if err != nil {
+		return fmt.Errorf("fileModTimes: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("LoadX509KeyPair: %w", err)
+	}
+	// the configuration replaces the one gRPC set up, so it has to offer HTTP/2 itself,
+	// HTTP/1.1 is offered for the clients of the HTTP gateway
+	config := &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{cert}, NextProtos: []string{"h2", "http/1.1"}}
+	if r.clientCAFile != "" {
+		pem, err := os.ReadFile(r.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("ReadFile: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: no certificates", r.clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if r.requireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.config, r.modTimes = config, modTimes
+	return nil
+}
+
+// fileModTimes returns the modification times of the files in the order certFile, keyFile, clientCAFile
+func (r *CertReloader) fileModTimes() ([]time.Time, error) {
+	files := []string{r.certFile, r.keyFile}
+	if r.clientCAFile != "" {
+		files = append(files, r.clientCAFile)
+	}
+	modTimes := make([]time.Time, 0, len(files))
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+	return modTimes, nil
+}
+
+// unchanged reports whether modTimes are those of the files loaded last
+func (r *CertReloader) unchanged(modTimes []time.Time) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for i := range modTimes {
+		if !modTimes[i].Equal(r.modTimes[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// CertificateRoles maps identities of client certificates to the roles they grant. An identity is the common name
+// of the certificate subject, one of its DNS names or one of its URI names such as a SPIFFE ID.
+type CertificateRoles map[string][]string
+
+// NewCertificateRoles creates CertificateRoles from a comma separated list of identity=roles pairs,
+// the roles of an identity are separated by "|", e.g. "billing-service=service,spiffe://example.org/ops=admin|service".
+// Only the admin and service roles can be granted.
+func NewCertificateRoles(spec string) (CertificateRoles, error) {
+	roles := CertificateRoles{}
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		i := strings.LastIndex(pair, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("%q is not an identity=roles pair", pair)
+		}
+		identity := pair[:i]
+		for _, role := range strings.Split(pair[i+1:], "|") {
+			if role != RoleAdmin && role != RoleService {
+				return nil, fmt.Errorf("identity %q: unknown role %q", identity, role)
+			}
+			roles[identity] = append(roles[identity], role)
+		}
+	}
+	return roles, nil
+}
+
+// Claims returns the claims of a caller authenticated by a verified client certificate. The caller is identified by the
+// common name of the certificate subject and has the roles mapped to any identity of the certificate.
+// A certificate without mapped identities grants no roles, so a client CA shared with other services grants nothing.
+func (r CertificateRoles) Claims(cert *x509.Certificate) *Claims {
+	subject := cert.Subject.CommonName
+	if subject == "" {
+		subject = cert.Subject.String()
+	}
+	identities := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	claims := &Claims{Subject: subject, ExpiresAt: cert.NotAfter}
+	for _, identity := range identities {
+		if identity == "" {
+			continue
+		}
+		for _, role := range r[identity] {
+			if !claims.HasRole(role) {
+				claims.Roles = append(claims.Roles, role)
+			}
+		}
+	}
+	return claims
+}
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testCA issues certificates for the TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCA creates a self-signed CA
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key}
+}
+
+// issue returns a certificate for commonName signed by the CA
+func (ca *testCA) issue(t *testing.T, commonName string, serial int64, usage x509.ExtKeyUsage) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writePEM writes the certificate and key of cert to certFile and keyFile
+func writePEM(t *testing.T, cert tls.Certificate, certFile, keyFile string) {
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600))
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600))
+}
+
+// handshake connects a client with clientCerts to a server using serverConfig and returns the server's connection state
+// and the certificate it served
+func handshake(t *testing.T, ca *testCA, serverConfig *tls.Config, clientCerts []tls.Certificate) (tls.ConnectionState, *x509.Certificate, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	served := make(chan *x509.Certificate, 1)
+	go func() {
+		conn, err := tls.Dial("tcp", lis.Addr().String(), &tls.Config{RootCAs: roots, ServerName: "balance", Certificates: clientCerts})
+		if err != nil {
+			served <- nil
+			return
+		}
+		defer conn.Close()
+		served <- conn.ConnectionState().PeerCertificates[0]
+	}()
+	conn, err := lis.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	server := tls.Server(conn, serverConfig)
+	err = server.Handshake()
+	cert := <-served
+	if err != nil {
+		return tls.ConnectionState{}, nil, err
+	}
+	require.NotNil(t, cert)
+	return server.ConnectionState(), cert, nil
+}
+
+// TestCertReloaderVerifiesAndReloads tests that client certificates are verified and rotated files are served
+func TestCertReloaderVerifiesAndReloads(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	writePEM(t, ca.issue(t, "balance", 2, x509.ExtKeyUsageServerAuth), certFile, keyFile)
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o600))
+
+	_, err := NewCertReloader(certFile, keyFile, "", true)
+	require.Error(t, err)
+	reloader, err := NewCertReloader(certFile, keyFile, caFile, true)
+	require.NoError(t, err)
+	clientCert := ca.issue(t, "billing-service", 3, x509.ExtKeyUsageClientAuth)
+
+	state, served, err := handshake(t, ca, reloader.TLSConfig(), []tls.Certificate{clientCert})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), served.SerialNumber.Int64())
+	require.Len(t, state.VerifiedChains, 1)
+	roles, err := NewCertificateRoles("billing-service=service")
+	require.NoError(t, err)
+	claims := roles.Claims(state.VerifiedChains[0][0])
+	require.Equal(t, "billing-service", claims.Subject)
+	require.True(t, claims.Privileged())
+
+	_, _, err = handshake(t, ca, reloader.TLSConfig(), nil)
+	require.Error(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.Run(ctx, 10*time.Millisecond)
+	writePEM(t, ca.issue(t, "balance", 4, x509.ExtKeyUsageServerAuth), certFile, keyFile)
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, later, later))
+	require.NoError(t, os.Chtimes(keyFile, later, later))
+	require.Eventually(t, func() bool {
+		_, served, err := handshake(t, ca, reloader.TLSConfig(), []tls.Certificate{clientCert})
+		return err == nil && served.SerialNumber.Int64() == 4
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+// TestCertificateRoles tests that certificates get the roles of their mapped identities and none without one
+func TestCertificateRoles(t *testing.T) {
+	spiffe, err := url.Parse("spiffe://example.org/ops")
+	require.NoError(t, err)
+	roles, err := NewCertificateRoles(" billing-service=service, spiffe://example.org/ops=admin|service,ledger.internal=service")
+	require.NoError(t, err)
+
+	for name, test := range map[string]struct {
+		cert  *x509.Certificate
+		roles []string
+	}{
+		"common name": {&x509.Certificate{Subject: pkix.Name{CommonName: "billing-service"}}, []string{RoleService}},
+		"dns name":    {&x509.Certificate{Subject: pkix.Name{CommonName: "ledger"}, DNSNames: []string{"ledger.internal"}}, []string{RoleService}},
+		"uri":         {&x509.Certificate{URIs: []*url.URL{spiffe}}, []string{RoleAdmin, RoleService}},
+		"unmapped":    {&x509.Certificate{Subject: pkix.Name{CommonName: "reporting"}, DNSNames: []string{"reporting.internal"}}, nil},
+	} {
+		claims := roles.Claims(test.cert)
+		require.Equal(t, test.roles, claims.Roles, name)
+	}
+	require.False(t, CertificateRoles(nil).Claims(&x509.Certificate{Subject: pkix.Name{CommonName: "billing-service"}}).Privileged())
+
+	for _, spec := range []string{"billing-service", "=service", "billing-service=root", "billing-service=service|"} {
+		_, err = NewCertificateRoles(spec)
+		require.Error(t, err, spec)
+	}
+}
@@ -0,0 +1,96 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/eugenshima/balance/internal/model"
+)
+
+// Content types of the Kafka REST proxy v2 API
+const (
+	kafkaJSONContentType = "application/vnd.kafka.json.v2+json"
+	kafkaAcceptType      = "application/vnd.kafka.v2+json"
+)
+
+// kafkaErrorBodyLimit is how much of an error response is kept in the returned error
+const kafkaErrorBodyLimit = 1024
+
+// KafkaRESTPublisher publishes events to a Kafka topic through the REST proxy v2 API, which Confluent REST Proxy
+// and Redpanda serve. Events are keyed by profile ID, so the events of a profile stay in one partition and in order.
+type KafkaRESTPublisher struct {
+	client *http.Client
+	url    string
+}
+
+// NewKafkaRESTPublisher constructor for KafkaRESTPublisher, baseURL is the address of the REST proxy
+func NewKafkaRESTPublisher(client *http.Client, baseURL, topic string) *KafkaRESTPublisher {
+	return &KafkaRESTPublisher{client: client, url: strings.TrimRight(baseURL, "/") + "/topics/" + url.PathEscape(topic)}
+}
+
+// kafkaRecord is a record of a produce request, its value is the whole event
+type kafkaRecord struct {
+	Key   string       `json:"key"`
+	Value *model.Event `json:"value"`
+}
+
+// kafkaProduceResponse is the body of a successful produce request, it has one offset per record
+type kafkaProduceResponse struct {
+	Offsets []struct {
+		Partition int32   `json:"partition"`
+		Offset    int64   `json:"offset"`
+		ErrorCode *int    `json:"error_code"`
+		Error     *string `json:"error"`
+	} `json:"offsets"`
+}
+
+// Publish produces events to the topic in a single request and fails if any of them was not stored
+func (p *KafkaRESTPublisher) Publish(ctx context.Context, events []*model.Event) error {
+	records := make([]kafkaRecord, 0, len(events))
+	for _, event := range events {
+		records = append(records, kafkaRecord{Key: event.ProfileID.String(), Value: event})
+	}
+	body, err := json.Marshal(map[string][]kafkaRecord{"records": records})
+	if err != nil {
+		return fmt.Errorf("Marshal: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("NewRequestWithContext: %w", err)
+	}
+	req.Header.Set("Content-Type", kafkaJSONContentType)
+	req.Header.Set("Accept", kafkaAcceptType)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Do: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, kafkaErrorBodyLimit))
+		return fmt.Errorf("produce: %s: %s", resp.Status, message)
+	}
+	var produced kafkaProduceResponse
+	err = json.NewDecoder(resp.Body).Decode(&produced)
+	if err != nil {
+		return fmt.Errorf("Decode: %w", err)
+	}
+	if len(produced.Offsets) != len(events) {
+		return fmt.Errorf("produce: %d offsets for %d records", len(produced.Offsets), len(events))
+	}
+	for i, offset := range produced.Offsets {
+		if offset.ErrorCode != nil {
+			message := ""
+			if offset.Error != nil {
+				message = *offset.Error
+			}
+			return fmt.Errorf("produce event %s: error code %d: %s", events[i].EventID, *offset.ErrorCode, message)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/eugenshima/balance/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// restProxy is a stand-in for a Kafka REST proxy that appends produced records to in-memory topics
+type restProxy struct {
+	mu     sync.Mutex
+	topics map[string][]kafkaRecord
+	// failRecord makes the proxy report an error for the record with this index
+	failRecord int
+}
+
+func (p *restProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, "/topics/") {
+		http.Error(w, `{"error_code":404,"message":"not found"}`, http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != kafkaJSONContentType {
+		http.Error(w, `{"error_code":415,"message":"unsupported"}`, http.StatusUnsupportedMediaType)
+		return
+	}
+	var body struct {
+		Records []struct {
+			Key   string          `json:"key"`
+			Value json.RawMessage `json:"value"`
+		} `json:"records"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	topic := r.URL.Path[len("/topics/"):]
+	offsets := make([]map[string]interface{}, 0, len(body.Records))
+	for i, record := range body.Records {
+		if i == p.failRecord {
+			offsets = append(offsets, map[string]interface{}{"partition": nil, "offset": nil, "error_code": 50003, "error": "timeout"})
+			continue
+		}
+		event := &model.Event{}
+		if err := json.Unmarshal(record.Value, event); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		p.topics[topic] = append(p.topics[topic], kafkaRecord{Key: record.Key, Value: event})
+		offsets = append(offsets, map[string]interface{}{"partition": 0, "offset": len(p.topics[topic]) - 1})
+	}
+	w.Header().Set("Content-Type", kafkaAcceptType)
+	fmt.Fprint(w, mustJSON(map[string]interface{}{"offsets": offsets}))
+}
+
+func mustJSON(v interface{}) string {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return string(encoded)
+}
+
+func TestKafkaRESTPublisher(t *testing.T) {
+	proxy := &restProxy{topics: map[string][]kafkaRecord{}, failRecord: -1}
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+	publisher := NewKafkaRESTPublisher(server.Client(), server.URL+"/", "balance-events")
+
+	profileID := uuid.New()
+	entry := &model.LedgerEntry{ProfileID: profileID, Currency: "USD", Delta: model.MustParseMoney("2.5"), Reason: model.ReasonDeposit}
+	event, err := model.NewEvent(model.EventBalanceChanged, profileID, entry)
+	require.NoError(t, err)
+	require.NoError(t, publisher.Publish(context.Background(), []*model.Event{event}))
+
+	records := proxy.topics["balance-events"]
+	require.Len(t, records, 1)
+	require.Equal(t, profileID.String(), records[0].Key)
+	require.Equal(t, event.EventID, records[0].Value.EventID)
+	require.Equal(t, model.EventBalanceChanged, records[0].Value.Type)
+	received := &model.LedgerEntry{}
+	require.NoError(t, json.Unmarshal(records[0].Value.Payload, received))
+	require.Equal(t, entry.Delta, received.Delta)
+
+	proxy.failRecord = 1
+	require.Error(t, publisher.Publish(context.Background(), []*model.Event{event, event}))
+
+	require.Error(t, NewKafkaRESTPublisher(server.Client(), server.URL+"/v3", "balance-events").
+		Publish(context.Background(), []*model.Event{event}))
+}
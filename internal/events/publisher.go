@@ -0,0 +1,59 @@
+// Package events publishes the domain events written to the outbox
+package events
+
+import (
+	"context"
+	"sync"
+
+	"github.com/eugenshima/balance/internal/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EventPublisher delivers a batch of events in order, a nil error means every event of the batch was delivered.
+// A batch that failed is published again, so implementations may deliver an event more than once.
+type EventPublisher interface {
+	Publish(ctx context.Context, events []*model.Event) error
+}
+
+// LogPublisher writes events to the log, it is used when no broker is configured
+type LogPublisher struct{}
+
+// Publish logs every event
+func (LogPublisher) Publish(_ context.Context, events []*model.Event) error {
+	for _, event := range events {
+		logrus.WithFields(logrus.Fields{
+			"EventID":   event.EventID,
+			"Sequence":  event.Sequence,
+			"Type":      event.Type,
+			"ProfileID": event.ProfileID,
+		}).Infof("event: %s", event.Payload)
+	}
+	return nil
+}
+
+// MemoryPublisher keeps published events in memory, it is meant for tests and local runs
+type MemoryPublisher struct {
+	mu     sync.Mutex
+	events []*model.Event
+}
+
+// NewMemoryPublisher constructor for MemoryPublisher
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+// Publish appends events to the published ones
+func (p *MemoryPublisher) Publish(_ context.Context, events []*model.Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, events...)
+	return nil
+}
+
+// Events returns the events published so far in publishing order
+func (p *MemoryPublisher) Events() []*model.Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*model.Event(nil), p.events...)
+}
@@ -0,0 +1,83 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"github.com/eugenshima/balance/internal/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Defaults of the relay: events are published in batches of relayBatchSize
+// and the outbox is polled every relayInterval once it is drained
+const (
+	relayBatchSize = 100
+	relayInterval  = time.Second
+)
+
+// outboxPruneInterval is how often RunOutboxRetention deletes published events
+const outboxPruneInterval = 10 * time.Minute
+
+// OutboxStore represents the outbox the relay publishes from
+type OutboxStore interface {
+	PublishOutbox(ctx context.Context, limit int, publish func(context.Context, []*model.Event) error) (int, error)
+}
+
+// OutboxPruner represents the outbox published events are deleted from
+type OutboxPruner interface {
+	DeleteOutboxEvents(ctx context.Context, retention time.Duration) (int64, error)
+}
+
+// Relay moves events from the outbox to an EventPublisher, every event is published at least once
+type Relay struct {
+	store     OutboxStore
+	publisher EventPublisher
+	batchSize int
+	interval  time.Duration
+}
+
+// NewRelay constructor for Relay
+func NewRelay(store OutboxStore, publisher EventPublisher) *Relay {
+	return &Relay{store: store, publisher: publisher, batchSize: relayBatchSize, interval: relayInterval}
+}
+
+// Run publishes the outbox until ctx is done. A full batch is followed by the next one right away,
+// otherwise and after a failed batch the relay waits for its interval.
+func (r *Relay) Run(ctx context.Context) {
+	for {
+		published, err := r.store.PublishOutbox(ctx, r.batchSize, r.publisher.Publish)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logrus.Errorf("PublishOutbox: %v", err)
+		} else if published == r.batchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.interval):
+		}
+	}
+}
+
+// RunOutboxRetention deletes the events published more than retention ago until ctx is done
+func RunOutboxRetention(ctx context.Context, pruner OutboxPruner, retention time.Duration) {
+	ticker := time.NewTicker(outboxPruneInterval)
+	defer ticker.Stop()
+	for {
+		deleted, err := pruner.DeleteOutboxEvents(ctx, retention)
+		if err != nil && ctx.Err() == nil {
+			logrus.Errorf("DeleteOutboxEvents: %v", err)
+		} else if deleted > 0 {
+			logrus.WithFields(logrus.Fields{"deleted": deleted}).Info("deleted published outbox events")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
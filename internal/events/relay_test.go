@@ -0,0 +1,103 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/balance/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryOutbox is an OutboxStore that keeps events until a publish of them succeeds
+type memoryOutbox struct {
+	mu     sync.Mutex
+	events []*model.Event
+}
+
+func (o *memoryOutbox) PublishOutbox(ctx context.Context, limit int, publish func(context.Context, []*model.Event) error) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	batch := o.events
+	if len(batch) > limit {
+		batch = batch[:limit]
+	}
+	if len(batch) == 0 {
+		return 0, nil
+	}
+	err := publish(ctx, batch)
+	if err != nil {
+		return 0, err
+	}
+	o.events = o.events[len(batch):]
+	return len(batch), nil
+}
+
+func (o *memoryOutbox) pending() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.events)
+}
+
+// outboxPrunerFunc is an OutboxPruner calling the function
+type outboxPrunerFunc func(ctx context.Context, retention time.Duration) (int64, error)
+
+func (f outboxPrunerFunc) DeleteOutboxEvents(ctx context.Context, retention time.Duration) (int64, error) {
+	return f(ctx, retention)
+}
+
+// flakyPublisher fails its first failures batches and then publishes to a MemoryPublisher
+type flakyPublisher struct {
+	*MemoryPublisher
+	mu       sync.Mutex
+	failures int
+}
+
+func (p *flakyPublisher) Publish(ctx context.Context, events []*model.Event) error {
+	p.mu.Lock()
+	if p.failures > 0 {
+		p.failures--
+		p.mu.Unlock()
+		return errors.New("broker unavailable")
+	}
+	p.mu.Unlock()
+	return p.MemoryPublisher.Publish(ctx, events)
+}
+
+func TestRelayPublishesEveryEventInOrder(t *testing.T) {
+	outbox := &memoryOutbox{}
+	for i := 1; i <= 5; i++ {
+		event, err := model.NewEvent(model.EventBalanceChanged, uuid.New(), map[string]int{"i": i})
+		require.NoError(t, err)
+		event.Sequence = int64(i)
+		outbox.events = append(outbox.events, event)
+	}
+	publisher := &flakyPublisher{MemoryPublisher: NewMemoryPublisher(), failures: 1}
+	relay := NewRelay(outbox, publisher)
+	relay.batchSize, relay.interval = 2, 10*time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go relay.Run(ctx)
+
+	require.Eventually(t, func() bool { return outbox.pending() == 0 }, 5*time.Second, 10*time.Millisecond)
+	published := publisher.Events()
+	require.Len(t, published, 5)
+	for i, event := range published {
+		require.Equal(t, int64(i+1), event.Sequence)
+	}
+}
+
+func TestRunOutboxRetention(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var retentions []time.Duration
+	RunOutboxRetention(ctx, outboxPrunerFunc(func(_ context.Context, retention time.Duration) (int64, error) {
+		retentions = append(retentions, retention)
+		cancel()
+		return 2, nil
+	}), 7*24*time.Hour)
+	require.Equal(t, []time.Duration{7 * 24 * time.Hour}, retentions)
+}
@@ -0,0 +1,97 @@
+package gateway
+
+import (
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+var (
+	// marshalOptions writes every field, so clients see zero amounts and statuses instead of missing keys
+	marshalOptions = protojson.MarshalOptions{EmitUnpopulated: true}
+	// unmarshalOptions rejects unknown fields, a misspelled field fails instead of being ignored
+	unmarshalOptions = protojson.UnmarshalOptions{}
+)
+
+// httpStatuses maps gRPC codes onto HTTP status codes, codes that are missing map to 500
+var httpStatuses = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.Canceled:           499,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+}
+
+// httpStatus returns the HTTP status code of a gRPC code
+func httpStatus(code codes.Code) int {
+	if httpCode, ok := httpStatuses[code]; ok {
+		return httpCode
+	}
+	return http.StatusInternalServerError
+}
+
+// writeResponse writes resp as JSON with httpCode, or err if the call failed. A nil resp writes no body.
+func writeResponse(w http.ResponseWriter, httpCode int, resp interface{}, err error) {
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if resp == nil {
+		w.WriteHeader(httpCode)
+		return
+	}
+	writeJSON(w, httpCode, resp.(protobuf.Message))
+}
+
+// writeError writes the gRPC status of err as a JSON google.rpc.Status with the HTTP status code of its code,
+// the body carries the same code, message and details a gRPC client gets
+func writeError(w http.ResponseWriter, err error) {
+	writeErrorWithCode(w, httpStatus(status.Code(err)), err)
+}
+
+// writeErrorWithCode writes the gRPC status of err with httpCode
+func writeErrorWithCode(w http.ResponseWriter, httpCode int, err error) {
+	writeJSON(w, httpCode, status.Convert(err).Proto())
+}
+
+// writeJSON writes msg as JSON with httpCode
+func writeJSON(w http.ResponseWriter, httpCode int, msg protobuf.Message) {
+	body, err := marshalOptions.Marshal(msg)
+	if err != nil {
+		logrus.Errorf("Marshal: %v", err)
+		http.Error(w, `{"code":13,"message":"internal error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpCode)
+	_, err = w.Write(body)
+	if err != nil {
+		logrus.Errorf("Write: %v", err)
+	}
+}
+
+// invalidParameter returns an INVALID_ARGUMENT status error with a BadRequest detail naming the parameter
+func invalidParameter(name string, err error) error {
+	st, detailErr := status.New(codes.InvalidArgument, err.Error()).WithDetails(&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: name, Description: err.Error()}},
+	})
+	if detailErr != nil {
+		logrus.Errorf("WithDetails: %v", detailErr)
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return st.Err()
+}
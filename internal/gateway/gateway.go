@@ -0,0 +1,292 @@
+// Package gateway serves the balance operations as an HTTP/JSON API for clients that can't speak gRPC
+package gateway
+
+import (
+	"context"
+	_ "embed" // embeds the OpenAPI document
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/eugenshima/balance/internal/model"
+	proto "github.com/eugenshima/balance/proto"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// balancesPath is the collection of balances, a single balance is at balancesPath/{ProfileID}/{Currency}
+const balancesPath = "/v1/balances"
+
+// maxBodySize bounds the size of request bodies
+const maxBodySize = 1 << 20
+
+// forwardedHeaders are the HTTP headers passed on to the gRPC handlers as incoming metadata
+var forwardedHeaders = []string{"authorization", "idempotency-key"}
+
+// tracer creates the server spans of gateway calls, which the gRPC stats handler creates for gRPC requests
+var tracer = otel.Tracer("github.com/eugenshima/balance/internal/gateway")
+
+// OpenAPI is the OpenAPI document of the gateway, it is served at /openapi.json
+//
+//go:embed openapi.json
+var OpenAPI []byte
+
+// Gateway translates HTTP/JSON requests into calls of the gRPC handlers. Calls go through the same unary
+// interceptors as gRPC requests, so authentication, idempotency and metrics apply to both.
+type Gateway struct {
+	server       proto.BalanceServiceServer
+	interceptors []grpc.UnaryServerInterceptor
+}
+
+// NewGateway constructor for Gateway, interceptors are called in order like grpc.ChainUnaryInterceptor
+func NewGateway(server proto.BalanceServiceServer, interceptors ...grpc.UnaryServerInterceptor) *Gateway {
+	return &Gateway{server: server, interceptors: interceptors}
+}
+
+// ServeHTTP routes a request to the gRPC handler of the operation
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/openapi.json" && r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(OpenAPI)
+		return
+	}
+	rest, ok := cutPath(r.URL.Path, balancesPath)
+	if !ok {
+		writeError(w, status.Errorf(codes.NotFound, "no route for %s", r.URL.Path))
+		return
+	}
+	if rest == "" {
+		switch r.Method {
+		case http.MethodGet:
+			g.listBalances(w, r)
+		case http.MethodPost:
+			g.createBalance(w, r)
+		default:
+			methodNotAllowed(w, r, http.MethodGet, http.MethodPost)
+		}
+		return
+	}
+	profileID, currency, ok := balanceKey(rest)
+	if !ok {
+		writeError(w, status.Errorf(codes.NotFound, "no route for %s", r.URL.Path))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		g.getBalance(w, r, profileID, currency)
+	case http.MethodPut:
+		g.updateBalance(w, r, profileID, currency)
+	case http.MethodDelete:
+		g.deleteBalance(w, r, profileID, currency)
+	default:
+		methodNotAllowed(w, r, http.MethodGet, http.MethodPut, http.MethodDelete)
+	}
+}
+
+// getBalance serves GET /v1/balances/{ProfileID}/{Currency} with GetUserByID
+func (g *Gateway) getBalance(w http.ResponseWriter, r *http.Request, profileID, currency string) {
+	req := &proto.UserGetByIDRequest{ProfileID: profileID, Currency: currency}
+	resp, err := g.invoke(r, "GetUserByID", req, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return g.server.GetUserByID(ctx, req.(*proto.UserGetByIDRequest))
+	})
+	writeResponse(w, http.StatusOK, resp, err)
+}
+
+// listBalances serves GET /v1/balances with GetAllUserBalances, the query parameters are the request fields
+func (g *Gateway) listBalances(w http.ResponseWriter, r *http.Request) {
+	req, err := listRequest(r.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	resp, err := g.invoke(r, "GetAllUserBalances", req, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return g.server.GetAllUserBalances(ctx, req.(*proto.GetAllBalanceRequest))
+	})
+	writeResponse(w, http.StatusOK, resp, err)
+}
+
+// createBalance serves POST /v1/balances with CreateUserBalance, the body is the Balance to create
+func (g *Gateway) createBalance(w http.ResponseWriter, r *http.Request) {
+	balance := &proto.Balance{}
+	err := readBody(r, balance)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	req := &proto.CreateBalanceRequest{Balance: balance}
+	resp, err := g.invoke(r, "CreateUserBalance", req, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return g.server.CreateUserBalance(ctx, req.(*proto.CreateBalanceRequest))
+	})
+	writeResponse(w, http.StatusCreated, resp, err)
+}
+
+// updateBalance serves PUT /v1/balances/{ProfileID}/{Currency} with UpdateUserBalance, the body is the new Balance
+// and the path decides which balance is updated
+func (g *Gateway) updateBalance(w http.ResponseWriter, r *http.Request, profileID, currency string) {
+	balance := &proto.Balance{}
+	err := readBody(r, balance)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	balance.ProfileID = profileID
+	balance.Currency = currency
+	req := &proto.UserUpdateRequest{Balance: balance}
+	resp, err := g.invoke(r, "UpdateUserBalance", req, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return g.server.UpdateUserBalance(ctx, req.(*proto.UserUpdateRequest))
+	})
+	writeResponse(w, http.StatusOK, resp, err)
+}
+
+// deleteBalance serves DELETE /v1/balances/{ProfileID}/{Currency} with DeleteUserBalance, which closes the balance
+func (g *Gateway) deleteBalance(w http.ResponseWriter, r *http.Request, profileID, currency string) {
+	req := &proto.DeleteBalanceRequest{ProfileID: profileID, Currency: currency}
+	_, err := g.invoke(r, "DeleteUserBalance", req, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return g.server.DeleteUserBalance(ctx, req.(*proto.DeleteBalanceRequest))
+	})
+	writeResponse(w, http.StatusNoContent, nil, err)
+}
+
+// invoke calls handler with req through the interceptors as the gRPC method /BalanceService/method,
+// the forwarded headers of r become the incoming metadata of the call and the client of r its peer. The call runs in
+// a server span that continues the trace of the W3C trace context headers of r.
+func (g *Gateway) invoke(r *http.Request, method string, req interface{}, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	service := proto.BalanceService_ServiceDesc.ServiceName
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, service+"/"+method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+		semconv.RPCSystemGRPC, semconv.RPCService(service), semconv.RPCMethod(method),
+		semconv.HTTPRequestMethodKey.String(r.Method), semconv.URLPath(r.URL.Path)))
+	defer func() {
+		span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(status.Code(err))))
+		if err != nil {
+			span.SetStatus(otelcodes.Error, status.Convert(err).Message())
+		}
+		span.End()
+	}()
+	md := metadata.MD{}
+	for _, name := range forwardedHeaders {
+		if values := r.Header.Values(name); len(values) > 0 {
+			md.Set(name, values...)
+		}
+	}
+	ctx = metadata.NewIncomingContext(ctx, md)
+	// the TLS state lets the handlers identify callers by their verified client certificate, as on gRPC connections
+	caller := &peer.Peer{}
+	caller.Addr, _ = net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if r.TLS != nil {
+		caller.AuthInfo = credentials.TLSInfo{State: *r.TLS}
+	}
+	ctx = peer.NewContext(ctx, caller)
+	info := &grpc.UnaryServerInfo{Server: g.server, FullMethod: "/" + service + "/" + method}
+	for i := len(g.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := g.interceptors[i], handler
+		handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+			return interceptor(ctx, req, info, next)
+		}
+	}
+	return handler(ctx, req)
+}
+
+// listRequest builds a GetAllBalanceRequest from the query parameters pageSize, pageToken, sort, currency,
+// profileIDs, minBalance and maxBalance. Amounts are decimal strings and sort is a BalanceSort name.
+func listRequest(query url.Values) (*proto.GetAllBalanceRequest, error) {
+	req := &proto.GetAllBalanceRequest{
+		PageToken:  query.Get("pageToken"),
+		Currency:   query.Get("currency"),
+		ProfileIDs: query["profileIDs"],
+	}
+	if value := query.Get("pageSize"); value != "" {
+		pageSize, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return nil, invalidParameter("pageSize", err)
+		}
+		req.PageSize = int32(pageSize)
+	}
+	if value := query.Get("sort"); value != "" {
+		sort, ok := proto.BalanceSort_value[value]
+		if !ok {
+			return nil, invalidParameter("sort", fmt.Errorf("unknown sort %q", value))
+		}
+		req.Sort = proto.BalanceSort(sort)
+	}
+	var err error
+	req.MinBalance, err = moneyParameter(query, "minBalance")
+	if err != nil {
+		return nil, err
+	}
+	req.MaxBalance, err = moneyParameter(query, "maxBalance")
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// moneyParameter parses the decimal amount in the query parameter name, it returns nil when the parameter is absent
+func moneyParameter(query url.Values, name string) (*proto.Money, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	amount, err := model.ParseMoney(value)
+	if err != nil {
+		return nil, invalidParameter(name, err)
+	}
+	return &proto.Money{Units: amount.Units(), Nanos: amount.Nanos()}, nil
+}
+
+// readBody decodes the JSON body of r into msg
+func readBody(r *http.Request, msg protobuf.Message) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "read body: %v", err)
+	}
+	if len(body) > maxBodySize {
+		return status.Errorf(codes.InvalidArgument, "body exceeds %d bytes", maxBodySize)
+	}
+	err = unmarshalOptions.Unmarshal(body, msg)
+	if err != nil {
+		return invalidParameter("body", err)
+	}
+	return nil
+}
+
+// cutPath returns the part of path after prefix and whether path is prefix or below it
+func cutPath(path, prefix string) (string, bool) {
+	if path == prefix {
+		return "", true
+	}
+	rest, ok := strings.CutPrefix(path, prefix+"/")
+	return rest, ok
+}
+
+// balanceKey splits the {ProfileID}/{Currency} path of a single balance
+func balanceKey(rest string) (profileID, currency string, ok bool) {
+	parts := strings.Split(rest, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// methodNotAllowed rejects a request whose method the path doesn't support
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	logrus.WithFields(logrus.Fields{"method": r.Method, "path": r.URL.Path}).Warn("method not allowed")
+	writeErrorWithCode(w, http.StatusMethodNotAllowed, status.Errorf(codes.Unimplemented, "method %s not allowed on %s", r.Method, r.URL.Path))
+}
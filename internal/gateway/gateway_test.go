@@ -0,0 +1,246 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/eugenshima/balance/internal/handlers"
+	"github.com/eugenshima/balance/internal/handlers/mocks"
+	"github.com/eugenshima/balance/internal/model"
+	proto "github.com/eugenshima/balance/proto"
+
+	"github.com/go-playground/validator"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// newTestGateway returns a gateway serving srv through interceptors
+func newTestGateway(srv handlers.BalanceService, interceptors ...grpc.UnaryServerInterceptor) *Gateway {
+	return NewGateway(handlers.NewBalancehandler(srv, validator.New()), interceptors...)
+}
+
+// serve sends a request to gateway and returns the response
+func serve(gateway *Gateway, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	recorder := httptest.NewRecorder()
+	gateway.ServeHTTP(recorder, req)
+	return recorder
+}
+
+// TestGatewayGetBalance tests that a balance is returned as proto3 JSON
+func TestGatewayGetBalance(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	profileID := uuid.New()
+	srv.On("GetUserByID", mock.Anything, profileID, model.Currency("USD")).
+		Return(&model.Balance{ProfileID: profileID, Currency: "USD", Balance: model.MustParseMoney("12.5"), Version: 3}, nil).Once()
+
+	resp := serve(newTestGateway(srv), http.MethodGet, "/v1/balances/"+profileID.String()+"/USD", "")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "application/json", resp.Header().Get("Content-Type"))
+	var body struct {
+		Balance struct {
+			ProfileID string
+			Currency  string
+			Version   string
+			Balance   struct {
+				Units string `json:"units"`
+				Nanos int32  `json:"nanos"`
+			}
+		} `json:"balance"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+	require.Equal(t, profileID.String(), body.Balance.ProfileID)
+	require.Equal(t, "3", body.Balance.Version)
+	require.Equal(t, "12", body.Balance.Balance.Units)
+	require.Equal(t, int32(500000000), body.Balance.Balance.Nanos)
+}
+
+// TestGatewayErrorsMirrorStatus tests that failures carry the HTTP status of their gRPC code and the gRPC status as body
+func TestGatewayErrorsMirrorStatus(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	srv.On("GetUserByID", mock.Anything, mock.Anything, model.Currency("USD")).Return(nil, model.ErrNotFound).Once()
+	gateway := newTestGateway(srv)
+
+	resp := serve(gateway, http.MethodGet, "/v1/balances/"+uuid.New().String()+"/USD", "")
+	require.Equal(t, http.StatusNotFound, resp.Code)
+	var status struct {
+		Code    int
+		Message string
+		Details []map[string]interface{}
+	}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &status))
+	require.Equal(t, 5, status.Code)
+	require.Len(t, status.Details, 1)
+	require.Equal(t, "type.googleapis.com/google.rpc.ErrorInfo", status.Details[0]["@type"])
+	require.Equal(t, "NOT_FOUND", status.Details[0]["reason"])
+
+	resp = serve(gateway, http.MethodGet, "/v1/balances/not-a-uuid/USD", "")
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	resp = serve(gateway, http.MethodGet, "/v1/balances?sort=BY_MOOD", "")
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	require.Contains(t, resp.Body.String(), "google.rpc.BadRequest")
+	resp = serve(gateway, http.MethodPost, "/v1/balances", `{"ProfileID": 7}`)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	resp = serve(gateway, http.MethodPatch, "/v1/balances/"+uuid.New().String()+"/USD", "")
+	require.Equal(t, http.StatusMethodNotAllowed, resp.Code)
+	require.Equal(t, "GET, PUT, DELETE", resp.Header().Get("Allow"))
+	resp = serve(gateway, http.MethodGet, "/v1/holds", "")
+	require.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+// TestGatewayCallsInterceptors tests that calls go through the interceptors with the headers as metadata
+func TestGatewayCallsInterceptors(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	profileID := uuid.New()
+	srv.On("CreateBalance", mock.Anything, mock.MatchedBy(func(b *model.Balance) bool {
+		return b.ProfileID == profileID && b.Currency == "EUR"
+	})).Return(nil).Once()
+	var methods []string
+	var md metadata.MD
+	interceptor := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		methods = append(methods, info.FullMethod)
+		md, _ = metadata.FromIncomingContext(ctx)
+		return handler(ctx, req)
+	}
+
+	resp := serve(newTestGateway(srv, interceptor), http.MethodPost, "/v1/balances",
+		`{"ProfileID": "`+profileID.String()+`", "Currency": "EUR", "Balance": {"units": "10"}}`,
+		"Authorization", "Bearer token", "Idempotency-Key", "create-1", "X-Other", "dropped")
+	require.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+	require.Equal(t, []string{"/BalanceService/CreateUserBalance"}, methods)
+	require.Equal(t, []string{"Bearer token"}, md.Get("authorization"))
+	require.Equal(t, []string{"create-1"}, md.Get("idempotency-key"))
+	require.Empty(t, md.Get("x-other"))
+}
+
+// TestGatewayTracing tests that calls run in a server span that continues the trace of the traceparent header, and
+// that failed calls mark their span as an error
+func TestGatewayTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	srv := mocks.NewBalanceService(t)
+	profileID := uuid.New()
+	var serviceSpan trace.SpanContext
+	srv.On("GetUserByID", mock.Anything, profileID, model.Currency("USD")).Run(func(args mock.Arguments) {
+		serviceSpan = trace.SpanContextFromContext(args.Get(0).(context.Context))
+	}).Return(&model.Balance{ProfileID: profileID, Currency: "USD"}, nil).Once()
+	srv.On("GetUserByID", mock.Anything, profileID, model.Currency("EUR")).Return(nil, model.ErrNotFound).Once()
+	gateway := newTestGateway(srv)
+
+	resp := serve(gateway, http.MethodGet, "/v1/balances/"+profileID.String()+"/USD", "",
+		"Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	resp = serve(gateway, http.MethodGet, "/v1/balances/"+profileID.String()+"/EUR", "")
+	require.Equal(t, http.StatusNotFound, resp.Code, resp.Body.String())
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	require.Equal(t, "BalanceService/GetUserByID", spans[0].Name())
+	require.Equal(t, trace.SpanKindServer, spans[0].SpanKind())
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext().TraceID().String())
+	require.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+	require.True(t, spans[0].Parent().IsRemote())
+	require.Equal(t, spans[0].SpanContext().SpanID(), serviceSpan.SpanID())
+	require.Equal(t, otelcodes.Unset, spans[0].Status().Code)
+	require.False(t, spans[1].Parent().IsValid())
+	require.Equal(t, otelcodes.Error, spans[1].Status().Code)
+}
+
+// TestGatewayListAndClose tests the query parameters of listing and closing a balance with DELETE
+func TestGatewayListAndClose(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	profileID := uuid.New()
+	srv.On("GetAllBalances", mock.Anything, mock.MatchedBy(func(f model.BalanceFilter) bool {
+		return f.Limit == 2 && f.Currency == "USD" && f.Sort == model.SortByBalanceDesc &&
+			f.MinBalance != nil && f.MinBalance.Cmp(model.MustParseMoney("1.5")) == 0
+	})).Return([]*model.Balance{{ProfileID: profileID, Currency: "USD"}}, nil, nil).Once()
+	srv.On("CloseBalance", mock.Anything, mock.MatchedBy(func(c *model.StatusChange) bool {
+		return c.ProfileID == profileID && c.Currency == "USD"
+	})).Return(&model.Balance{ProfileID: profileID}, nil).Once()
+	gateway := newTestGateway(srv)
+
+	resp := serve(gateway, http.MethodGet, "/v1/balances?pageSize=2&currency=USD&sort=BALANCE_SORT_BALANCE_DESC&minBalance=1.5", "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	require.Contains(t, resp.Body.String(), profileID.String())
+
+	resp = serve(gateway, http.MethodDelete, "/v1/balances/"+profileID.String()+"/USD", "", "Authorization", "Bearer token")
+	require.Equal(t, http.StatusNoContent, resp.Code, resp.Body.String())
+	require.Empty(t, resp.Body.String())
+}
+
+// TestOpenAPIMatchesProto tests that the schemas of the OpenAPI document list the JSON names of the fields and values
+// of the proto messages and enums they are named after, and that every operation is a BalanceService method
+func TestOpenAPIMatchesProto(t *testing.T) {
+	var document struct {
+		Paths      map[string]map[string]json.RawMessage
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage
+				Enum       []string
+			}
+		}
+	}
+	require.NoError(t, json.Unmarshal(OpenAPI, &document))
+	file := proto.File_balance_proto
+	checked := 0
+	for name, schema := range document.Components.Schemas {
+		if message := file.Messages().ByName(protoreflect.Name(name)); message != nil {
+			var fields []string
+			for i := 0; i < message.Fields().Len(); i++ {
+				fields = append(fields, message.Fields().Get(i).JSONName())
+			}
+			require.ElementsMatch(t, fields, keys(schema.Properties), name)
+			checked++
+		}
+		if enum := file.Enums().ByName(protoreflect.Name(name)); enum != nil {
+			var values []string
+			for i := 0; i < enum.Values().Len(); i++ {
+				values = append(values, string(enum.Values().Get(i).Name()))
+			}
+			require.Equal(t, values, schema.Enum, name)
+			checked++
+		}
+	}
+	require.Equal(t, 8, checked)
+
+	methods := file.Services().ByName("BalanceService").Methods()
+	for path, operations := range document.Paths {
+		for verb, raw := range operations {
+			if verb == "parameters" {
+				continue
+			}
+			var operation struct{ OperationID string }
+			require.NoError(t, json.Unmarshal(raw, &operation))
+			require.NotNil(t, methods.ByName(protoreflect.Name(operation.OperationID)), "%s %s", verb, path)
+		}
+	}
+}
+
+// keys returns the sorted keys of m
+func keys(m map[string]json.RawMessage) []string {
+	result := make([]string, 0, len(m))
+	for key := range m {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
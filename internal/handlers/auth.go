@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	"github.com/eugenshima/balance/internal/auth"
+	"github.com/eugenshima/balance/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// AuthorizationHeader is the gRPC metadata key carrying the "Bearer <JWT>" credentials of the caller
+const AuthorizationHeader = "authorization"
+
+// serviceMethodPrefix is the prefix of the methods of BalanceService, methods of other services such as
+// health checks are not authenticated
+const serviceMethodPrefix = "/BalanceService/"
+
+// ownProfileMethods lists the read RPCs end users may call for the profile named in their token.
+// Every other BalanceService RPC, including all mutating ones, requires the admin or service role.
+var ownProfileMethods = map[string]bool{
+	"/BalanceService/GetUserByID":      true,
+	"/BalanceService/ListTransactions": true,
+	"/BalanceService/WatchBalance":     true,
+}
+
+// TokenVerifier interface represents the verification of bearer tokens
+type TokenVerifier interface {
+	Verify(token string) (*auth.Claims, error)
+}
+
+// profileIDGetter is implemented by every request message that targets a single profile
+type profileIDGetter interface {
+	GetProfileID() string
+}
+
+// NewAuthInterceptor creates an interceptor that authenticates the bearer token of every BalanceService RPC,
+// stores the caller's claims in the context with auth.NewContext and authorizes the request.
+// Requests without a token over a connection with a verified client certificate get the roles certRoles maps it to.
+func NewAuthInterceptor(verifier TokenVerifier, certRoles auth.CertificateRoles) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !strings.HasPrefix(info.FullMethod, serviceMethodPrefix) {
+			return handler(ctx, req)
+		}
+		claims, err := authenticate(ctx, verifier, certRoles)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"method": info.FullMethod}).Errorf("authenticate: %v", err)
+			return nil, statusError(fmt.Errorf("authenticate: %w", err))
+		}
+		err = authorize(info.FullMethod, claims, req)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"method": info.FullMethod, "subject": claims.Subject}).Errorf("authorize: %v", err)
+			return nil, statusError(fmt.Errorf("authorize: %w", err))
+		}
+		return handler(auth.NewContext(ctx, claims), req)
+	}
+}
+
+// NewAuthStreamInterceptor creates the streaming counterpart of NewAuthInterceptor. The request of a server-streaming
+// RPC is only known once the handler receives it, so end users are authorized when the first message is received.
+func NewAuthStreamInterceptor(verifier TokenVerifier, certRoles auth.CertificateRoles) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !strings.HasPrefix(info.FullMethod, serviceMethodPrefix) {
+			return handler(srv, ss)
+		}
+		claims, err := authenticate(ss.Context(), verifier, certRoles)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"method": info.FullMethod}).Errorf("authenticate: %v", err)
+			return statusError(fmt.Errorf("authenticate: %w", err))
+		}
+		if !claims.Privileged() && !ownProfileMethods[info.FullMethod] {
+			logrus.WithFields(logrus.Fields{"method": info.FullMethod, "subject": claims.Subject}).Errorf("authorize: %v", model.ErrPermissionDenied)
+			return statusError(fmt.Errorf("authorize: %w", model.ErrPermissionDenied))
+		}
+		return handler(srv, &authorizedStream{ServerStream: ss, ctx: auth.NewContext(ss.Context(), claims), method: info.FullMethod, claims: claims})
+	}
+}
+
+// authorizedStream is a grpc.ServerStream that carries the caller's claims and authorizes the received messages
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	method string
+	claims *auth.Claims
+}
+
+// Context returns the stream context with the caller's claims
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+// RecvMsg receives a request message and rejects it if the caller may not make it
+func (s *authorizedStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err != nil {
+		return err
+	}
+	err = authorize(s.method, s.claims, m)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"method": s.method, "subject": s.claims.Subject}).Errorf("authorize: %v", err)
+		return statusError(fmt.Errorf("authorize: %w", err))
+	}
+	return nil
+}
+
+// authenticate verifies the bearer token in the gRPC metadata. A request without a token is authenticated by
+// the client certificate the TLS handshake verified, if there is one.
+func authenticate(ctx context.Context, verifier TokenVerifier, certRoles auth.CertificateRoles) (*auth.Claims, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(AuthorizationHeader)
+	if len(values) == 0 {
+		if cert := verifiedClientCert(ctx); cert != nil {
+			return certRoles.Claims(cert), nil
+		}
+		return nil, fmt.Errorf("no %s header: %w", AuthorizationHeader, model.ErrUnauthenticated)
+	}
+	scheme, token, found := strings.Cut(values[0], " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, fmt.Errorf("not a bearer token: %w", model.ErrUnauthenticated)
+	}
+	return verifier.Verify(token)
+}
+
+// verifiedClientCert returns the client certificate of the connection if it was verified against the client CAs
+func verifiedClientCert(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return info.State.VerifiedChains[0][0]
+}
+
+// authorize allows privileged callers every request and end users the own profile methods for their own profile
+func authorize(method string, claims *auth.Claims, req interface{}) error {
+	if claims.Privileged() {
+		return nil
+	}
+	if !ownProfileMethods[method] {
+		return fmt.Errorf("%s requires the %s or %s role: %w", method, auth.RoleAdmin, auth.RoleService, model.ErrPermissionDenied)
+	}
+	getter, ok := req.(profileIDGetter)
+	if !ok {
+		return fmt.Errorf("%T has no profile: %w", req, model.ErrPermissionDenied)
+	}
+	profileID, err := uuid.Parse(getter.GetProfileID())
+	if err != nil || claims.ProfileID == uuid.Nil || profileID != claims.ProfileID {
+		return fmt.Errorf("profile %q does not belong to the caller: %w", getter.GetProfileID(), model.ErrPermissionDenied)
+	}
+	return nil
+}
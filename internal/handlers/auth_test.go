@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/balance/internal/auth"
+	"github.com/eugenshima/balance/internal/handlers/mocks"
+	"github.com/eugenshima/balance/internal/model"
+	proto "github.com/eugenshima/balance/proto"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var testJWTSecret = []byte("test-secret")
+
+// newAuthTestClient starts the handler behind the auth interceptors
+func newAuthTestClient(t *testing.T, srv BalanceService) proto.BalanceServiceClient {
+	verifier := auth.NewVerifier("", "", []auth.Key{{Key: testJWTSecret}})
+	return newTestClient(t, srv, grpc.ChainUnaryInterceptor(NewAuthInterceptor(verifier, nil)), grpc.ChainStreamInterceptor(NewAuthStreamInterceptor(verifier, nil)))
+}
+
+// withToken returns a context sending an HS256 token with the subject and roles
+func withToken(t *testing.T, subject string, roles ...string) context.Context {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": subject, "roles": roles, "exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString(testJWTSecret)
+	require.NoError(t, err)
+	return metadata.AppendToOutgoingContext(context.Background(), AuthorizationHeader, "Bearer "+token)
+}
+
+// TestAuthEndUserReadsOwnProfileOnly tests that end users can read their own balance and nothing else
+func TestAuthEndUserReadsOwnProfileOnly(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	client := newAuthTestClient(t, srv)
+	profileID := uuid.New()
+	srv.On("GetUserByID", mock.MatchedBy(func(ctx context.Context) bool {
+		claims, ok := auth.FromContext(ctx)
+		return ok && claims.ProfileID == profileID
+	}), profileID, model.Currency("USD")).Return(&model.Balance{ProfileID: profileID, Currency: "USD"}, nil).Once()
+	ctx := withToken(t, profileID.String())
+
+	_, err := client.GetUserByID(ctx, &proto.UserGetByIDRequest{ProfileID: profileID.String(), Currency: "USD"})
+	require.NoError(t, err)
+
+	_, err = client.GetUserByID(ctx, &proto.UserGetByIDRequest{ProfileID: uuid.New().String(), Currency: "USD"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.DeleteUserBalance(ctx, &proto.DeleteBalanceRequest{ProfileID: profileID.String(), Currency: "USD"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.GetAllUserBalances(ctx, &proto.GetAllBalanceRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+// TestAuthPrivilegedCallerMutates tests that admins and services may call mutating RPCs for any profile
+func TestAuthPrivilegedCallerMutates(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	client := newAuthTestClient(t, srv)
+	profileID := uuid.New()
+	srv.On("CloseBalance", mock.Anything, mock.AnythingOfType("*model.StatusChange")).
+		Return(&model.Balance{ProfileID: profileID, Currency: "USD", Status: model.StatusClosed}, nil).Twice()
+
+	for _, role := range []string{auth.RoleAdmin, auth.RoleService} {
+		_, err := client.DeleteUserBalance(withToken(t, "operator", role), &proto.DeleteBalanceRequest{ProfileID: profileID.String(), Currency: "USD"})
+		require.NoError(t, err, role)
+	}
+}
+
+// TestAuthRejectsMissingOrInvalidTokens tests that requests without valid credentials are unauthenticated
+func TestAuthRejectsMissingOrInvalidTokens(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	client := newAuthTestClient(t, srv)
+	req := &proto.UserGetByIDRequest{ProfileID: uuid.New().String(), Currency: "USD"}
+
+	for _, ctx := range []context.Context{
+		context.Background(),
+		metadata.AppendToOutgoingContext(context.Background(), AuthorizationHeader, "Basic dXNlcjpwYXNz"),
+		metadata.AppendToOutgoingContext(context.Background(), AuthorizationHeader, "Bearer not.a.token"),
+	} {
+		_, err := client.GetUserByID(ctx, req)
+		require.Equal(t, codes.Unauthenticated, status.Code(err))
+	}
+}
+
+// TestAuthWatchBalanceOfOtherProfile tests that end users cannot watch a balance that is not theirs
+func TestAuthWatchBalanceOfOtherProfile(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	client := newAuthTestClient(t, srv)
+	profileID := uuid.New()
+
+	stream, err := client.WatchBalance(withToken(t, profileID.String()), &proto.WatchBalanceRequest{ProfileID: uuid.New().String(), Currency: "USD"})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	streamAll, err := client.StreamAllBalances(withToken(t, profileID.String()), &proto.StreamAllBalancesRequest{})
+	require.NoError(t, err)
+	_, err = streamAll.Recv()
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+// TestAuthClientCertificateIdentity tests that a verified client certificate authenticates a request without a token
+// and only grants the roles its identity is mapped to
+func TestAuthClientCertificateIdentity(t *testing.T) {
+	verifier := auth.NewVerifier("", "", []auth.Key{{Key: testJWTSecret}})
+	certRoles, err := auth.NewCertificateRoles("billing-service=service")
+	require.NoError(t, err)
+	interceptor := NewAuthInterceptor(verifier, certRoles)
+	info := &grpc.UnaryServerInfo{FullMethod: "/BalanceService/DeleteUserBalance"}
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "billing-service"}}
+	var caller *auth.Claims
+	handler := func(ctx context.Context, _ interface{}) (interface{}, error) {
+		caller, _ = auth.FromContext(ctx)
+		return &proto.DeleteBalanceResponse{}, nil
+	}
+
+	verified := peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{
+		State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}},
+	}})
+	_, err = interceptor(verified, &proto.DeleteBalanceRequest{}, info, handler)
+	require.NoError(t, err)
+	require.Equal(t, "billing-service", caller.Subject)
+	require.True(t, caller.HasRole(auth.RoleService))
+
+	unmapped := peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{
+		State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "reporting"}}}}},
+	}})
+	_, err = interceptor(unmapped, &proto.DeleteBalanceRequest{}, info, handler)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	unverified := peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{
+		State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}},
+	}})
+	_, err = interceptor(unverified, &proto.DeleteBalanceRequest{}, info, handler)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
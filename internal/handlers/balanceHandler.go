@@ -2,8 +2,15 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 
 	"github.com/eugenshima/balance/internal/model"
 	proto "github.com/eugenshima/balance/proto"
@@ -11,8 +18,56 @@ import (
 	vld "github.com/go-playground/validator"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// errMissingBalance is returned for UpdateUserBalance and CreateUserBalance requests without a balance
+var errMissingBalance = errors.New("balance is required")
+
+// Page size bounds for paginated RPCs
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// reasonToProto maps ledger reason codes onto the proto enum
+var reasonToProto = map[model.Reason]proto.Reason{
+	model.ReasonOpening:     proto.Reason_REASON_OPENING,
+	model.ReasonAdjustment:  proto.Reason_REASON_ADJUSTMENT,
+	model.ReasonDeposit:     proto.Reason_REASON_DEPOSIT,
+	model.ReasonWithdrawal:  proto.Reason_REASON_WITHDRAWAL,
+	model.ReasonClosing:     proto.Reason_REASON_CLOSING,
+	model.ReasonTransferOut: proto.Reason_REASON_TRANSFER_OUT,
+	model.ReasonTransferIn:  proto.Reason_REASON_TRANSFER_IN,
+	model.ReasonHoldCapture: proto.Reason_REASON_HOLD_CAPTURE,
+}
+
+// balanceSortFromProto maps the proto sort orders onto the model
+var balanceSortFromProto = map[proto.BalanceSort]model.BalanceSort{
+	proto.BalanceSort_BALANCE_SORT_UNSPECIFIED:  model.SortByProfileID,
+	proto.BalanceSort_BALANCE_SORT_PROFILE_ID:   model.SortByProfileID,
+	proto.BalanceSort_BALANCE_SORT_BALANCE_ASC:  model.SortByBalanceAsc,
+	proto.BalanceSort_BALANCE_SORT_BALANCE_DESC: model.SortByBalanceDesc,
+}
+
+// balanceStatusToProto maps balance statuses onto the proto enum
+var balanceStatusToProto = map[model.BalanceStatus]proto.BalanceStatus{
+	model.StatusActive:      proto.BalanceStatus_BALANCE_STATUS_ACTIVE,
+	model.StatusDebitFrozen: proto.BalanceStatus_BALANCE_STATUS_DEBIT_FROZEN,
+	model.StatusFullyFrozen: proto.BalanceStatus_BALANCE_STATUS_FULLY_FROZEN,
+	model.StatusClosed:      proto.BalanceStatus_BALANCE_STATUS_CLOSED,
+}
+
+// deleteBalanceActor is recorded as the actor of closes made through the deprecated DeleteUserBalance RPC
+const deleteBalanceActor = "DeleteUserBalance"
+
+// holdStatusToProto maps hold statuses onto the proto enum
+var holdStatusToProto = map[model.HoldStatus]proto.HoldStatus{
+	model.HoldActive:   proto.HoldStatus_HOLD_STATUS_ACTIVE,
+	model.HoldCaptured: proto.HoldStatus_HOLD_STATUS_CAPTURED,
+	model.HoldReleased: proto.HoldStatus_HOLD_STATUS_RELEASED,
+}
+
 // BalanceHandler struct represents a balance handler
 type BalanceHandler struct {
 	srv BalanceService
@@ -32,11 +87,23 @@ func NewBalancehandler(srv BalanceService, vl *vld.Validate) *BalanceHandler {
 
 // BalanceService interface represents service methods
 type BalanceService interface {
-	GetAllBalances(ctx context.Context) ([]*model.Balance, error)
+	GetAllBalances(ctx context.Context, filter model.BalanceFilter) ([]*model.Balance, *model.BalanceCursor, error)
+	StreamAllBalances(ctx context.Context, fn func(*model.Balance) error) error
+	WatchBalance(ctx context.Context, profileID uuid.UUID, currency model.Currency, afterSequence int64, fn func(*model.LedgerEntry) error) error
 	UpdateBalance(ctx context.Context, user *model.Balance) error
-	GetUserByID(ctx context.Context, userID uuid.UUID) (*model.Balance, error)
+	GetUserByID(ctx context.Context, userID uuid.UUID, currency model.Currency) (*model.Balance, error)
 	CreateBalance(ctx context.Context, user *model.Balance) error
-	DeleteBalance(ctx context.Context, userID uuid.UUID) error
+	FreezeBalance(ctx context.Context, change *model.StatusChange) (*model.Balance, error)
+	UnfreezeBalance(ctx context.Context, change *model.StatusChange) (*model.Balance, error)
+	CloseBalance(ctx context.Context, change *model.StatusChange) (*model.Balance, error)
+	SetCreditLimit(ctx context.Context, profileID uuid.UUID, currency model.Currency, creditLimit model.Money) (*model.Balance, error)
+	Deposit(ctx context.Context, profileID uuid.UUID, currency model.Currency, amount model.Money, reference string) (*model.Balance, error)
+	Withdraw(ctx context.Context, profileID uuid.UUID, currency model.Currency, amount model.Money, reference string) (*model.Balance, error)
+	ListTransactions(ctx context.Context, filter model.LedgerFilter) ([]*model.LedgerEntry, int64, error)
+	Transfer(ctx context.Context, transfer *model.Transfer) (*model.Balance, *model.Balance, error)
+	CreateHold(ctx context.Context, hold *model.Hold) error
+	CaptureHold(ctx context.Context, holdID uuid.UUID, amount model.Money) (*model.Hold, *model.Balance, error)
+	ReleaseHold(ctx context.Context, holdID uuid.UUID) (*model.Hold, error)
 }
 
 // CustomIDValidaion func validates your variables
@@ -54,112 +121,742 @@ func (h *BalanceHandler) CustomIDValidaion(ctx context.Context, i interface{}) e
 
 // UpdateUserBalance function updates the user balance information
 func (h *BalanceHandler) UpdateUserBalance(ctx context.Context, req *proto.UserUpdateRequest) (*proto.UserUpdateResponse, error) {
-	err := h.CustomIDValidaion(ctx, req.Balance.ProfileID)
+	msg := req.GetBalance()
+	if msg == nil {
+		return nil, invalidArgument("balance", errMissingBalance)
+	}
+	err := h.CustomIDValidaion(ctx, msg.ProfileID)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ProfileID": msg.ProfileID}).Errorf("CustomValidate: %v", err)
+		return nil, invalidArgument("balance.ProfileID", fmt.Errorf("validate: %w", err))
+	}
+	ID, err := uuid.Parse(msg.ProfileID)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ProfileID": msg.ProfileID}).Errorf("Parse: %v", err)
+		return nil, invalidArgument("balance.ProfileID", fmt.Errorf("parse: %w", err))
+	}
+	currency, err := parseCurrency("balance.Currency", msg.Currency)
 	if err != nil {
-		logrus.WithFields(logrus.Fields{"ProfileID": req.Balance.ProfileID}).Errorf("CustomValidate: %v", err)
-		return nil, fmt.Errorf("validate: %w", err)
+		return nil, err
 	}
-	ID, err := uuid.Parse(req.Balance.ProfileID)
+	amount, err := parseAmount("balance.Balance", currency, msg.Balance)
 	if err != nil {
-		logrus.WithFields(logrus.Fields{"ProfileID": req.Balance.ProfileID}).Errorf("Parse: %v", err)
-		return nil, fmt.Errorf("parse: %w", err)
+		return nil, err
 	}
 	user := &model.Balance{
 		ProfileID: ID,
-		Balance:   req.Balance.Balance,
+		Currency:  currency,
+		Balance:   amount,
+		Version:   msg.Version,
 	}
 	err = h.srv.UpdateBalance(ctx, user)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{"user": user}).Errorf("UpdateBalance: %v", err)
-		return nil, fmt.Errorf("UpdateBalance: %w", err)
+		return nil, statusError(fmt.Errorf("UpdateBalance: %w", err))
 	}
-	return &proto.UserUpdateResponse{}, nil
+	return &proto.UserUpdateResponse{Balance: balanceToProto(user)}, nil
 }
 
-// GetUserByID function returns a user with the given ID
+// GetUserByID function returns the balance of a user with the given ID in the requested currency
 func (h *BalanceHandler) GetUserByID(ctx context.Context, req *proto.UserGetByIDRequest) (*proto.UserGetByIDResponse, error) {
 	err := h.CustomIDValidaion(ctx, req.ProfileID)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{"ProfileID": req.ProfileID}).Errorf("Validate: %v", err)
-		return nil, fmt.Errorf("validate: %w", err)
+		return nil, invalidArgument("ProfileID", fmt.Errorf("validate: %w", err))
 	}
 	ID, err := uuid.Parse(req.ProfileID)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{"ProfileID": req.ProfileID}).Errorf("Parse: %v", err)
-		return nil, fmt.Errorf("parse: %w", err)
+		return nil, invalidArgument("ProfileID", fmt.Errorf("parse: %w", err))
+	}
+	currency, err := parseCurrency("Currency", req.Currency)
+	if err != nil {
+		return nil, err
 	}
-	result, err := h.srv.GetUserByID(ctx, ID)
+	result, err := h.srv.GetUserByID(ctx, ID, currency)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{"req.ProfileID": req.ProfileID}).Errorf("GetUserByID: %v", err)
-		return nil, fmt.Errorf("GetUserByID: %w", err)
+		return nil, statusError(fmt.Errorf("GetUserByID: %w", err))
 	}
 
-	balance := &proto.Balance{
-		BalanceID: result.BalanceID.String(),
-		ProfileID: result.ProfileID.String(),
-		Balance:   result.Balance,
-	}
-	return &proto.UserGetByIDResponse{Balance: balance}, nil
+	return &proto.UserGetByIDResponse{Balance: balanceToProto(result)}, nil
 }
 
 // CreateUserBalance function creates a new user balance
 func (h *BalanceHandler) CreateUserBalance(ctx context.Context, req *proto.CreateBalanceRequest) (*proto.CreateBalanceResponse, error) {
-	err := h.CustomIDValidaion(ctx, req.Balance.ProfileID)
+	msg := req.GetBalance()
+	if msg == nil {
+		return nil, invalidArgument("balance", errMissingBalance)
+	}
+	err := h.CustomIDValidaion(ctx, msg.ProfileID)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ProfileID": msg.ProfileID}).Errorf("Validate: %v", err)
+		return nil, invalidArgument("balance.ProfileID", fmt.Errorf("validate: %w", err))
+	}
+	ProfileID, err := uuid.Parse(msg.ProfileID)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"req.ProfileID": msg.ProfileID}).Errorf("Parse: %v", err)
+		return nil, invalidArgument("balance.ProfileID", fmt.Errorf("parse: %w", err))
+	}
+	currency, err := parseCurrency("balance.Currency", msg.Currency)
 	if err != nil {
-		logrus.WithFields(logrus.Fields{"ProfileID": req.Balance.ProfileID}).Errorf("Validate: %v", err)
-		return nil, fmt.Errorf("validate: %w", err)
+		return nil, err
 	}
-	ProfileID, err := uuid.Parse(req.Balance.ProfileID)
+	amount, err := parseAmount("balance.Balance", currency, msg.Balance)
 	if err != nil {
-		logrus.WithFields(logrus.Fields{"req.ProfileID": req.Balance.ProfileID}).Errorf("Parse: %v", err)
-		return nil, fmt.Errorf("parse: %w", err)
+		return nil, err
 	}
 	balance := &model.Balance{
 		BalanceID: uuid.New(),
 		ProfileID: ProfileID,
-		Balance:   req.Balance.Balance,
+		Currency:  currency,
+		Balance:   amount,
 	}
 	err = h.srv.CreateBalance(ctx, balance)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{"user": balance}).Errorf("CreateBalance: %v", err)
-		return nil, fmt.Errorf("CreateBalance: %w", err)
+		return nil, statusError(fmt.Errorf("CreateBalance: %w", err))
 	}
 	return &proto.CreateBalanceResponse{}, nil
 }
 
-// DeleteUserBalance deletes user's balance
+// DeleteUserBalance closes user's balance, it is kept for old clients and behaves like CloseBalance
 func (h *BalanceHandler) DeleteUserBalance(ctx context.Context, req *proto.DeleteBalanceRequest) (*proto.DeleteBalanceResponse, error) {
 	err := h.CustomIDValidaion(ctx, req.ProfileID)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{"ProfileID": req.ProfileID}).Errorf("Validate: %v", err)
-		return nil, fmt.Errorf("validate: %w", err)
+		return nil, invalidArgument("ProfileID", fmt.Errorf("validate: %w", err))
 	}
 	ID, err := uuid.Parse(req.ProfileID)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{"ProfileID.ID": req.ProfileID}).Errorf("Parse: %v", err)
-		return nil, fmt.Errorf("parse: %w", err)
+		return nil, invalidArgument("ProfileID", fmt.Errorf("parse: %w", err))
+	}
+	currency, err := parseCurrency("Currency", req.Currency)
+	if err != nil {
+		return nil, err
 	}
-	err = h.srv.DeleteBalance(ctx, ID)
+	_, err = h.srv.CloseBalance(ctx, &model.StatusChange{ProfileID: ID, Currency: currency, Actor: deleteBalanceActor})
 	if err != nil {
-		logrus.WithFields(logrus.Fields{"ID": ID}).Errorf("DeleteBalance: %v", err)
-		return nil, fmt.Errorf("DeleteBalance: %w", err)
+		logrus.WithFields(logrus.Fields{"ID": ID}).Errorf("CloseBalance: %v", err)
+		return nil, statusError(fmt.Errorf("CloseBalance: %w", err))
 	}
 	return &proto.DeleteBalanceResponse{}, nil
 }
 
-// GetAllUserBalances returns all user balances
-func (h *BalanceHandler) GetAllUserBalances(ctx context.Context, _ *proto.GetAllBalanceRequest) (*proto.GetAllBalanceResponse, error) {
-	users, err := h.srv.GetAllBalances(ctx)
+// FreezeBalance blocks debits, or every operation when DebitOnly is false, on user's balance
+func (h *BalanceHandler) FreezeBalance(ctx context.Context, req *proto.FreezeBalanceRequest) (*proto.FreezeBalanceResponse, error) {
+	change, err := h.parseStatusChange(ctx, req.ProfileID, req.Currency, req.Actor, req.Reason)
+	if err != nil {
+		return nil, err
+	}
+	change.Status = model.StatusFullyFrozen
+	if req.DebitOnly {
+		change.Status = model.StatusDebitFrozen
+	}
+	balance, err := h.srv.FreezeBalance(ctx, change)
 	if err != nil {
-		logrus.Errorf("GetAllBalances: %v", err)
-		return nil, fmt.Errorf("GetAllBalances: %w", err)
+		logrus.WithFields(logrus.Fields{"change": change}).Errorf("FreezeBalance: %v", err)
+		return nil, statusError(fmt.Errorf("FreezeBalance: %w", err))
+	}
+	return &proto.FreezeBalanceResponse{Balance: balanceToProto(balance)}, nil
+}
+
+// UnfreezeBalance returns user's frozen balance to the active status
+func (h *BalanceHandler) UnfreezeBalance(ctx context.Context, req *proto.UnfreezeBalanceRequest) (*proto.UnfreezeBalanceResponse, error) {
+	change, err := h.parseStatusChange(ctx, req.ProfileID, req.Currency, req.Actor, req.Reason)
+	if err != nil {
+		return nil, err
+	}
+	balance, err := h.srv.UnfreezeBalance(ctx, change)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"change": change}).Errorf("UnfreezeBalance: %v", err)
+		return nil, statusError(fmt.Errorf("UnfreezeBalance: %w", err))
+	}
+	return &proto.UnfreezeBalanceResponse{Balance: balanceToProto(balance)}, nil
+}
+
+// CloseBalance closes user's balance, only a zero balance without active holds can be closed
+func (h *BalanceHandler) CloseBalance(ctx context.Context, req *proto.CloseBalanceRequest) (*proto.CloseBalanceResponse, error) {
+	change, err := h.parseStatusChange(ctx, req.ProfileID, req.Currency, req.Actor, req.Reason)
+	if err != nil {
+		return nil, err
+	}
+	balance, err := h.srv.CloseBalance(ctx, change)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"change": change}).Errorf("CloseBalance: %v", err)
+		return nil, statusError(fmt.Errorf("CloseBalance: %w", err))
+	}
+	return &proto.CloseBalanceResponse{Balance: balanceToProto(balance)}, nil
+}
+
+// SetCreditLimit sets how far user's available balance may go below zero
+func (h *BalanceHandler) SetCreditLimit(ctx context.Context, req *proto.SetCreditLimitRequest) (*proto.SetCreditLimitResponse, error) {
+	err := h.CustomIDValidaion(ctx, req.ProfileID)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ProfileID": req.ProfileID}).Errorf("Validate: %v", err)
+		return nil, invalidArgument("ProfileID", fmt.Errorf("validate: %w", err))
+	}
+	ID, err := uuid.Parse(req.ProfileID)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ProfileID": req.ProfileID}).Errorf("Parse: %v", err)
+		return nil, invalidArgument("ProfileID", fmt.Errorf("parse: %w", err))
+	}
+	currency, err := parseCurrency("Currency", req.Currency)
+	if err != nil {
+		return nil, err
+	}
+	creditLimit, err := parseAmount("CreditLimit", currency, req.CreditLimit)
+	if err != nil {
+		return nil, err
+	}
+	balance, err := h.srv.SetCreditLimit(ctx, ID, currency, creditLimit)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ProfileID": ID, "Currency": currency, "CreditLimit": creditLimit}).Errorf("SetCreditLimit: %v", err)
+		return nil, statusError(fmt.Errorf("SetCreditLimit: %w", err))
+	}
+	return &proto.SetCreditLimitResponse{Balance: balanceToProto(balance)}, nil
+}
+
+// parseStatusChange validates the profile ID, currency and actor shared by the status RPCs
+func (h *BalanceHandler) parseStatusChange(ctx context.Context, profileID, currencyCode, actor, reason string) (*model.StatusChange, error) {
+	err := h.CustomIDValidaion(ctx, profileID)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ProfileID": profileID}).Errorf("Validate: %v", err)
+		return nil, invalidArgument("ProfileID", fmt.Errorf("validate: %w", err))
+	}
+	ID, err := uuid.Parse(profileID)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ProfileID": profileID}).Errorf("Parse: %v", err)
+		return nil, invalidArgument("ProfileID", fmt.Errorf("parse: %w", err))
+	}
+	currency, err := parseCurrency("Currency", currencyCode)
+	if err != nil {
+		return nil, err
+	}
+	err = h.vl.VarCtx(ctx, actor, "required")
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"Actor": actor}).Errorf("Validate: %v", err)
+		return nil, invalidArgument("Actor", fmt.Errorf("validate: %w", err))
+	}
+	return &model.StatusChange{ProfileID: ID, Currency: currency, Actor: actor, Reason: reason}, nil
+}
+
+// GetAllUserBalances returns a page of user balances
+func (h *BalanceHandler) GetAllUserBalances(ctx context.Context, req *proto.GetAllBalanceRequest) (*proto.GetAllBalanceResponse, error) {
+	filter, err := h.parseBalanceFilter(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	users, next, err := h.srv.GetAllBalances(ctx, filter)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"filter": filter}).Errorf("GetAllBalances: %v", err)
+		return nil, statusError(fmt.Errorf("GetAllBalances: %w", err))
 	}
 	response := []*proto.Balance{}
 	for _, user := range users {
-		response = append(response, &proto.Balance{
-			ProfileID: user.BalanceID.String(),
-			Balance:   user.Balance,
-		})
+		response = append(response, balanceToProto(user))
+	}
+	token, err := encodeBalanceToken(filter, next)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"cursor": next}).Errorf("encodeBalanceToken: %v", err)
+		return nil, statusError(fmt.Errorf("encodeBalanceToken: %w", err))
+	}
+	return &proto.GetAllBalanceResponse{Balances: response, NextPageToken: token}, nil
+}
+
+// StreamAllBalances sends all user balances as they are read, the stream ends early when the client cancels it
+func (h *BalanceHandler) StreamAllBalances(_ *proto.StreamAllBalancesRequest, stream proto.BalanceService_StreamAllBalancesServer) error {
+	err := h.srv.StreamAllBalances(stream.Context(), func(balance *model.Balance) error {
+		return stream.Send(balanceToProto(balance))
+	})
+	if err != nil {
+		logrus.Errorf("StreamAllBalances: %v", err)
+		return statusError(fmt.Errorf("StreamAllBalances: %w", err))
+	}
+	return nil
+}
+
+// parseBalanceFilter validates and converts the paging, sorting and filtering fields of GetAllUserBalances
+func (h *BalanceHandler) parseBalanceFilter(ctx context.Context, req *proto.GetAllBalanceRequest) (model.BalanceFilter, error) {
+	var filter model.BalanceFilter
+	var ok bool
+	filter.Sort, ok = balanceSortFromProto[req.Sort]
+	if !ok {
+		logrus.WithFields(logrus.Fields{"Sort": req.Sort}).Errorf("balanceSortFromProto: %v", model.ErrInvalidSort)
+		return filter, invalidArgument("Sort", model.ErrInvalidSort)
+	}
+	pageSize, err := pageSizeFromProto(req.PageSize)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"PageSize": req.PageSize}).Errorf("pageSizeFromProto: %v", err)
+		return filter, invalidArgument("PageSize", fmt.Errorf("pageSizeFromProto: %w", err))
+	}
+	filter.Limit = pageSize
+	for _, profileID := range req.ProfileIDs {
+		err = h.CustomIDValidaion(ctx, profileID)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"ProfileIDs": req.ProfileIDs}).Errorf("Validate: %v", err)
+			return filter, invalidArgument("ProfileIDs", fmt.Errorf("validate: %w", err))
+		}
+		filter.ProfileIDs = append(filter.ProfileIDs, uuid.MustParse(profileID))
+	}
+	if req.Currency != "" {
+		filter.Currency, err = parseCurrency("Currency", req.Currency)
+		if err != nil {
+			return filter, err
+		}
+	}
+	for field, bound := range map[string]struct {
+		src *proto.Money
+		dst **model.Money
+	}{
+		"MinBalance": {req.MinBalance, &filter.MinBalance},
+		"MaxBalance": {req.MaxBalance, &filter.MaxBalance},
+	} {
+		if bound.src == nil {
+			continue
+		}
+		amount, err := moneyFromProto(bound.src)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{field: bound.src}).Errorf("moneyFromProto: %v", err)
+			return filter, invalidArgument(field, fmt.Errorf("moneyFromProto: %w", err))
+		}
+		*bound.dst = &amount
+	}
+	filter.After, err = decodeBalanceToken(filter, req.PageToken)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"PageToken": req.PageToken}).Errorf("decodeBalanceToken: %v", err)
+		return filter, invalidArgument("PageToken", fmt.Errorf("decodeBalanceToken: %w", err))
+	}
+	return filter, nil
+}
+
+// Deposit adds funds to the user's balance and returns the resulting balance
+func (h *BalanceHandler) Deposit(ctx context.Context, req *proto.DepositRequest) (*proto.DepositResponse, error) {
+	ID, currency, amount, err := h.parseAmountRequest(ctx, "ProfileID", req.ProfileID, req.Currency, req.Amount)
+	if err != nil {
+		return nil, err
+	}
+	result, err := h.srv.Deposit(ctx, ID, currency, amount, req.Reference)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ProfileID": ID, "Currency": currency, "Amount": amount}).Errorf("Deposit: %v", err)
+		return nil, statusError(fmt.Errorf("Deposit: %w", err))
+	}
+	return &proto.DepositResponse{Balance: balanceToProto(result)}, nil
+}
+
+// Withdraw takes funds from the user's balance and returns the resulting balance
+func (h *BalanceHandler) Withdraw(ctx context.Context, req *proto.WithdrawRequest) (*proto.WithdrawResponse, error) {
+	ID, currency, amount, err := h.parseAmountRequest(ctx, "ProfileID", req.ProfileID, req.Currency, req.Amount)
+	if err != nil {
+		return nil, err
+	}
+	result, err := h.srv.Withdraw(ctx, ID, currency, amount, req.Reference)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ProfileID": ID, "Currency": currency, "Amount": amount}).Errorf("Withdraw: %v", err)
+		return nil, statusError(fmt.Errorf("Withdraw: %w", err))
+	}
+	return &proto.WithdrawResponse{Balance: balanceToProto(result)}, nil
+}
+
+// Transfer moves funds from one profile to another and returns both resulting balances
+func (h *BalanceHandler) Transfer(ctx context.Context, req *proto.TransferRequest) (*proto.TransferResponse, error) {
+	fromID, currency, amount, err := h.parseAmountRequest(ctx, "FromProfileID", req.FromProfileID, req.Currency, req.Amount)
+	if err != nil {
+		return nil, err
+	}
+	err = h.CustomIDValidaion(ctx, req.ToProfileID)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ToProfileID": req.ToProfileID}).Errorf("Validate: %v", err)
+		return nil, invalidArgument("ToProfileID", fmt.Errorf("validate: %w", err))
+	}
+	toID, err := uuid.Parse(req.ToProfileID)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ToProfileID": req.ToProfileID}).Errorf("Parse: %v", err)
+		return nil, invalidArgument("ToProfileID", fmt.Errorf("parse: %w", err))
+	}
+	transfer := &model.Transfer{
+		TransferID:    uuid.New(),
+		FromProfileID: fromID,
+		ToProfileID:   toID,
+		Currency:      currency,
+		Amount:        amount,
+		Reference:     req.Reference,
+	}
+	from, to, err := h.srv.Transfer(ctx, transfer)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"transfer": transfer}).Errorf("Transfer: %v", err)
+		return nil, statusError(fmt.Errorf("Transfer: %w", err))
+	}
+	return &proto.TransferResponse{
+		TransferID: transfer.TransferID.String(),
+		From:       balanceToProto(from),
+		To:         balanceToProto(to),
+	}, nil
+}
+
+// CreateHold reserves funds on the user's balance without debiting them
+func (h *BalanceHandler) CreateHold(ctx context.Context, req *proto.CreateHoldRequest) (*proto.CreateHoldResponse, error) {
+	ID, currency, amount, err := h.parseAmountRequest(ctx, "ProfileID", req.ProfileID, req.Currency, req.Amount)
+	if err != nil {
+		return nil, err
+	}
+	hold := &model.Hold{
+		HoldID:    uuid.New(),
+		ProfileID: ID,
+		Currency:  currency,
+		Amount:    amount,
+		Reference: req.Reference,
+	}
+	err = h.srv.CreateHold(ctx, hold)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"hold": hold}).Errorf("CreateHold: %v", err)
+		return nil, statusError(fmt.Errorf("CreateHold: %w", err))
+	}
+	return &proto.CreateHoldResponse{Hold: holdToProto(hold)}, nil
+}
+
+// CaptureHold debits the whole or a part of a hold from the user's balance, the amount must fit the scale of the hold's currency
+func (h *BalanceHandler) CaptureHold(ctx context.Context, req *proto.CaptureHoldRequest) (*proto.CaptureHoldResponse, error) {
+	ID, err := h.parseHoldID(ctx, req.HoldID)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := moneyFromProto(req.Amount)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"Amount": req.Amount}).Errorf("moneyFromProto: %v", err)
+		return nil, invalidArgument("Amount", fmt.Errorf("moneyFromProto: %w", err))
+	}
+	hold, balance, err := h.srv.CaptureHold(ctx, ID, amount)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"HoldID": ID, "Amount": amount}).Errorf("CaptureHold: %v", err)
+		return nil, statusError(fmt.Errorf("CaptureHold: %w", err))
+	}
+	return &proto.CaptureHoldResponse{Hold: holdToProto(hold), Balance: balanceToProto(balance)}, nil
+}
+
+// ReleaseHold cancels a hold and returns its funds to the available balance
+func (h *BalanceHandler) ReleaseHold(ctx context.Context, req *proto.ReleaseHoldRequest) (*proto.ReleaseHoldResponse, error) {
+	ID, err := h.parseHoldID(ctx, req.HoldID)
+	if err != nil {
+		return nil, err
+	}
+	hold, err := h.srv.ReleaseHold(ctx, ID)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"HoldID": ID}).Errorf("ReleaseHold: %v", err)
+		return nil, statusError(fmt.Errorf("ReleaseHold: %w", err))
+	}
+	return &proto.ReleaseHoldResponse{Hold: holdToProto(hold)}, nil
+}
+
+// parseHoldID validates and parses a hold ID
+func (h *BalanceHandler) parseHoldID(ctx context.Context, holdID string) (uuid.UUID, error) {
+	err := h.CustomIDValidaion(ctx, holdID)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"HoldID": holdID}).Errorf("Validate: %v", err)
+		return uuid.Nil, invalidArgument("HoldID", fmt.Errorf("validate: %w", err))
+	}
+	ID, err := uuid.Parse(holdID)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"HoldID": holdID}).Errorf("Parse: %v", err)
+		return uuid.Nil, invalidArgument("HoldID", fmt.Errorf("parse: %w", err))
+	}
+	return ID, nil
+}
+
+// ListTransactions returns a page of the profile's ledger entries
+func (h *BalanceHandler) ListTransactions(ctx context.Context, req *proto.ListTransactionsRequest) (*proto.ListTransactionsResponse, error) {
+	err := h.CustomIDValidaion(ctx, req.ProfileID)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ProfileID": req.ProfileID}).Errorf("Validate: %v", err)
+		return nil, invalidArgument("ProfileID", fmt.Errorf("validate: %w", err))
+	}
+	ID, err := uuid.Parse(req.ProfileID)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ProfileID": req.ProfileID}).Errorf("Parse: %v", err)
+		return nil, invalidArgument("ProfileID", fmt.Errorf("parse: %w", err))
+	}
+	pageSize, err := pageSizeFromProto(req.PageSize)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"PageSize": req.PageSize}).Errorf("pageSizeFromProto: %v", err)
+		return nil, invalidArgument("PageSize", fmt.Errorf("pageSizeFromProto: %w", err))
+	}
+	after, err := decodeSequenceToken(req.PageToken)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"PageToken": req.PageToken}).Errorf("decodeSequenceToken: %v", err)
+		return nil, invalidArgument("PageToken", fmt.Errorf("decodeSequenceToken: %w", err))
+	}
+	filter := model.LedgerFilter{
+		ProfileID:     ID,
+		AfterSequence: after,
+		Limit:         pageSize,
+	}
+	if req.Currency != "" {
+		filter.Currency, err = parseCurrency("Currency", req.Currency)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if req.From != nil {
+		filter.From = req.From.AsTime()
+	}
+	if req.To != nil {
+		filter.To = req.To.AsTime()
+	}
+	entries, next, err := h.srv.ListTransactions(ctx, filter)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"filter": filter}).Errorf("ListTransactions: %v", err)
+		return nil, statusError(fmt.Errorf("ListTransactions: %w", err))
+	}
+	response := make([]*proto.LedgerEntry, 0, len(entries))
+	for _, entry := range entries {
+		response = append(response, ledgerEntryToProto(entry))
+	}
+	return &proto.ListTransactionsResponse{Entries: response, NextPageToken: encodeSequenceToken(next)}, nil
+}
+
+// WatchBalance sends the ledger entries of a profile's balance in Currency after AfterSequence as they are written
+func (h *BalanceHandler) WatchBalance(req *proto.WatchBalanceRequest, stream proto.BalanceService_WatchBalanceServer) error {
+	err := h.CustomIDValidaion(stream.Context(), req.ProfileID)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ProfileID": req.ProfileID}).Errorf("Validate: %v", err)
+		return invalidArgument("ProfileID", fmt.Errorf("validate: %w", err))
+	}
+	ID, err := uuid.Parse(req.ProfileID)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ProfileID": req.ProfileID}).Errorf("Parse: %v", err)
+		return invalidArgument("ProfileID", fmt.Errorf("parse: %w", err))
+	}
+	currency, err := parseCurrency("Currency", req.Currency)
+	if err != nil {
+		return err
+	}
+	if req.AfterSequence < 0 {
+		logrus.WithFields(logrus.Fields{"AfterSequence": req.AfterSequence}).Errorf("WatchBalance: negative sequence")
+		return invalidArgument("AfterSequence", fmt.Errorf("sequence %d is negative", req.AfterSequence))
+	}
+	err = h.srv.WatchBalance(stream.Context(), ID, currency, req.AfterSequence, func(entry *model.LedgerEntry) error {
+		return stream.Send(&proto.WatchBalanceResponse{Entry: ledgerEntryToProto(entry)})
+	})
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ProfileID": req.ProfileID}).Errorf("WatchBalance: %v", err)
+		return statusError(fmt.Errorf("WatchBalance: %w", err))
+	}
+	return nil
+}
+
+// parseAmountRequest validates and parses the profile ID, currency and amount shared by the RPCs that move funds,
+// field names the profile ID in validation errors
+func (h *BalanceHandler) parseAmountRequest(ctx context.Context, field, profileID, currencyCode string, protoAmount *proto.Money) (
+	uuid.UUID, model.Currency, model.Money, error) {
+	err := h.CustomIDValidaion(ctx, profileID)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ProfileID": profileID}).Errorf("Validate: %v", err)
+		return uuid.Nil, "", model.Money{}, invalidArgument(field, fmt.Errorf("validate: %w", err))
+	}
+	ID, err := uuid.Parse(profileID)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ProfileID": profileID}).Errorf("Parse: %v", err)
+		return uuid.Nil, "", model.Money{}, invalidArgument(field, fmt.Errorf("parse: %w", err))
+	}
+	currency, err := parseCurrency("Currency", currencyCode)
+	if err != nil {
+		return uuid.Nil, "", model.Money{}, err
+	}
+	amount, err := parseAmount("Amount", currency, protoAmount)
+	if err != nil {
+		return uuid.Nil, "", model.Money{}, err
+	}
+	return ID, currency, amount, nil
+}
+
+// parseCurrency validates a currency code, field names it in validation errors
+func parseCurrency(field, code string) (model.Currency, error) {
+	currency, err := model.ParseCurrency(code)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{field: code}).Errorf("ParseCurrency: %v", err)
+		return "", invalidArgument(field, fmt.Errorf("ParseCurrency: %w", err))
+	}
+	return currency, nil
+}
+
+// parseAmount converts a proto Money and checks that it has no more decimal places than currency allows,
+// field names the amount in validation errors
+func parseAmount(field string, currency model.Currency, protoAmount *proto.Money) (model.Money, error) {
+	amount, err := moneyFromProto(protoAmount)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{field: protoAmount}).Errorf("moneyFromProto: %v", err)
+		return model.Money{}, invalidArgument(field, fmt.Errorf("moneyFromProto: %w", err))
+	}
+	err = currency.CheckAmount(amount)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{field: amount, "Currency": currency}).Errorf("CheckAmount: %v", err)
+		return model.Money{}, invalidArgument(field, fmt.Errorf("CheckAmount: %w", err))
+	}
+	return amount, nil
+}
+
+// moneyFromProto converts a proto Money into the model type, a missing amount is treated as zero
+func moneyFromProto(m *proto.Money) (model.Money, error) {
+	if m == nil {
+		return model.Money{}, nil
+	}
+	return model.NewMoney(m.Units, m.Nanos)
+}
+
+// moneyToProto converts a model Money into the proto message
+func moneyToProto(m model.Money) *proto.Money {
+	return &proto.Money{Units: m.Units(), Nanos: m.Nanos()}
+}
+
+// ledgerEntryToProto converts a model LedgerEntry into the proto message
+func ledgerEntryToProto(e *model.LedgerEntry) *proto.LedgerEntry {
+	entry := &proto.LedgerEntry{
+		EntryID:   e.EntryID.String(),
+		Sequence:  e.Sequence,
+		ProfileID: e.ProfileID.String(),
+		Currency:  string(e.Currency),
+		Delta:     moneyToProto(e.Delta),
+		Balance:   moneyToProto(e.Balance),
+		Reason:    reasonToProto[e.Reason],
+		Reference: e.Reference,
+		CreatedAt: timestamppb.New(e.CreatedAt),
+	}
+	if e.TransferID != uuid.Nil {
+		entry.TransferID = e.TransferID.String()
+	}
+	return entry
+}
+
+// holdToProto converts a model Hold into the proto message
+func holdToProto(hold *model.Hold) *proto.Hold {
+	return &proto.Hold{
+		HoldID:    hold.HoldID.String(),
+		ProfileID: hold.ProfileID.String(),
+		Currency:  string(hold.Currency),
+		Amount:    moneyToProto(hold.Amount),
+		Captured:  moneyToProto(hold.Captured),
+		Status:    holdStatusToProto[hold.Status],
+		Reference: hold.Reference,
+		CreatedAt: timestamppb.New(hold.CreatedAt),
+		UpdatedAt: timestamppb.New(hold.UpdatedAt),
+	}
+}
+
+// pageSizeFromProto applies the default and upper bound to a requested page size
+func pageSizeFromProto(size int32) (int, error) {
+	switch {
+	case size < 0:
+		return 0, model.ErrInvalidPageSize
+	case size == 0:
+		return defaultPageSize, nil
+	case size > maxPageSize:
+		return maxPageSize, nil
+	}
+	return int(size), nil
+}
+
+// encodeSequenceToken turns a ledger sequence into an opaque page token, 0 becomes an empty token
+func encodeSequenceToken(sequence int64) string {
+	if sequence == 0 {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(sequence, 10)))
+}
+
+// decodeSequenceToken reverses encodeSequenceToken, an empty token means the first page
+func decodeSequenceToken(token string) (int64, error) {
+	if token == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, fmt.Errorf("invalid page token: %w", err)
+	}
+	sequence, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil || sequence < 0 {
+		return 0, fmt.Errorf("invalid page token %q", token)
+	}
+	return sequence, nil
+}
+
+// balanceToken is the content of a GetAllUserBalances page token. Query is the fingerprint of the sort and filters
+// of the listing it was issued for, it guards against continuing another listing with the token.
+type balanceToken struct {
+	Query     []byte         `json:"q"`
+	ProfileID uuid.UUID      `json:"p"`
+	Currency  model.Currency `json:"c"`
+	Balance   model.Money    `json:"b"`
+}
+
+// balanceQuery returns the fingerprint of the sort and filters of a balance listing, the page size and position are
+// left out and so is the order of the profile IDs
+func balanceQuery(filter model.BalanceFilter) ([]byte, error) {
+	profileIDs := make([]string, 0, len(filter.ProfileIDs))
+	for _, profileID := range filter.ProfileIDs {
+		profileIDs = append(profileIDs, profileID.String())
+	}
+	sort.Strings(profileIDs)
+	raw, err := json.Marshal([]interface{}{filter.Sort, filter.Currency, filter.MinBalance, filter.MaxBalance, profileIDs})
+	if err != nil {
+		return nil, fmt.Errorf("Marshal: %w", err)
+	}
+	sum := sha256.Sum256(raw)
+	return sum[:16], nil
+}
+
+// encodeBalanceToken turns a balance cursor into an opaque page token for the listing filter, a nil cursor becomes an
+// empty token
+func encodeBalanceToken(filter model.BalanceFilter, cursor *model.BalanceCursor) (string, error) {
+	if cursor == nil {
+		return "", nil
+	}
+	query, err := balanceQuery(filter)
+	if err != nil {
+		return "", fmt.Errorf("balanceQuery: %w", err)
+	}
+	raw, err := json.Marshal(balanceToken{Query: query, ProfileID: cursor.ProfileID, Currency: cursor.Currency, Balance: cursor.Balance})
+	if err != nil {
+		return "", fmt.Errorf("Marshal: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// decodeBalanceToken reverses encodeBalanceToken, an empty token means the first page. A token issued for a listing
+// with another sort or other filters is rejected.
+func decodeBalanceToken(filter model.BalanceFilter, token string) (*model.BalanceCursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid page token: %w", err)
+	}
+	var decoded balanceToken
+	err = json.Unmarshal(raw, &decoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid page token: %w", err)
+	}
+	query, err := balanceQuery(filter)
+	if err != nil {
+		return nil, fmt.Errorf("balanceQuery: %w", err)
+	}
+	if !bytes.Equal(decoded.Query, query) {
+		return nil, errors.New("page token was issued for another sort or other filters")
+	}
+	return &model.BalanceCursor{ProfileID: decoded.ProfileID, Currency: decoded.Currency, Balance: decoded.Balance}, nil
+}
+
+// balanceToProto converts a model Balance into the proto message
+func balanceToProto(b *model.Balance) *proto.Balance {
+	return &proto.Balance{
+		BalanceID:   b.BalanceID.String(),
+		ProfileID:   b.ProfileID.String(),
+		Currency:    string(b.Currency),
+		Balance:     moneyToProto(b.Balance),
+		Available:   moneyToProto(b.Available),
+		CreditLimit: moneyToProto(b.CreditLimit),
+		Status:      balanceStatusToProto[b.Status],
+		Version:     b.Version,
 	}
-	return &proto.GetAllBalanceResponse{Balances: response}, nil
 }
@@ -3,22 +3,36 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"os"
 	"testing"
 
 	"github.com/eugenshima/balance/internal/handlers/mocks"
 	"github.com/eugenshima/balance/internal/model"
+	proto "github.com/eugenshima/balance/proto"
 
+	"github.com/go-playground/validator"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	protobuf "google.golang.org/protobuf/proto"
 )
 
 var (
 	mockBalanceService *mocks.BalanceService
 	mockBalanceEntity  = model.Balance{
 		BalanceID: uuid.New(),
-		Balance:   1234.25,
+		Currency:  "USD",
+		Balance:   model.MustParseMoney("1234.25"),
 	}
 )
 
@@ -40,27 +54,29 @@ func TestCreateUserBalance(t *testing.T) {
 	require.True(t, assertion)
 }
 
-// TestDelete is a mocktest for Delete method of interface BalanceService
+// TestDelete tests that the deprecated delete RPC closes the balance instead of removing it
 func TestDelete(t *testing.T) {
-	mockBalanceService.On("DeleteBalance", mock.Anything, mock.AnythingOfType("uuid.UUID")).Return(nil).Once()
+	srv := mocks.NewBalanceService(t)
+	client := newTestClient(t, srv)
+	profileID := uuid.New()
+	srv.On("CloseBalance", mock.Anything, &model.StatusChange{ProfileID: profileID, Currency: "USD", Actor: deleteBalanceActor}).
+		Return(&model.Balance{ProfileID: profileID, Status: model.StatusClosed}, nil).Once()
 
-	err := mockBalanceService.DeleteBalance(context.Background(), mockBalanceEntity.BalanceID)
+	_, err := client.DeleteUserBalance(context.Background(), &proto.DeleteBalanceRequest{ProfileID: profileID.String(), Currency: "USD"})
 	require.NoError(t, err)
-
-	assertion := mockBalanceService.AssertExpectations(t)
-	require.True(t, assertion)
 }
 
 // TestGetAll is a mocktest for Get All method of interface BalanceService
 func TestGetAll(t *testing.T) {
-	mockBalanceService.On("GetAllBalances", mock.Anything).Return([]*model.Balance{}, nil).Twice()
+	filter := model.BalanceFilter{Sort: model.SortByProfileID, Limit: defaultPageSize}
+	mockBalanceService.On("GetAllBalances", mock.Anything, filter).Return([]*model.Balance{}, (*model.BalanceCursor)(nil), nil).Twice()
 	handler := NewBalancehandler(mockBalanceService, nil)
 
-	res, err := mockBalanceService.GetAllBalances(context.Background())
+	res, _, err := mockBalanceService.GetAllBalances(context.Background(), filter)
 	require.NoError(t, err)
 	require.NotNil(t, res)
 
-	results, err := handler.srv.GetAllBalances(context.Background())
+	results, _, err := handler.srv.GetAllBalances(context.Background(), filter)
 	require.NoError(t, err)
 	require.NotNil(t, results)
 	require.Equal(t, len(res), len(results))
@@ -82,12 +98,452 @@ func TestUpdate(t *testing.T) {
 
 // TestGetByID is a mocktest for Get By ID method of interface BalanceService
 func TestGetByID(t *testing.T) {
-	mockBalanceService.On("GetUserByID", mock.Anything, mock.AnythingOfType("uuid.UUID")).Return(&model.Balance{}, nil).Once()
+	mockBalanceService.On("GetUserByID", mock.Anything, mock.AnythingOfType("uuid.UUID"), model.Currency("USD")).Return(&model.Balance{}, nil).Once()
 
-	result, err := mockBalanceService.GetUserByID(context.Background(), mockBalanceEntity.BalanceID)
+	result, err := mockBalanceService.GetUserByID(context.Background(), mockBalanceEntity.BalanceID, mockBalanceEntity.Currency)
 	require.NoError(t, err)
 	require.NotNil(t, result)
 
 	assertion := mockBalanceService.AssertExpectations(t)
 	require.True(t, assertion)
 }
+
+// newTestClient starts the handler on an in-memory listener and returns a connected client
+func newTestClient(t *testing.T, srv BalanceService, opts ...grpc.ServerOption) proto.BalanceServiceClient {
+	lis := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(opts...)
+	proto.RegisterBalanceServiceServer(server, NewBalancehandler(srv, validator.New()))
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return proto.NewBalanceServiceClient(conn)
+}
+
+// TestMoneyGRPCRoundTrip tests that amounts survive a gRPC round-trip without precision loss
+func TestMoneyGRPCRoundTrip(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	client := newTestClient(t, srv)
+	sum, sumErr := model.MustParseMoney("0.1").Add(model.MustParseMoney("0.2"))
+	require.NoError(t, sumErr)
+
+	for _, amount := range []model.Money{
+		sum,
+		model.MustParseMoney("-0.01"),
+		model.MustParseMoney("9007199254740993.12"),
+		model.MustParseMoney("-1234.5"),
+	} {
+		profileID := uuid.New()
+		var stored *model.Balance
+		srv.On("UpdateBalance", mock.Anything, mock.AnythingOfType("*model.Balance")).Run(func(args mock.Arguments) {
+			stored = args.Get(1).(*model.Balance)
+		}).Return(nil).Once()
+		_, err := client.UpdateUserBalance(context.Background(), &proto.UserUpdateRequest{
+			Balance: &proto.Balance{ProfileID: profileID.String(), Currency: "USD", Balance: moneyToProto(amount)},
+		})
+		require.NoError(t, err)
+		require.Equal(t, amount, stored.Balance)
+
+		srv.On("GetUserByID", mock.Anything, profileID, model.Currency("USD")).Return(stored, nil).Once()
+		resp, err := client.GetUserByID(context.Background(), &proto.UserGetByIDRequest{ProfileID: profileID.String(), Currency: "USD"})
+		require.NoError(t, err)
+		received, err := moneyFromProto(resp.Balance.Balance)
+		require.NoError(t, err)
+		require.Equal(t, amount, received)
+		require.Equal(t, amount.String(), received.String())
+	}
+}
+
+// TestMoneyFromProtoRejectsMixedSigns tests that malformed proto amounts are rejected
+func TestMoneyFromProtoRejectsMixedSigns(t *testing.T) {
+	_, err := moneyFromProto(&proto.Money{Units: 1, Nanos: -5})
+	require.ErrorIs(t, err, model.ErrMoneySign)
+	_, err = moneyFromProto(&proto.Money{Nanos: 1_000_000_000})
+	require.ErrorIs(t, err, model.ErrMoneyFormat)
+}
+
+// TestWithdrawInsufficientFunds tests that the withdraw error reaches the client
+func TestWithdrawInsufficientFunds(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	client := newTestClient(t, srv)
+	profileID := uuid.New()
+	amount := model.MustParseMoney("5.25")
+	srv.On("Withdraw", mock.Anything, profileID, model.Currency("USD"), amount, "").Return(nil, model.ErrInsufficientFunds).Once()
+
+	_, err := client.Withdraw(context.Background(), &proto.WithdrawRequest{ProfileID: profileID.String(), Currency: "USD", Amount: moneyToProto(amount)})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), model.ErrInsufficientFunds.Error())
+}
+
+// TestDepositReturnsBalance tests that deposit returns the resulting balance
+func TestDepositReturnsBalance(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	client := newTestClient(t, srv)
+	profileID := uuid.New()
+	srv.On("Deposit", mock.Anything, profileID, model.Currency("USD"), model.MustParseMoney("1.1"), "order-42").
+		Return(&model.Balance{ProfileID: profileID, Balance: model.MustParseMoney("3.3")}, nil).Once()
+
+	resp, err := client.Deposit(context.Background(), &proto.DepositRequest{ProfileID: profileID.String(), Currency: "USD", Amount: moneyToProto(model.MustParseMoney("1.1")), Reference: "order-42"})
+	require.NoError(t, err)
+	received, err := moneyFromProto(resp.Balance.Balance)
+	require.NoError(t, err)
+	require.Equal(t, model.MustParseMoney("3.3"), received)
+}
+
+// TestListTransactionsPagination tests page size defaults and page token round-trips
+func TestListTransactionsPagination(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	client := newTestClient(t, srv)
+	profileID := uuid.New()
+	entry := &model.LedgerEntry{EntryID: uuid.New(), Sequence: 7, ProfileID: profileID, Reason: model.ReasonDeposit}
+	srv.On("ListTransactions", mock.Anything, model.LedgerFilter{ProfileID: profileID, Limit: defaultPageSize}).
+		Return([]*model.LedgerEntry{entry}, int64(7), nil).Once()
+	srv.On("ListTransactions", mock.Anything, model.LedgerFilter{ProfileID: profileID, AfterSequence: 7, Limit: 1}).
+		Return([]*model.LedgerEntry{}, int64(0), nil).Once()
+
+	resp, err := client.ListTransactions(context.Background(), &proto.ListTransactionsRequest{ProfileID: profileID.String()})
+	require.NoError(t, err)
+	require.Len(t, resp.Entries, 1)
+	require.Equal(t, proto.Reason_REASON_DEPOSIT, resp.Entries[0].Reason)
+	require.NotEmpty(t, resp.NextPageToken)
+
+	resp, err = client.ListTransactions(context.Background(), &proto.ListTransactionsRequest{ProfileID: profileID.String(), PageSize: 1, PageToken: resp.NextPageToken})
+	require.NoError(t, err)
+	require.Empty(t, resp.Entries)
+	require.Empty(t, resp.NextPageToken)
+}
+
+// TestGetAllUserBalancesPagination tests filters, sort and page token round-trips of GetAllUserBalances
+func TestGetAllUserBalancesPagination(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	client := newTestClient(t, srv)
+	profileID := uuid.New()
+	minBalance := model.MustParseMoney("10")
+	cursor := &model.BalanceCursor{ProfileID: profileID, Balance: model.MustParseMoney("12.5")}
+	filter := model.BalanceFilter{ProfileIDs: []uuid.UUID{profileID}, MinBalance: &minBalance, Sort: model.SortByBalanceDesc, Limit: 1}
+	srv.On("GetAllBalances", mock.Anything, filter).
+		Return([]*model.Balance{{ProfileID: profileID, Balance: cursor.Balance}}, cursor, nil).Once()
+	next := filter
+	next.After = cursor
+	srv.On("GetAllBalances", mock.Anything, next).Return([]*model.Balance{}, (*model.BalanceCursor)(nil), nil).Once()
+
+	req := &proto.GetAllBalanceRequest{
+		PageSize:   1,
+		Sort:       proto.BalanceSort_BALANCE_SORT_BALANCE_DESC,
+		MinBalance: moneyToProto(minBalance),
+		ProfileIDs: []string{profileID.String()},
+	}
+	resp, err := client.GetAllUserBalances(context.Background(), req)
+	require.NoError(t, err)
+	require.Len(t, resp.Balances, 1)
+	require.NotEmpty(t, resp.NextPageToken)
+
+	req.PageToken = resp.NextPageToken
+	resp, err = client.GetAllUserBalances(context.Background(), req)
+	require.NoError(t, err)
+	require.Empty(t, resp.Balances)
+	require.Empty(t, resp.NextPageToken)
+
+	// the token only continues a listing with the same sort and filters
+	for name, change := range map[string]func(*proto.GetAllBalanceRequest){
+		"sort":       func(r *proto.GetAllBalanceRequest) { r.Sort = proto.BalanceSort_BALANCE_SORT_BALANCE_ASC },
+		"currency":   func(r *proto.GetAllBalanceRequest) { r.Currency = "EUR" },
+		"minBalance": func(r *proto.GetAllBalanceRequest) { r.MinBalance = moneyToProto(model.MustParseMoney("0")) },
+		"maxBalance": func(r *proto.GetAllBalanceRequest) { r.MaxBalance = moneyToProto(model.MustParseMoney("100")) },
+		"profileIDs": func(r *proto.GetAllBalanceRequest) { r.ProfileIDs = append(r.ProfileIDs, uuid.NewString()) },
+	} {
+		changed := protobuf.Clone(req).(*proto.GetAllBalanceRequest)
+		change(changed)
+		_, err = client.GetAllUserBalances(context.Background(), changed)
+		require.Equal(t, codes.InvalidArgument, status.Code(err), name)
+	}
+
+	_, err = client.GetAllUserBalances(context.Background(), &proto.GetAllBalanceRequest{ProfileIDs: []string{"not a uuid"}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// TestStreamAllBalances tests that streamed balances reach the client and that storage errors end the stream
+func TestStreamAllBalances(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	client := newTestClient(t, srv)
+	balances := []*model.Balance{{ProfileID: uuid.New()}, {ProfileID: uuid.New()}}
+	srv.On("StreamAllBalances", mock.Anything, mock.Anything).Return(func(_ context.Context, fn func(*model.Balance) error) error {
+		for _, balance := range balances {
+			if err := fn(balance); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Once()
+	srv.On("StreamAllBalances", mock.Anything, mock.Anything).Return(model.ErrUnavailable).Once()
+
+	stream, err := client.StreamAllBalances(context.Background(), &proto.StreamAllBalancesRequest{})
+	require.NoError(t, err)
+	for _, balance := range balances {
+		received, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, balance.ProfileID.String(), received.ProfileID)
+	}
+	_, err = stream.Recv()
+	require.ErrorIs(t, err, io.EOF)
+
+	stream, err = client.StreamAllBalances(context.Background(), &proto.StreamAllBalancesRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+// TestWatchBalance tests that watched ledger entries reach the client until it cancels the stream
+func TestWatchBalance(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	client := newTestClient(t, srv)
+	profileID := uuid.New()
+	entries := []*model.LedgerEntry{{Sequence: 3, ProfileID: profileID}, {Sequence: 5, ProfileID: profileID}}
+	srv.On("WatchBalance", mock.Anything, profileID, model.Currency("EUR"), int64(2), mock.Anything).
+		Return(func(ctx context.Context, _ uuid.UUID, _ model.Currency, _ int64, fn func(*model.LedgerEntry) error) error {
+			for _, entry := range entries {
+				if err := fn(entry); err != nil {
+					return err
+				}
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		}).Once()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.WatchBalance(ctx, &proto.WatchBalanceRequest{ProfileID: profileID.String(), Currency: "EUR", AfterSequence: 2})
+	require.NoError(t, err)
+	for _, entry := range entries {
+		received, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, entry.Sequence, received.Entry.Sequence)
+	}
+	cancel()
+	_, err = stream.Recv()
+	require.Equal(t, codes.Canceled, status.Code(err))
+
+	stream, err = client.WatchBalance(context.Background(), &proto.WatchBalanceRequest{ProfileID: profileID.String(), Currency: "EUR", AfterSequence: -1})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// TestDecodeSequenceTokenRejectsGarbage tests that malformed page tokens are rejected
+func TestDecodeSequenceTokenRejectsGarbage(t *testing.T) {
+	_, err := decodeSequenceToken("not a token!")
+	require.Error(t, err)
+	_, err = decodeSequenceToken(encodeSequenceToken(-1))
+	require.Error(t, err)
+	sequence, err := decodeSequenceToken(encodeSequenceToken(42))
+	require.NoError(t, err)
+	require.Equal(t, int64(42), sequence)
+}
+
+// TestTransferReturnsBothBalances tests that transfer returns both resulting balances
+func TestTransferReturnsBothBalances(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	client := newTestClient(t, srv)
+	fromID, toID := uuid.New(), uuid.New()
+	var transfer *model.Transfer
+	srv.On("Transfer", mock.Anything, mock.AnythingOfType("*model.Transfer")).Run(func(args mock.Arguments) {
+		transfer = args.Get(1).(*model.Transfer)
+	}).Return(
+		&model.Balance{ProfileID: fromID, Balance: model.MustParseMoney("1")},
+		&model.Balance{ProfileID: toID, Balance: model.MustParseMoney("9")},
+		nil,
+	).Once()
+
+	resp, err := client.Transfer(context.Background(), &proto.TransferRequest{
+		FromProfileID: fromID.String(),
+		ToProfileID:   toID.String(),
+		Currency:      "USD",
+		Amount:        moneyToProto(model.MustParseMoney("4")),
+		Reference:     "p2p",
+	})
+	require.NoError(t, err)
+	require.Equal(t, transfer.TransferID.String(), resp.TransferID)
+	require.Equal(t, fromID, transfer.FromProfileID)
+	require.Equal(t, toID, transfer.ToProfileID)
+	require.Equal(t, model.MustParseMoney("4"), transfer.Amount)
+	require.Equal(t, model.Currency("USD"), transfer.Currency)
+	require.Equal(t, toID.String(), resp.To.ProfileID)
+}
+
+func TestHoldLifecycle(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	client := newTestClient(t, srv)
+	profileID := uuid.New()
+	var hold *model.Hold
+	srv.On("CreateHold", mock.Anything, mock.AnythingOfType("*model.Hold")).Run(func(args mock.Arguments) {
+		hold = args.Get(1).(*model.Hold)
+		hold.Status = model.HoldActive
+	}).Return(nil).Once()
+
+	created, err := client.CreateHold(context.Background(), &proto.CreateHoldRequest{
+		ProfileID: profileID.String(),
+		Currency:  "USD",
+		Amount:    moneyToProto(model.MustParseMoney("12.5")),
+		Reference: "order-7",
+	})
+	require.NoError(t, err)
+	require.Equal(t, hold.HoldID.String(), created.Hold.HoldID)
+	require.Equal(t, proto.HoldStatus_HOLD_STATUS_ACTIVE, created.Hold.Status)
+	require.Equal(t, model.MustParseMoney("12.5"), hold.Amount)
+
+	srv.On("CaptureHold", mock.Anything, hold.HoldID, model.Money{}).Return(
+		&model.Hold{HoldID: hold.HoldID, ProfileID: profileID, Amount: hold.Amount, Captured: hold.Amount, Status: model.HoldCaptured},
+		&model.Balance{ProfileID: profileID, Balance: model.MustParseMoney("7.5"), Available: model.MustParseMoney("7.5")},
+		nil,
+	).Once()
+	captured, err := client.CaptureHold(context.Background(), &proto.CaptureHoldRequest{HoldID: hold.HoldID.String()})
+	require.NoError(t, err)
+	require.Equal(t, proto.HoldStatus_HOLD_STATUS_CAPTURED, captured.Hold.Status)
+	require.Equal(t, moneyToProto(model.MustParseMoney("7.5")).String(), captured.Balance.Available.String())
+
+	srv.On("ReleaseHold", mock.Anything, hold.HoldID).Return(nil, model.ErrHoldNotActive).Once()
+	_, err = client.ReleaseHold(context.Background(), &proto.ReleaseHoldRequest{HoldID: hold.HoldID.String()})
+	require.ErrorContains(t, err, model.ErrHoldNotActive.Error())
+}
+
+func TestFreezeBalance(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	client := newTestClient(t, srv)
+	profileID := uuid.New()
+	change := &model.StatusChange{ProfileID: profileID, Currency: "USD", Status: model.StatusDebitFrozen, Actor: "compliance", Reason: "KYC review"}
+	srv.On("FreezeBalance", mock.Anything, change).
+		Return(&model.Balance{ProfileID: profileID, Status: model.StatusDebitFrozen}, nil).Once()
+
+	resp, err := client.FreezeBalance(context.Background(), &proto.FreezeBalanceRequest{
+		ProfileID: profileID.String(),
+		Currency:  "USD",
+		DebitOnly: true,
+		Actor:     "compliance",
+		Reason:    "KYC review",
+	})
+	require.NoError(t, err)
+	require.Equal(t, proto.BalanceStatus_BALANCE_STATUS_DEBIT_FROZEN, resp.Balance.Status)
+
+	_, err = client.CloseBalance(context.Background(), &proto.CloseBalanceRequest{ProfileID: profileID.String()})
+	require.Error(t, err)
+}
+
+func TestUpdateUserBalanceVersionConflict(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	client := newTestClient(t, srv)
+	profileID := uuid.New()
+	srv.On("UpdateBalance", mock.Anything, mock.MatchedBy(func(b *model.Balance) bool { return b.Version == 3 })).
+		Return(model.ErrVersionConflict).Once()
+
+	_, err := client.UpdateUserBalance(context.Background(), &proto.UserUpdateRequest{
+		Balance: &proto.Balance{ProfileID: profileID.String(), Currency: "USD", Balance: moneyToProto(model.MustParseMoney("1")), Version: 3},
+	})
+	require.Equal(t, codes.Aborted, status.Code(err))
+}
+
+// TestMissingBalanceIsRejected tests that update and create requests without a balance fail instead of crashing
+func TestMissingBalanceIsRejected(t *testing.T) {
+	client := newTestClient(t, mocks.NewBalanceService(t))
+	_, updateErr := client.UpdateUserBalance(context.Background(), &proto.UserUpdateRequest{})
+	_, createErr := client.CreateUserBalance(context.Background(), &proto.CreateBalanceRequest{})
+	for _, err := range []error{updateErr, createErr} {
+		st := status.Convert(err)
+		require.Equal(t, codes.InvalidArgument, st.Code())
+		badRequest, ok := st.Details()[0].(*errdetails.BadRequest)
+		require.True(t, ok)
+		require.Equal(t, "balance", badRequest.FieldViolations[0].Field)
+	}
+}
+
+func TestErrorsMapToStatusCodes(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	client := newTestClient(t, srv)
+	for _, tc := range []struct {
+		err    error
+		code   codes.Code
+		reason string
+	}{
+		{fmt.Errorf("QueryRow(): %w", model.ErrNotFound), codes.NotFound, "NOT_FOUND"},
+		{model.ErrInsufficientFunds, codes.FailedPrecondition, "INSUFFICIENT_FUNDS"},
+		{model.ErrUnavailable, codes.Unavailable, "STORAGE_UNAVAILABLE"},
+		{fmt.Errorf("CaptureHold: %w", model.ErrCurrencyScale), codes.InvalidArgument, "CURRENCY_SCALE"},
+		{errors.New("syntax error at or near SELECT"), codes.Internal, "INTERNAL"},
+	} {
+		profileID := uuid.New()
+		srv.On("Withdraw", mock.Anything, profileID, model.Currency("USD"), model.MustParseMoney("1"), "").Return(nil, tc.err).Once()
+		_, err := client.Withdraw(context.Background(), &proto.WithdrawRequest{ProfileID: profileID.String(), Currency: "USD", Amount: moneyToProto(model.MustParseMoney("1"))})
+		st := status.Convert(err)
+		require.Equal(t, tc.code, st.Code())
+		require.Len(t, st.Details(), 1)
+		info, ok := st.Details()[0].(*errdetails.ErrorInfo)
+		require.True(t, ok)
+		require.Equal(t, tc.reason, info.Reason)
+	}
+	_, err := client.Withdraw(context.Background(), &proto.WithdrawRequest{ProfileID: "not-a-uuid"})
+	st := status.Convert(err)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+	badRequest, ok := st.Details()[0].(*errdetails.BadRequest)
+	require.True(t, ok)
+	require.Equal(t, "ProfileID", badRequest.FieldViolations[0].Field)
+}
+
+// TestCurrencyValidation tests that unknown currencies and amounts finer than the currency allows are rejected
+func TestCurrencyValidation(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	client := newTestClient(t, srv)
+	profileID := uuid.New().String()
+	for _, tc := range []struct {
+		currency string
+		amount   string
+		field    string
+	}{
+		{"", "1", "Currency"},
+		{"usd", "1", "Currency"},
+		{"XYZ", "1", "Currency"},
+		{"USD", "1.005", "Amount"},
+		{"JPY", "0.5", "Amount"},
+	} {
+		_, err := client.Deposit(context.Background(), &proto.DepositRequest{
+			ProfileID: profileID,
+			Currency:  tc.currency,
+			Amount:    moneyToProto(model.MustParseMoney(tc.amount)),
+		})
+		st := status.Convert(err)
+		require.Equal(t, codes.InvalidArgument, st.Code())
+		badRequest, ok := st.Details()[0].(*errdetails.BadRequest)
+		require.True(t, ok)
+		require.Equal(t, tc.field, badRequest.FieldViolations[0].Field)
+	}
+}
+
+func TestSetCreditLimit(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	client := newTestClient(t, srv)
+	profileID := uuid.New()
+	limit := model.MustParseMoney("250")
+	srv.On("SetCreditLimit", mock.Anything, profileID, model.Currency("USD"), limit).
+		Return(&model.Balance{ProfileID: profileID, Currency: "USD", Balance: model.MustParseMoney("-20"), CreditLimit: limit}, nil).Once()
+	srv.On("SetCreditLimit", mock.Anything, profileID, model.Currency("USD"), model.Money{}).Return(nil, model.ErrCreditLimitInUse).Once()
+
+	resp, err := client.SetCreditLimit(context.Background(), &proto.SetCreditLimitRequest{ProfileID: profileID.String(), Currency: "USD", CreditLimit: moneyToProto(limit)})
+	require.NoError(t, err)
+	require.Equal(t, moneyToProto(limit).String(), resp.Balance.CreditLimit.String())
+
+	_, err = client.SetCreditLimit(context.Background(), &proto.SetCreditLimitRequest{ProfileID: profileID.String(), Currency: "USD"})
+	st := status.Convert(err)
+	require.Equal(t, codes.FailedPrecondition, st.Code())
+	require.Equal(t, "CREDIT_LIMIT_IN_USE", st.Details()[0].(*errdetails.ErrorInfo).Reason)
+
+	_, err = client.SetCreditLimit(context.Background(), &proto.SetCreditLimitRequest{ProfileID: profileID.String(), Currency: "USD", CreditLimit: moneyToProto(model.MustParseMoney("0.001"))})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
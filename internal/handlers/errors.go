@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/eugenshima/balance/internal/model"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/runtime/protoiface"
+)
+
+// errorDomain is the ErrorInfo domain of the errors returned by this service
+const errorDomain = "balance.eugenshima.github.com"
+
+// errorStatuses maps domain errors onto gRPC codes and the ErrorInfo reasons clients can switch on,
+// the first entry the error matches wins
+var errorStatuses = []struct {
+	err    error
+	code   codes.Code
+	reason string
+}{
+	{model.ErrNotFound, codes.NotFound, "NOT_FOUND"},
+	{model.ErrAlreadyExists, codes.AlreadyExists, "ALREADY_EXISTS"},
+	{model.ErrInvalidAmount, codes.InvalidArgument, "INVALID_AMOUNT"},
+	{model.ErrInvalidCurrency, codes.InvalidArgument, "INVALID_CURRENCY"},
+	{model.ErrCurrencyScale, codes.InvalidArgument, "CURRENCY_SCALE"},
+	{model.ErrSameProfileTransfer, codes.InvalidArgument, "SAME_PROFILE_TRANSFER"},
+	{model.ErrInvalidPageSize, codes.InvalidArgument, "INVALID_PAGE_SIZE"},
+	{model.ErrInvalidSort, codes.InvalidArgument, "INVALID_SORT"},
+	{model.ErrActorRequired, codes.InvalidArgument, "ACTOR_REQUIRED"},
+	{model.ErrInvalidCreditLimit, codes.InvalidArgument, "INVALID_CREDIT_LIMIT"},
+	{model.ErrInsufficientFunds, codes.FailedPrecondition, "INSUFFICIENT_FUNDS"},
+	{model.ErrCreditLimitInUse, codes.FailedPrecondition, "CREDIT_LIMIT_IN_USE"},
+	{model.ErrBalanceFrozen, codes.FailedPrecondition, "BALANCE_FROZEN"},
+	{model.ErrBalanceClosed, codes.FailedPrecondition, "BALANCE_CLOSED"},
+	{model.ErrBalanceNotZero, codes.FailedPrecondition, "BALANCE_NOT_ZERO"},
+	{model.ErrInvalidStatusTransition, codes.FailedPrecondition, "INVALID_STATUS_TRANSITION"},
+	{model.ErrHoldNotActive, codes.FailedPrecondition, "HOLD_NOT_ACTIVE"},
+	{model.ErrCaptureExceedsHold, codes.FailedPrecondition, "CAPTURE_EXCEEDS_HOLD"},
+	{model.ErrUnauthenticated, codes.Unauthenticated, "UNAUTHENTICATED"},
+	{model.ErrPermissionDenied, codes.PermissionDenied, "PERMISSION_DENIED"},
+	{model.ErrIdempotencyConflict, codes.FailedPrecondition, "IDEMPOTENCY_KEY_REUSED"},
+	{model.ErrIdempotencyInProgress, codes.Aborted, "IDEMPOTENCY_KEY_IN_PROGRESS"},
+	{model.ErrVersionConflict, codes.Aborted, "VERSION_CONFLICT"},
+	{model.ErrConflict, codes.Aborted, "CONCURRENT_UPDATE"},
+	{model.ErrUnavailable, codes.Unavailable, "STORAGE_UNAVAILABLE"},
+	{errShuttingDown, codes.Unavailable, "SHUTTING_DOWN"},
+	{context.DeadlineExceeded, codes.DeadlineExceeded, "DEADLINE_EXCEEDED"},
+	{context.Canceled, codes.Canceled, "CANCELED"},
+}
+
+// statusError converts err into a gRPC status error with an ErrorInfo detail.
+// Errors that are not known domain errors become INTERNAL without exposing their text.
+func statusError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	for _, known := range errorStatuses {
+		if errors.Is(err, known.err) {
+			return withDetails(status.New(known.code, err.Error()), &errdetails.ErrorInfo{Reason: known.reason, Domain: errorDomain})
+		}
+	}
+	return withDetails(status.New(codes.Internal, "internal error"), &errdetails.ErrorInfo{Reason: "INTERNAL", Domain: errorDomain})
+}
+
+// invalidArgument returns an INVALID_ARGUMENT status error with a BadRequest detail pointing at field
+func invalidArgument(field string, err error) error {
+	return withDetails(status.New(codes.InvalidArgument, err.Error()), &errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: field, Description: err.Error()}},
+	})
+}
+
+// withDetails attaches details to st and returns it as an error, st is returned as is if the details cannot be encoded
+func withDetails(st *status.Status, details ...protoiface.MessageV1) error {
+	detailed, err := st.WithDetails(details...)
+	if err != nil {
+		logrus.Errorf("WithDetails: %v", err)
+		return st.Err()
+	}
+	return detailed.Err()
+}
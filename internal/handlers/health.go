@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	proto "github.com/eugenshima/balance/proto"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// pingTimeout bounds a single database ping of the health monitor
+const pingTimeout = 3 * time.Second
+
+// Pinger interface represents a database connection whose reachability can be checked
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// HealthMonitor keeps the status of the grpc.health.v1 service in line with database connectivity,
+// the server and BalanceService are NOT_SERVING while the database can't be pinged
+type HealthMonitor struct {
+	pinger Pinger
+	server *health.Server
+}
+
+// NewHealthMonitor constructor for HealthMonitor, the services are NOT_SERVING until the first successful ping
+func NewHealthMonitor(pinger Pinger, server *health.Server) *HealthMonitor {
+	m := &HealthMonitor{pinger: pinger, server: server}
+	m.setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+	return m
+}
+
+// Run pings the database right away and then every interval until ctx is done
+func (m *HealthMonitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check pings the database once and updates the serving status, changes of the status are logged
+func (m *HealthMonitor) Check(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	err := m.pinger.Ping(ctx)
+	status := healthpb.HealthCheckResponse_SERVING
+	if err != nil {
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	previous, _ := m.server.Check(ctx, &healthpb.HealthCheckRequest{})
+	if previous.GetStatus() != status {
+		if err != nil {
+			logrus.Errorf("Ping: %v", err)
+		} else {
+			logrus.Info("database is reachable")
+		}
+	}
+	m.setStatus(status)
+}
+
+// setStatus sets the status of the server as a whole and of BalanceService
+func (m *HealthMonitor) setStatus(status healthpb.HealthCheckResponse_ServingStatus) {
+	m.server.SetServingStatus("", status)
+	m.server.SetServingStatus(proto.BalanceService_ServiceDesc.ServiceName, status)
+}
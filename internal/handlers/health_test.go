@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// fakePinger is a Pinger whose result is set by the test
+type fakePinger struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (p *fakePinger) Ping(context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *fakePinger) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
+
+// TestHealthMonitorFollowsDatabase tests that the services are NOT_SERVING until a ping succeeds and while pings fail
+func TestHealthMonitorFollowsDatabase(t *testing.T) {
+	pinger := &fakePinger{}
+	server := health.NewServer()
+	monitor := NewHealthMonitor(pinger, server)
+	statusOf := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := server.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		return resp.Status
+	}
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, statusOf(""))
+
+	monitor.Check(context.Background())
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, statusOf(""))
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, statusOf("BalanceService"))
+
+	pinger.fail(errors.New("connection refused"))
+	monitor.Check(context.Background())
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, statusOf(""))
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, statusOf("BalanceService"))
+
+	pinger.fail(nil)
+	monitor.Check(context.Background())
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, statusOf("BalanceService"))
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/eugenshima/balance/internal/auth"
+	"github.com/eugenshima/balance/internal/model"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	protobuf "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// IdempotencyKeyHeader is the gRPC metadata key a client can send instead of the IdempotencyKey request field
+const IdempotencyKeyHeader = "idempotency-key"
+
+// idempotencyStoreTimeout bounds storing the response or releasing the key after an RPC. Both run without the
+// cancellation of the RPC, so a client that gives up on a request doesn't leave its key reserved.
+const idempotencyStoreTimeout = 5 * time.Second
+
+// idempotencyPruneInterval is how often RunIdempotencyKeyRetention deletes expired keys
+const idempotencyPruneInterval = 10 * time.Minute
+
+// mutatingMethods lists the RPCs that change balances and therefore honour idempotency keys
+var mutatingMethods = map[string]bool{
+	"/BalanceService/UpdateUserBalance": true,
+	"/BalanceService/CreateUserBalance": true,
+	"/BalanceService/DeleteUserBalance": true,
+	"/BalanceService/Deposit":           true,
+	"/BalanceService/Withdraw":          true,
+	"/BalanceService/Transfer":          true,
+	"/BalanceService/CreateHold":        true,
+	"/BalanceService/CaptureHold":       true,
+	"/BalanceService/ReleaseHold":       true,
+	"/BalanceService/FreezeBalance":     true,
+	"/BalanceService/UnfreezeBalance":   true,
+	"/BalanceService/CloseBalance":      true,
+	"/BalanceService/SetCreditLimit":    true,
+}
+
+//go:generate /home/yauhenishymanski/work/bin/mockery --name=IdempotencyStore --case=underscore --output=./mocks
+
+// IdempotencyStore interface represents the storage of idempotency keys
+type IdempotencyStore interface {
+	ReserveIdempotencyKey(ctx context.Context, record *model.IdempotencyRecord, lease time.Duration) (*model.IdempotencyRecord, error)
+	CompleteIdempotencyKey(ctx context.Context, key string, response []byte) error
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+}
+
+// IdempotencyKeyPruner interface represents a storage of idempotency keys that deletes old keys
+type IdempotencyKeyPruner interface {
+	DeleteIdempotencyKeys(ctx context.Context, retention time.Duration) (int64, error)
+}
+
+// idempotencyKeyGetter is implemented by every mutating request message
+type idempotencyKeyGetter interface {
+	GetIdempotencyKey() string
+}
+
+// NewIdempotencyInterceptor creates an interceptor that executes a mutating RPC at most once per idempotency key.
+// The first request reserves the key, its response is stored and replayed for duplicates, a failed request
+// releases the key so it can be retried. Reusing a key with a different request returns model.ErrIdempotencyConflict.
+// A key still reserved without a response after lease, e.g. by a process that died, is taken over by the next request,
+// so lease must be longer than any RPC runs. Keys are scoped to the authenticated subject, so callers cannot replay
+// each other's responses. Requests without a key are executed as usual.
+func NewIdempotencyInterceptor(store IdempotencyStore, lease time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !mutatingMethods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		key := idempotencyKey(ctx, req)
+		if key == "" {
+			return handler(ctx, req)
+		}
+		key = scopedKey(ctx, key)
+		hash, err := requestHash(info.FullMethod, req)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"method": info.FullMethod}).Errorf("requestHash: %v", err)
+			return nil, statusError(fmt.Errorf("requestHash: %w", err))
+		}
+		existing, err := store.ReserveIdempotencyKey(ctx, &model.IdempotencyRecord{Key: key, RequestHash: hash}, lease)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"key": key}).Errorf("ReserveIdempotencyKey: %v", err)
+			return nil, statusError(fmt.Errorf("ReserveIdempotencyKey: %w", err))
+		}
+		if existing != nil {
+			resp, replayErr := replayResponse(key, hash, existing)
+			if replayErr != nil {
+				return nil, statusError(replayErr)
+			}
+			return resp, nil
+		}
+		resp, err := handler(ctx, req)
+		storeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), idempotencyStoreTimeout)
+		defer cancel()
+		if err != nil {
+			releaseErr := store.ReleaseIdempotencyKey(storeCtx, key)
+			if releaseErr != nil {
+				logrus.WithFields(logrus.Fields{"key": key}).Errorf("ReleaseIdempotencyKey: %v", releaseErr)
+			}
+			return nil, err
+		}
+		stored, err := anypb.New(resp.(protobuf.Message))
+		if err == nil {
+			var response []byte
+			response, err = protobuf.Marshal(stored)
+			if err == nil {
+				err = store.CompleteIdempotencyKey(storeCtx, key, response)
+			}
+		}
+		if err != nil {
+			// the RPC already succeeded, the key stays reserved so a retry cannot apply it twice
+			logrus.WithFields(logrus.Fields{"key": key}).Errorf("CompleteIdempotencyKey: %v", err)
+		}
+		return resp, nil
+	}
+}
+
+// RunIdempotencyKeyRetention deletes the keys created more than retention ago right away and then every
+// idempotencyPruneInterval until ctx is done
+func RunIdempotencyKeyRetention(ctx context.Context, pruner IdempotencyKeyPruner, retention time.Duration) {
+	ticker := time.NewTicker(idempotencyPruneInterval)
+	defer ticker.Stop()
+	for {
+		deleted, err := pruner.DeleteIdempotencyKeys(ctx, retention)
+		if err != nil && ctx.Err() == nil {
+			logrus.Errorf("DeleteIdempotencyKeys: %v", err)
+		} else if deleted > 0 {
+			logrus.WithFields(logrus.Fields{"deleted": deleted}).Info("deleted expired idempotency keys")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// idempotencyKey returns the key from the request field, or from the gRPC metadata when the field is empty
+func idempotencyKey(ctx context.Context, req interface{}) string {
+	if getter, ok := req.(idempotencyKeyGetter); ok && getter.GetIdempotencyKey() != "" {
+		return getter.GetIdempotencyKey()
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get(IdempotencyKeyHeader)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// scopedKey returns the storage key of key for the caller in ctx. The subject is length-prefixed so that no two
+// subject and key pairs share a storage key, callers without claims share the empty subject.
+func scopedKey(ctx context.Context, key string) string {
+	var subject string
+	if claims, ok := auth.FromContext(ctx); ok {
+		subject = claims.Subject
+	}
+	return fmt.Sprintf("%d:%s:%s", len(subject), subject, key)
+}
+
+// requestHash hashes the method name and the deterministic encoding of the request
+func requestHash(method string, req interface{}) ([]byte, error) {
+	msg, ok := req.(protobuf.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a proto message", req)
+	}
+	body, err := protobuf.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("Marshal: %w", err)
+	}
+	sum := sha256.New()
+	sum.Write([]byte(method))
+	sum.Write([]byte{0})
+	sum.Write(body)
+	return sum.Sum(nil), nil
+}
+
+// replayResponse returns the stored response of a duplicate request
+func replayResponse(key string, hash []byte, existing *model.IdempotencyRecord) (interface{}, error) {
+	if !bytes.Equal(existing.RequestHash, hash) {
+		logrus.WithFields(logrus.Fields{"key": key}).Errorf("replayResponse: %v", model.ErrIdempotencyConflict)
+		return nil, fmt.Errorf("replayResponse: %w", model.ErrIdempotencyConflict)
+	}
+	if existing.Response == nil {
+		return nil, fmt.Errorf("replayResponse: %w", model.ErrIdempotencyInProgress)
+	}
+	stored := &anypb.Any{}
+	err := protobuf.Unmarshal(existing.Response, stored)
+	if err != nil {
+		return nil, fmt.Errorf("Unmarshal: %w", err)
+	}
+	resp, err := stored.UnmarshalNew()
+	if err != nil {
+		return nil, fmt.Errorf("UnmarshalNew: %w", err)
+	}
+	return resp, nil
+}
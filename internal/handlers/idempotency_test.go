@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/balance/internal/auth"
+	"github.com/eugenshima/balance/internal/handlers/mocks"
+	"github.com/eugenshima/balance/internal/model"
+	proto "github.com/eugenshima/balance/proto"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// memoryIdempotencyStore keeps idempotency records in a map
+type memoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]*model.IdempotencyRecord
+}
+
+func (s *memoryIdempotencyStore) ReserveIdempotencyKey(_ context.Context, record *model.IdempotencyRecord, lease time.Duration) (*model.IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.records[record.Key]; ok && (existing.Response != nil || time.Since(existing.ReservedAt) <= lease) {
+		return existing, nil
+	}
+	record.ReservedAt = time.Now()
+	s.records[record.Key] = record
+	return nil, nil
+}
+
+func (s *memoryIdempotencyStore) CompleteIdempotencyKey(_ context.Context, key string, response []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[key].Response = response
+	return nil
+}
+
+func (s *memoryIdempotencyStore) ReleaseIdempotencyKey(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
+
+func newIdempotentTestClient(t *testing.T, srv BalanceService) proto.BalanceServiceClient {
+	store := &memoryIdempotencyStore{records: map[string]*model.IdempotencyRecord{}}
+	return newTestClient(t, srv, grpc.ChainUnaryInterceptor(NewIdempotencyInterceptor(store, time.Minute)))
+}
+
+func TestIdempotentDepositIsAppliedOnce(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	client := newIdempotentTestClient(t, srv)
+	profileID := uuid.New()
+	srv.On("Deposit", mock.Anything, profileID, model.Currency("USD"), model.MustParseMoney("5"), "").
+		Return(&model.Balance{ProfileID: profileID, Balance: model.MustParseMoney("15")}, nil).Once()
+
+	req := &proto.DepositRequest{ProfileID: profileID.String(), Currency: "USD", Amount: moneyToProto(model.MustParseMoney("5")), IdempotencyKey: "deposit-1"}
+	first, err := client.Deposit(context.Background(), req)
+	require.NoError(t, err)
+	second, err := client.Deposit(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, first.Balance.Balance.String(), second.Balance.Balance.String())
+
+	req.Amount = moneyToProto(model.MustParseMoney("6"))
+	_, err = client.Deposit(context.Background(), req)
+	require.ErrorContains(t, err, model.ErrIdempotencyConflict.Error())
+}
+
+func TestIdempotencyKeyFromMetadata(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	client := newIdempotentTestClient(t, srv)
+	profileID := uuid.New()
+	srv.On("CreateBalance", mock.Anything, mock.AnythingOfType("*model.Balance")).Return(nil).Once()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), IdempotencyKeyHeader, "create-1")
+	req := &proto.CreateBalanceRequest{Balance: &proto.Balance{ProfileID: profileID.String(), Currency: "USD"}}
+	_, err := client.CreateUserBalance(ctx, req)
+	require.NoError(t, err)
+	_, err = client.CreateUserBalance(ctx, req)
+	require.NoError(t, err)
+}
+
+func TestFailedRequestReleasesIdempotencyKey(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	client := newIdempotentTestClient(t, srv)
+	profileID := uuid.New()
+	srv.On("Withdraw", mock.Anything, profileID, model.Currency("USD"), model.MustParseMoney("1"), "").Return(nil, errors.New("connection reset")).Once()
+	srv.On("Withdraw", mock.Anything, profileID, model.Currency("USD"), model.MustParseMoney("1"), "").
+		Return(&model.Balance{ProfileID: profileID, Balance: model.MustParseMoney("0")}, nil).Once()
+
+	req := &proto.WithdrawRequest{ProfileID: profileID.String(), Currency: "USD", Amount: moneyToProto(model.MustParseMoney("1")), IdempotencyKey: "withdraw-1"}
+	_, err := client.Withdraw(context.Background(), req)
+	require.Error(t, err)
+	_, err = client.Withdraw(context.Background(), req)
+	require.NoError(t, err)
+	_, err = client.Withdraw(context.Background(), req)
+	require.NoError(t, err)
+}
+
+func TestIdempotencyKeysAreScopedToSubject(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	store := &memoryIdempotencyStore{records: map[string]*model.IdempotencyRecord{}}
+	verifier := auth.NewVerifier("", "", []auth.Key{{Key: testJWTSecret}})
+	client := newTestClient(t, srv, grpc.ChainUnaryInterceptor(NewAuthInterceptor(verifier, nil), NewIdempotencyInterceptor(store, time.Minute)))
+	profileID := uuid.New()
+	srv.On("Deposit", mock.Anything, profileID, model.Currency("USD"), model.MustParseMoney("5"), "").
+		Return(&model.Balance{ProfileID: profileID, Balance: model.MustParseMoney("5")}, nil).Once()
+	srv.On("Deposit", mock.Anything, profileID, model.Currency("USD"), model.MustParseMoney("5"), "").
+		Return(&model.Balance{ProfileID: profileID, Balance: model.MustParseMoney("10")}, nil).Once()
+
+	req := &proto.DepositRequest{ProfileID: profileID.String(), Currency: "USD", Amount: moneyToProto(model.MustParseMoney("5")), IdempotencyKey: "deposit-1"}
+	first, err := client.Deposit(withToken(t, "billing", auth.RoleService), req)
+	require.NoError(t, err)
+	second, err := client.Deposit(withToken(t, "payouts", auth.RoleService), req)
+	require.NoError(t, err)
+	require.NotEqual(t, first.Balance.Balance.String(), second.Balance.Balance.String())
+	replayed, err := client.Deposit(withToken(t, "billing", auth.RoleService), req)
+	require.NoError(t, err)
+	require.Equal(t, first.Balance.Balance.String(), replayed.Balance.Balance.String())
+	require.Len(t, store.records, 2)
+}
+
+func TestIdempotencyKeyIsSettledAfterClientCancels(t *testing.T) {
+	store := mocks.NewIdempotencyStore(t)
+	interceptor := NewIdempotencyInterceptor(store, time.Minute)
+	info := &grpc.UnaryServerInfo{FullMethod: "/BalanceService/Deposit"}
+	live := mock.MatchedBy(func(ctx context.Context) bool { return ctx.Err() == nil })
+	store.On("ReserveIdempotencyKey", mock.Anything, mock.Anything, time.Minute).Return(nil, nil).Twice()
+	store.On("CompleteIdempotencyKey", live, "0::deposit-1", mock.Anything).Return(nil).Once()
+	store.On("ReleaseIdempotencyKey", live, "0::deposit-2").Return(nil).Once()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := interceptor(ctx, &proto.DepositRequest{IdempotencyKey: "deposit-1"}, info, func(ctx context.Context, _ interface{}) (interface{}, error) {
+		cancel()
+		return &proto.DepositResponse{}, nil
+	})
+	require.NoError(t, err)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	_, err = interceptor(ctx, &proto.DepositRequest{IdempotencyKey: "deposit-2"}, info, func(ctx context.Context, _ interface{}) (interface{}, error) {
+		cancel()
+		return nil, ctx.Err()
+	})
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestStaleIdempotencyKeyIsReclaimed(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	store := &memoryIdempotencyStore{records: map[string]*model.IdempotencyRecord{}}
+	client := newTestClient(t, srv, grpc.ChainUnaryInterceptor(NewIdempotencyInterceptor(store, time.Minute)))
+	profileID := uuid.New()
+	srv.On("Deposit", mock.Anything, profileID, model.Currency("USD"), model.MustParseMoney("5"), "").
+		Return(&model.Balance{ProfileID: profileID, Balance: model.MustParseMoney("5")}, nil).Once()
+	fresh := &proto.DepositRequest{ProfileID: profileID.String(), Currency: "USD", Amount: moneyToProto(model.MustParseMoney("5")), IdempotencyKey: "fresh"}
+	stale := &proto.DepositRequest{ProfileID: profileID.String(), Currency: "USD", Amount: moneyToProto(model.MustParseMoney("5")), IdempotencyKey: "stale"}
+	for req, reservedAt := range map[*proto.DepositRequest]time.Time{fresh: time.Now(), stale: time.Now().Add(-2 * time.Minute)} {
+		hash, err := requestHash("/BalanceService/Deposit", req)
+		require.NoError(t, err)
+		key := "0::" + req.IdempotencyKey
+		store.records[key] = &model.IdempotencyRecord{Key: key, RequestHash: hash, ReservedAt: reservedAt}
+	}
+
+	_, err := client.Deposit(context.Background(), fresh)
+	require.ErrorContains(t, err, model.ErrIdempotencyInProgress.Error())
+	_, err = client.Deposit(context.Background(), stale)
+	require.NoError(t, err)
+	require.NotNil(t, store.records["0::stale"].Response)
+}
+
+// idempotencyPrunerFunc adapts a function to IdempotencyKeyPruner
+type idempotencyPrunerFunc func(ctx context.Context, retention time.Duration) (int64, error)
+
+func (f idempotencyPrunerFunc) DeleteIdempotencyKeys(ctx context.Context, retention time.Duration) (int64, error) {
+	return f(ctx, retention)
+}
+
+func TestRunIdempotencyKeyRetention(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var retentions []time.Duration
+	RunIdempotencyKeyRetention(ctx, idempotencyPrunerFunc(func(_ context.Context, retention time.Duration) (int64, error) {
+		retentions = append(retentions, retention)
+		cancel()
+		return 3, nil
+	}), 24*time.Hour)
+	require.Equal(t, []time.Duration{24 * time.Hour}, retentions)
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// RPCMetrics counts the RPCs served and records their latency by method and status code,
+// it is a prometheus.Collector
+type RPCMetrics struct {
+	handled *prometheus.CounterVec
+	latency *prometheus.HistogramVec
+}
+
+// NewRPCMetrics constructor for RPCMetrics
+func NewRPCMetrics() *RPCMetrics {
+	return &RPCMetrics{
+		handled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_handled_total",
+			Help: "RPCs completed on the server by method and status code.",
+		}, []string{"grpc_method", "grpc_code"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "grpc_server_handling_seconds",
+			Help:    "Time the server took to complete RPCs by method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"grpc_method"}),
+	}
+}
+
+// Describe sends the descriptors of the RPC counters and latency histograms
+func (m *RPCMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.handled.Describe(ch)
+	m.latency.Describe(ch)
+}
+
+// Collect sends the RPC counters and latency histograms
+func (m *RPCMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.handled.Collect(ch)
+	m.latency.Collect(ch)
+}
+
+// UnaryInterceptor creates an interceptor that records every unary RPC, it comes first in the chain
+// so that requests rejected by later interceptors are counted too
+func (m *RPCMetrics) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		m.observe(info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// StreamInterceptor creates the streaming counterpart of UnaryInterceptor, a stream is recorded when it ends
+func (m *RPCMetrics) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		m.observe(info.FullMethod, start, err)
+		return err
+	}
+}
+
+// observe records an RPC that started at start and ended with err
+func (m *RPCMetrics) observe(method string, start time.Time, err error) {
+	m.handled.WithLabelValues(method, status.Code(err).String()).Inc()
+	m.latency.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
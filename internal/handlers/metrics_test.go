@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/eugenshima/balance/internal/handlers/mocks"
+	"github.com/eugenshima/balance/internal/model"
+	proto "github.com/eugenshima/balance/proto"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// TestRPCMetricsCountByCode tests that RPCs are counted by method and status code and their latency is recorded
+func TestRPCMetricsCountByCode(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	rpcMetrics := NewRPCMetrics()
+	client := newTestClient(t, srv, grpc.ChainUnaryInterceptor(rpcMetrics.UnaryInterceptor()))
+	profileID := uuid.New()
+	srv.On("GetUserByID", mock.Anything, profileID, model.Currency("USD")).Return(&model.Balance{ProfileID: profileID, Currency: "USD"}, nil).Once()
+	srv.On("GetUserByID", mock.Anything, mock.Anything, model.Currency("USD")).Return(nil, model.ErrNotFound).Once()
+
+	_, err := client.GetUserByID(context.Background(), &proto.UserGetByIDRequest{ProfileID: profileID.String(), Currency: "USD"})
+	require.NoError(t, err)
+	_, err = client.GetUserByID(context.Background(), &proto.UserGetByIDRequest{ProfileID: uuid.New().String(), Currency: "USD"})
+	require.Error(t, err)
+	_, err = client.GetUserByID(context.Background(), &proto.UserGetByIDRequest{ProfileID: "not a uuid", Currency: "USD"})
+	require.Error(t, err)
+
+	method := "/BalanceService/GetUserByID"
+	for code, count := range map[string]float64{"OK": 1, "NotFound": 1, "InvalidArgument": 1} {
+		require.Equal(t, count, testutil.ToFloat64(rpcMetrics.handled.WithLabelValues(method, code)), code)
+	}
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(rpcMetrics)
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 2)
+	require.Equal(t, "grpc_server_handling_seconds", families[1].GetName())
+	require.Equal(t, uint64(3), families[1].GetMetric()[0].GetHistogram().GetSampleCount())
+}
@@ -17,6 +17,61 @@ type BalanceService struct {
 	mock.Mock
 }
 
+// CaptureHold provides a mock function with given fields: ctx, holdID, amount
+func (_m *BalanceService) CaptureHold(ctx context.Context, holdID uuid.UUID, amount model.Money) (*model.Hold, *model.Balance, error) {
+	ret := _m.Called(ctx, holdID, amount)
+
+	var r0 *model.Hold
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, model.Money) *model.Hold); ok {
+		r0 = rf(ctx, holdID, amount)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Hold)
+		}
+	}
+
+	var r1 *model.Balance
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID, model.Money) *model.Balance); ok {
+		r1 = rf(ctx, holdID, amount)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*model.Balance)
+		}
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, uuid.UUID, model.Money) error); ok {
+		r2 = rf(ctx, holdID, amount)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// CloseBalance provides a mock function with given fields: ctx, change
+func (_m *BalanceService) CloseBalance(ctx context.Context, change *model.StatusChange) (*model.Balance, error) {
+	ret := _m.Called(ctx, change)
+
+	var r0 *model.Balance
+	if rf, ok := ret.Get(0).(func(context.Context, *model.StatusChange) *model.Balance); ok {
+		r0 = rf(ctx, change)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Balance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *model.StatusChange) error); ok {
+		r1 = rf(ctx, change)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // CreateBalance provides a mock function with given fields: ctx, user
 func (_m *BalanceService) CreateBalance(ctx context.Context, user *model.Balance) error {
 	ret := _m.Called(ctx, user)
@@ -31,13 +86,13 @@ func (_m *BalanceService) CreateBalance(ctx context.Context, user *model.Balance
 	return r0
 }
 
-// DeleteBalance provides a mock function with given fields: ctx, userID
-func (_m *BalanceService) DeleteBalance(ctx context.Context, userID uuid.UUID) error {
-	ret := _m.Called(ctx, userID)
+// CreateHold provides a mock function with given fields: ctx, hold
+func (_m *BalanceService) CreateHold(ctx context.Context, hold *model.Hold) error {
+	ret := _m.Called(ctx, hold)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) error); ok {
-		r0 = rf(ctx, userID)
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Hold) error); ok {
+		r0 = rf(ctx, hold)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -45,22 +100,100 @@ func (_m *BalanceService) DeleteBalance(ctx context.Context, userID uuid.UUID) e
 	return r0
 }
 
-// GetAllBalances provides a mock function with given fields: ctx
-func (_m *BalanceService) GetAllBalances(ctx context.Context) ([]*model.Balance, error) {
-	ret := _m.Called(ctx)
+// Deposit provides a mock function with given fields: ctx, profileID, currency, amount, reference
+func (_m *BalanceService) Deposit(ctx context.Context, profileID uuid.UUID, currency model.Currency, amount model.Money, reference string) (*model.Balance, error) {
+	ret := _m.Called(ctx, profileID, currency, amount, reference)
+
+	var r0 *model.Balance
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, model.Currency, model.Money, string) *model.Balance); ok {
+		r0 = rf(ctx, profileID, currency, amount, reference)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Balance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID, model.Currency, model.Money, string) error); ok {
+		r1 = rf(ctx, profileID, currency, amount, reference)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FreezeBalance provides a mock function with given fields: ctx, change
+func (_m *BalanceService) FreezeBalance(ctx context.Context, change *model.StatusChange) (*model.Balance, error) {
+	ret := _m.Called(ctx, change)
+
+	var r0 *model.Balance
+	if rf, ok := ret.Get(0).(func(context.Context, *model.StatusChange) *model.Balance); ok {
+		r0 = rf(ctx, change)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Balance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *model.StatusChange) error); ok {
+		r1 = rf(ctx, change)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAllBalances provides a mock function with given fields: ctx, filter
+func (_m *BalanceService) GetAllBalances(ctx context.Context, filter model.BalanceFilter) ([]*model.Balance, *model.BalanceCursor, error) {
+	ret := _m.Called(ctx, filter)
 
 	var r0 []*model.Balance
-	if rf, ok := ret.Get(0).(func(context.Context) []*model.Balance); ok {
-		r0 = rf(ctx)
+	if rf, ok := ret.Get(0).(func(context.Context, model.BalanceFilter) []*model.Balance); ok {
+		r0 = rf(ctx, filter)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]*model.Balance)
 		}
 	}
 
+	var r1 *model.BalanceCursor
+	if rf, ok := ret.Get(1).(func(context.Context, model.BalanceFilter) *model.BalanceCursor); ok {
+		r1 = rf(ctx, filter)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*model.BalanceCursor)
+		}
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, model.BalanceFilter) error); ok {
+		r2 = rf(ctx, filter)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// GetUserByID provides a mock function with given fields: ctx, userID, currency
+func (_m *BalanceService) GetUserByID(ctx context.Context, userID uuid.UUID, currency model.Currency) (*model.Balance, error) {
+	ret := _m.Called(ctx, userID, currency)
+
+	var r0 *model.Balance
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, model.Currency) *model.Balance); ok {
+		r0 = rf(ctx, userID, currency)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Balance)
+		}
+	}
+
 	var r1 error
-	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
-		r1 = rf(ctx)
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID, model.Currency) error); ok {
+		r1 = rf(ctx, userID, currency)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -68,13 +201,66 @@ func (_m *BalanceService) GetAllBalances(ctx context.Context) ([]*model.Balance,
 	return r0, r1
 }
 
-// GetUserByID provides a mock function with given fields: ctx, userID
-func (_m *BalanceService) GetUserByID(ctx context.Context, userID uuid.UUID) (*model.Balance, error) {
-	ret := _m.Called(ctx, userID)
+// ListTransactions provides a mock function with given fields: ctx, filter
+func (_m *BalanceService) ListTransactions(ctx context.Context, filter model.LedgerFilter) ([]*model.LedgerEntry, int64, error) {
+	ret := _m.Called(ctx, filter)
+
+	var r0 []*model.LedgerEntry
+	if rf, ok := ret.Get(0).(func(context.Context, model.LedgerFilter) []*model.LedgerEntry); ok {
+		r0 = rf(ctx, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.LedgerEntry)
+		}
+	}
+
+	var r1 int64
+	if rf, ok := ret.Get(1).(func(context.Context, model.LedgerFilter) int64); ok {
+		r1 = rf(ctx, filter)
+	} else {
+		r1 = ret.Get(1).(int64)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, model.LedgerFilter) error); ok {
+		r2 = rf(ctx, filter)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// ReleaseHold provides a mock function with given fields: ctx, holdID
+func (_m *BalanceService) ReleaseHold(ctx context.Context, holdID uuid.UUID) (*model.Hold, error) {
+	ret := _m.Called(ctx, holdID)
+
+	var r0 *model.Hold
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) *model.Hold); ok {
+		r0 = rf(ctx, holdID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Hold)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
+		r1 = rf(ctx, holdID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SetCreditLimit provides a mock function with given fields: ctx, profileID, currency, creditLimit
+func (_m *BalanceService) SetCreditLimit(ctx context.Context, profileID uuid.UUID, currency model.Currency, creditLimit model.Money) (*model.Balance, error) {
+	ret := _m.Called(ctx, profileID, currency, creditLimit)
 
 	var r0 *model.Balance
-	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) *model.Balance); ok {
-		r0 = rf(ctx, userID)
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, model.Currency, model.Money) *model.Balance); ok {
+		r0 = rf(ctx, profileID, currency, creditLimit)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*model.Balance)
@@ -82,8 +268,77 @@ func (_m *BalanceService) GetUserByID(ctx context.Context, userID uuid.UUID) (*m
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
-		r1 = rf(ctx, userID)
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID, model.Currency, model.Money) error); ok {
+		r1 = rf(ctx, profileID, currency, creditLimit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// StreamAllBalances provides a mock function with given fields: ctx, fn
+func (_m *BalanceService) StreamAllBalances(ctx context.Context, fn func(*model.Balance) error) error {
+	ret := _m.Called(ctx, fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, func(*model.Balance) error) error); ok {
+		r0 = rf(ctx, fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Transfer provides a mock function with given fields: ctx, transfer
+func (_m *BalanceService) Transfer(ctx context.Context, transfer *model.Transfer) (*model.Balance, *model.Balance, error) {
+	ret := _m.Called(ctx, transfer)
+
+	var r0 *model.Balance
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Transfer) *model.Balance); ok {
+		r0 = rf(ctx, transfer)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Balance)
+		}
+	}
+
+	var r1 *model.Balance
+	if rf, ok := ret.Get(1).(func(context.Context, *model.Transfer) *model.Balance); ok {
+		r1 = rf(ctx, transfer)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*model.Balance)
+		}
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, *model.Transfer) error); ok {
+		r2 = rf(ctx, transfer)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// UnfreezeBalance provides a mock function with given fields: ctx, change
+func (_m *BalanceService) UnfreezeBalance(ctx context.Context, change *model.StatusChange) (*model.Balance, error) {
+	ret := _m.Called(ctx, change)
+
+	var r0 *model.Balance
+	if rf, ok := ret.Get(0).(func(context.Context, *model.StatusChange) *model.Balance); ok {
+		r0 = rf(ctx, change)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Balance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *model.StatusChange) error); ok {
+		r1 = rf(ctx, change)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -105,6 +360,43 @@ func (_m *BalanceService) UpdateBalance(ctx context.Context, user *model.Balance
 	return r0
 }
 
+// WatchBalance provides a mock function with given fields: ctx, profileID, currency, afterSequence, fn
+func (_m *BalanceService) WatchBalance(ctx context.Context, profileID uuid.UUID, currency model.Currency, afterSequence int64, fn func(*model.LedgerEntry) error) error {
+	ret := _m.Called(ctx, profileID, currency, afterSequence, fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, model.Currency, int64, func(*model.LedgerEntry) error) error); ok {
+		r0 = rf(ctx, profileID, currency, afterSequence, fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Withdraw provides a mock function with given fields: ctx, profileID, currency, amount, reference
+func (_m *BalanceService) Withdraw(ctx context.Context, profileID uuid.UUID, currency model.Currency, amount model.Money, reference string) (*model.Balance, error) {
+	ret := _m.Called(ctx, profileID, currency, amount, reference)
+
+	var r0 *model.Balance
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, model.Currency, model.Money, string) *model.Balance); ok {
+		r0 = rf(ctx, profileID, currency, amount, reference)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Balance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID, model.Currency, model.Money, string) error); ok {
+		r1 = rf(ctx, profileID, currency, amount, reference)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 type mockConstructorTestingTNewBalanceService interface {
 	mock.TestingT
 	Cleanup(func())
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc"
+)
+
+// errShuttingDown ends the streams still open when the server shuts down
+var errShuttingDown = errors.New("server is shutting down")
+
+// NewShutdownStreamInterceptor creates an interceptor that cancels every stream once shutdown is done.
+// Streams such as WatchBalance never end on their own, so without it a graceful stop would wait for its timeout.
+// The streams end with UNAVAILABLE and clients reconnect to another replica.
+func NewShutdownStreamInterceptor(shutdown context.Context) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, cancel := context.WithCancel(ss.Context())
+		defer cancel()
+		stop := context.AfterFunc(shutdown, cancel)
+		defer stop()
+		err := handler(srv, &shutdownStream{ServerStream: ss, ctx: ctx})
+		if err != nil && shutdown.Err() != nil && ss.Context().Err() == nil {
+			return statusError(errShuttingDown)
+		}
+		return err
+	}
+}
+
+// shutdownStream is a grpc.ServerStream whose context is canceled on shutdown
+type shutdownStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the stream context that is canceled on shutdown
+func (s *shutdownStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/eugenshima/balance/internal/handlers/mocks"
+	"github.com/eugenshima/balance/internal/model"
+	proto "github.com/eugenshima/balance/proto"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestShutdownEndsStreams tests that open streams end with UNAVAILABLE as soon as shutdown begins
+func TestShutdownEndsStreams(t *testing.T) {
+	srv := mocks.NewBalanceService(t)
+	shutdown, beginShutdown := context.WithCancel(context.Background())
+	defer beginShutdown()
+	client := newTestClient(t, srv, grpc.ChainStreamInterceptor(NewShutdownStreamInterceptor(shutdown)))
+	profileID := uuid.New()
+	srv.On("WatchBalance", mock.Anything, profileID, model.Currency("EUR"), int64(0), mock.Anything).
+		Return(func(ctx context.Context, _ uuid.UUID, _ model.Currency, _ int64, fn func(*model.LedgerEntry) error) error {
+			if err := fn(&model.LedgerEntry{Sequence: 1, ProfileID: profileID}); err != nil {
+				return err
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		}).Once()
+
+	stream, err := client.WatchBalance(context.Background(), &proto.WatchBalanceRequest{ProfileID: profileID.String(), Currency: "EUR"})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	beginShutdown()
+	_, err = stream.Recv()
+	st := status.Convert(err)
+	require.Equal(t, codes.Unavailable, st.Code())
+	info, ok := st.Details()[0].(*errdetails.ErrorInfo)
+	require.True(t, ok)
+	require.Equal(t, "SHUTTING_DOWN", info.Reason)
+}
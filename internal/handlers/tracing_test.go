@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/eugenshima/balance/internal/handlers/mocks"
+	"github.com/eugenshima/balance/internal/model"
+	proto "github.com/eugenshima/balance/proto"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// TestTracingContinuesIncomingTrace tests that the server span of an RPC continues the trace of the traceparent
+// metadata and that the service is called with the server span in its context
+func TestTracingContinuesIncomingTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	srv := mocks.NewBalanceService(t)
+	client := newTestClient(t, srv, grpc.StatsHandler(otelgrpc.NewServerHandler(
+		otelgrpc.WithTracerProvider(provider), otelgrpc.WithPropagators(propagation.TraceContext{}))))
+	profileID := uuid.New()
+	var serviceSpan trace.SpanContext
+	srv.On("GetUserByID", mock.Anything, profileID, model.Currency("USD")).Run(func(args mock.Arguments) {
+		serviceSpan = trace.SpanContextFromContext(args.Get(0).(context.Context))
+	}).Return(&model.Balance{ProfileID: profileID, Currency: "USD"}, nil).Once()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(),
+		"traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, err := client.GetUserByID(ctx, &proto.UserGetByIDRequest{ProfileID: profileID.String(), Currency: "USD"})
+	require.NoError(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(t, "BalanceService/GetUserByID", spans[0].Name())
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext().TraceID().String())
+	require.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+	require.True(t, spans[0].Parent().IsRemote())
+	require.Equal(t, spans[0].SpanContext().SpanID(), serviceSpan.SpanID())
+}
@@ -0,0 +1,49 @@
+UPDATE shares.balance SET balance = 0 WHERE balance IS NULL;
+
+ALTER TABLE shares.balance
+    ALTER COLUMN balance_id SET NOT NULL,
+    ALTER COLUMN profile_id SET NOT NULL,
+    ALTER COLUMN balance TYPE NUMERIC(38, 9),
+    ALTER COLUMN balance SET DEFAULT 0,
+    ALTER COLUMN balance SET NOT NULL,
+    ADD COLUMN currency TEXT NOT NULL DEFAULT current_setting('balance.legacy_currency'),
+    ADD COLUMN held NUMERIC(38, 9) NOT NULL DEFAULT 0,
+    ADD COLUMN credit_limit NUMERIC(38, 9) NOT NULL DEFAULT 0 CHECK (credit_limit >= 0),
+    ADD COLUMN status TEXT NOT NULL DEFAULT 'active',
+    ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+
+ALTER TABLE shares.balance ALTER COLUMN currency DROP DEFAULT;
+
+-- negative balances were allowed before credit limits existed, they keep their overdraft as credit limit
+UPDATE shares.balance SET credit_limit = -balance WHERE balance < 0;
+
+DO $$
+DECLARE
+    profile_column SMALLINT;
+    object_name TEXT;
+BEGIN
+    SELECT attnum INTO profile_column FROM pg_attribute
+        WHERE attrelid = 'shares.balance'::regclass AND attname = 'profile_id';
+    FOR object_name IN SELECT conname FROM pg_constraint
+        WHERE conrelid = 'shares.balance'::regclass AND contype = 'u' AND conkey = ARRAY[profile_column]
+    LOOP
+        EXECUTE format('ALTER TABLE shares.balance DROP CONSTRAINT %I', object_name);
+    END LOOP;
+    FOR object_name IN SELECT indexrelid::regclass::text FROM pg_index
+        WHERE indrelid = 'shares.balance'::regclass AND indisunique AND NOT indisprimary
+            AND indkey::SMALLINT[] = ARRAY[profile_column]
+    LOOP
+        EXECUTE format('DROP INDEX %s', object_name);
+    END LOOP;
+    PERFORM 1 FROM pg_constraint WHERE conrelid = 'shares.balance'::regclass AND contype = 'p';
+    IF NOT FOUND THEN
+        ALTER TABLE shares.balance ADD PRIMARY KEY (balance_id);
+    END IF;
+END
+$$;
+
+ALTER TABLE shares.balance
+    ADD CONSTRAINT balance_profile_currency_key UNIQUE (profile_id, currency),
+    ADD CONSTRAINT balance_within_credit_limit CHECK (balance - held + credit_limit >= 0);
+
+CREATE INDEX balance_balance_idx ON shares.balance (balance, profile_id, currency);
@@ -0,0 +1,367 @@
+// Package migrations applies the versioned SQL schema of the service, the migrations are embedded in the binary
+package migrations
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/eugenshima/balance/internal/model"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/sirupsen/logrus"
+)
+
+// files holds the migrations as sql/<version>_<name>.up.sql and sql/<version>_<name>.down.sql
+//
+//go:embed sql/*.sql
+var files embed.FS
+
+// legacySQL converts the shares.balance table of a schema set up by hand before the migrations existed
+// into the table of legacyVersion, the legacy balances get the currency in the balance.legacy_currency setting
+//
+//go:embed legacy.sql
+var legacySQL string
+
+// legacyVersion is the last migration a legacy schema matches once legacySQL converted it
+const legacyVersion = 2
+
+// legacyColumns are the columns of shares.balance in a legacy schema
+var legacyColumns = []string{"balance_id", "profile_id", "balance"}
+
+// lockKey is the key of the advisory lock held while migrating, so replicas that start together apply each
+// migration once
+const lockKey int64 = 0x62616c616e6365 // "balance"
+
+// versionsTable records the applied migrations, it is outside of the shares schema which the first migration creates
+const versionsTable = "public.balance_schema_migrations"
+
+// fileName matches the names of migration files
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a version of the schema with the SQL to apply and to revert it
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration and when it was applied, AppliedAt is nil for pending migrations
+type Status struct {
+	*Migration
+	AppliedAt *time.Time
+}
+
+// Load reads the migrations in the root of fsys ordered by version, every version needs an up and a down file
+func Load(fsys fs.FS) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("ReadDir: %w", err)
+	}
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			return nil, fmt.Errorf("%s: not a migration, names are <version>_<name>.up.sql or .down.sql", entry.Name())
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("%s: invalid version", entry.Name())
+		}
+		sql, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("ReadFile: %w", err)
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("%s: version %d is also named %s", entry.Name(), version, migration.Name)
+		}
+		if match[3] == "up" {
+			migration.Up = string(sql)
+		} else {
+			migration.Down = string(sql)
+		}
+	}
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Embedded returns the migrations built into the binary
+func Embedded() ([]*Migration, error) {
+	sub, err := fs.Sub(files, "sql")
+	if err != nil {
+		return nil, fmt.Errorf("Sub: %w", err)
+	}
+	return Load(sub)
+}
+
+// Migrator applies and reverts migrations on a database
+type Migrator struct {
+	pool           *pgxpool.Pool
+	migrations     []*Migration
+	legacyCurrency model.Currency
+}
+
+// NewMigrator constructor for Migrator with the embedded migrations, the balances of a legacy schema
+// are converted to legacyCurrency
+func NewMigrator(pool *pgxpool.Pool, legacyCurrency string) (*Migrator, error) {
+	currency, err := model.ParseCurrency(legacyCurrency)
+	if err != nil {
+		return nil, fmt.Errorf("ParseCurrency: %w", err)
+	}
+	migrations, err := Embedded()
+	if err != nil {
+		return nil, fmt.Errorf("Embedded: %w", err)
+	}
+	return &Migrator{pool: pool, migrations: migrations, legacyCurrency: currency}, nil
+}
+
+// Up applies the pending migrations in version order, each in its own transaction, and returns them.
+// A legacy schema, whose shares.balance predates the migrations, is first converted and recorded at legacyVersion.
+func (m *Migrator) Up(ctx context.Context) ([]*Migration, error) {
+	var applied []*Migration
+	err := m.locked(ctx, func(conn *pgxpool.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if len(versions) == 0 {
+			legacy, err := isLegacy(ctx, conn)
+			if err != nil {
+				return err
+			}
+			if legacy {
+				versions, err = m.convertLegacy(ctx, conn)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		for _, migration := range m.migrations {
+			if _, ok := versions[migration.Version]; ok {
+				continue
+			}
+			err = apply(ctx, conn, migration, migration.Up,
+				"INSERT INTO "+versionsTable+" (version, name) VALUES ($1, $2)", migration.Version, migration.Name)
+			if err != nil {
+				return err
+			}
+			logrus.WithFields(logrus.Fields{"version": migration.Version, "name": migration.Name}).Info("applied migration")
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the last steps applied migrations in reverse version order and returns them
+func (m *Migrator) Down(ctx context.Context, steps int) ([]*Migration, error) {
+	var reverted []*Migration
+	err := m.locked(ctx, func(conn *pgxpool.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := versions[migration.Version]; !ok {
+				continue
+			}
+			err = apply(ctx, conn, migration, migration.Down,
+				"DELETE FROM "+versionsTable+" WHERE version = $1", migration.Version)
+			if err != nil {
+				return err
+			}
+			logrus.WithFields(logrus.Fields{"version": migration.Version, "name": migration.Name}).Info("reverted migration")
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Status returns every migration and when it was applied
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.locked(ctx, func(conn *pgxpool.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			status := Status{Migration: migration}
+			if appliedAt, ok := versions[migration.Version]; ok {
+				status.AppliedAt = &appliedAt
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// locked calls fn on a connection that holds the migration lock and has the versions table
+func (m *Migrator) locked(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("Acquire: %w", err)
+	}
+	defer conn.Release()
+	_, err = conn.Exec(ctx, "SELECT pg_advisory_lock($1)", lockKey)
+	if err != nil {
+		return fmt.Errorf("pg_advisory_lock: %w", err)
+	}
+	defer func() {
+		// the lock is released even if ctx is done, otherwise it would stay with the pooled connection
+		_, err := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey)
+		if err != nil {
+			logrus.Errorf("pg_advisory_unlock: %v", err)
+		}
+	}()
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS `+versionsTable+` (
+		version    BIGINT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", versionsTable, err)
+	}
+	return fn(conn)
+}
+
+// isLegacy reports whether shares.balance has the columns of a legacy schema
+func isLegacy(ctx context.Context, conn *pgxpool.Conn) (bool, error) {
+	var columns []string
+	err := conn.QueryRow(ctx, `SELECT coalesce(array_agg(column_name::text ORDER BY ordinal_position), '{}')
+		FROM information_schema.columns WHERE table_schema = 'shares' AND table_name = 'balance'`).Scan(&columns)
+	if err != nil {
+		return false, fmt.Errorf("QueryRow: %w", err)
+	}
+	if len(columns) != len(legacyColumns) {
+		return false, nil
+	}
+	for i, column := range columns {
+		if column != legacyColumns[i] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// convertLegacy converts a legacy schema with legacySQL and records the migrations up to legacyVersion as applied
+// in one transaction, it returns the applied versions
+func (m *Migrator) convertLegacy(ctx context.Context, conn *pgxpool.Conn) (versions map[int64]time.Time, err error) {
+	tx, err := conn.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("BeginTx: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			rollbackErr := tx.Rollback(ctx)
+			if rollbackErr != nil {
+				logrus.Errorf("Rollback: %v", rollbackErr)
+			}
+			return
+		}
+		err = tx.Commit(ctx)
+		if err != nil {
+			err = fmt.Errorf("Commit: %w", err)
+		}
+	}()
+	_, err = tx.Exec(ctx, "SELECT set_config('balance.legacy_currency', $1, true)", string(m.legacyCurrency))
+	if err != nil {
+		return nil, fmt.Errorf("set_config: %w", err)
+	}
+	_, err = tx.Exec(ctx, legacySQL)
+	if err != nil {
+		return nil, fmt.Errorf("convert legacy schema: %w", err)
+	}
+	versions = map[int64]time.Time{}
+	for _, migration := range m.migrations {
+		if migration.Version > legacyVersion {
+			break
+		}
+		var appliedAt time.Time
+		err = tx.QueryRow(ctx, "INSERT INTO "+versionsTable+" (version, name) VALUES ($1, $2) RETURNING applied_at",
+			migration.Version, migration.Name).Scan(&appliedAt)
+		if err != nil {
+			return nil, fmt.Errorf("record migration %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+		versions[migration.Version] = appliedAt
+	}
+	logrus.WithFields(logrus.Fields{"version": legacyVersion, "currency": m.legacyCurrency}).Info("converted legacy schema")
+	return versions, nil
+}
+
+// appliedVersions returns the applied versions and when they were applied
+func appliedVersions(ctx context.Context, conn *pgxpool.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.Query(ctx, "SELECT version, applied_at FROM "+versionsTable)
+	if err != nil {
+		return nil, fmt.Errorf("Query: %w", err)
+	}
+	defer rows.Close()
+	versions := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		err = rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, fmt.Errorf("Scan: %w", err)
+		}
+		versions[version] = appliedAt
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err: %w", err)
+	}
+	return versions, nil
+}
+
+// apply runs sql and the statement that records it in one transaction, the SQL of a migration may hold
+// several statements
+func apply(ctx context.Context, conn *pgxpool.Conn, migration *Migration, sql, record string, args ...interface{}) (err error) {
+	name := fmt.Sprintf("%04d_%s", migration.Version, migration.Name)
+	tx, err := conn.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("BeginTx: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			rollbackErr := tx.Rollback(ctx)
+			if rollbackErr != nil {
+				logrus.Errorf("Rollback: %v", rollbackErr)
+			}
+			return
+		}
+		err = tx.Commit(ctx)
+		if err != nil {
+			err = fmt.Errorf("Commit: %w", err)
+		}
+	}()
+	_, err = tx.Exec(ctx, sql)
+	if err != nil {
+		return fmt.Errorf("migration %s: %w", name, err)
+	}
+	_, err = tx.Exec(ctx, record, args...)
+	if err != nil {
+		return fmt.Errorf("record migration %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package migrations
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestEmbeddedMigrations tests that the embedded migrations load with consecutive versions starting at 1
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := Embedded()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	for i, migration := range migrations {
+		require.Equal(t, int64(i+1), migration.Version, migration.Name)
+		require.NotEmpty(t, migration.Up)
+		require.NotEmpty(t, migration.Down)
+	}
+	require.Equal(t, "create_schema", migrations[0].Name)
+}
+
+// TestEmbeddedMigrationsCreateStrictly tests that no migration silently keeps an existing object of possibly another shape
+func TestEmbeddedMigrationsCreateStrictly(t *testing.T) {
+	migrations, err := Embedded()
+	require.NoError(t, err)
+	for _, migration := range migrations {
+		require.NotContains(t, strings.ToUpper(migration.Up), "IF NOT EXISTS", migration.Name)
+	}
+}
+
+// TestLoadOrdersByVersion tests that migrations are ordered by version rather than by file name
+func TestLoadOrdersByVersion(t *testing.T) {
+	migrations, err := Load(fstest.MapFS{
+		"10_add_index.up.sql":     {Data: []byte("CREATE INDEX")},
+		"10_add_index.down.sql":   {Data: []byte("DROP INDEX")},
+		"9_create_table.up.sql":   {Data: []byte("CREATE TABLE")},
+		"9_create_table.down.sql": {Data: []byte("DROP TABLE")},
+	})
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+	require.Equal(t, &Migration{Version: 9, Name: "create_table", Up: "CREATE TABLE", Down: "DROP TABLE"}, migrations[0])
+	require.Equal(t, int64(10), migrations[1].Version)
+}
+
+// TestLoadRejectsInvalidSets tests that unpaired, misnamed and conflicting migration files are rejected
+func TestLoadRejectsInvalidSets(t *testing.T) {
+	for name, fsys := range map[string]fstest.MapFS{
+		"missing down": {"1_a.up.sql": {Data: []byte("SELECT 1")}},
+		"bad name":     {"create.sql": {Data: []byte("SELECT 1")}},
+		"zero version": {"0_a.up.sql": {Data: []byte("SELECT 1")}, "0_a.down.sql": {Data: []byte("SELECT 1")}},
+		"two names":    {"1_a.up.sql": {Data: []byte("SELECT 1")}, "1_b.down.sql": {Data: []byte("SELECT 1")}},
+	} {
+		_, err := Load(fsys)
+		require.Error(t, err, name)
+	}
+}
+
+// TestNewMigratorRejectsInvalidLegacyCurrency tests that the currency of legacy balances is validated up front
+func TestNewMigratorRejectsInvalidLegacyCurrency(t *testing.T) {
+	_, err := NewMigrator(nil, "usd")
+	require.Error(t, err)
+	migrator, err := NewMigrator(nil, "EUR")
+	require.NoError(t, err)
+	require.Equal(t, "EUR", string(migrator.legacyCurrency))
+	require.NotEmpty(t, legacySQL)
+}
@@ -0,0 +1 @@
+CREATE SCHEMA shares;
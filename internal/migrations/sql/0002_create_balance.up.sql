@@ -0,0 +1,14 @@
+CREATE TABLE shares.balance (
+    balance_id   UUID PRIMARY KEY,
+    profile_id   UUID NOT NULL,
+    currency     TEXT NOT NULL,
+    balance      NUMERIC(38, 9) NOT NULL DEFAULT 0,
+    held         NUMERIC(38, 9) NOT NULL DEFAULT 0,
+    credit_limit NUMERIC(38, 9) NOT NULL DEFAULT 0 CHECK (credit_limit >= 0),
+    status       TEXT NOT NULL DEFAULT 'active',
+    version      BIGINT NOT NULL DEFAULT 1,
+    CONSTRAINT balance_profile_currency_key UNIQUE (profile_id, currency),
+    CONSTRAINT balance_within_credit_limit CHECK (balance - held + credit_limit >= 0)
+);
+
+CREATE INDEX balance_balance_idx ON shares.balance (balance, profile_id, currency);
@@ -0,0 +1,11 @@
+CREATE TABLE shares.balance_status_change (
+    change_id  UUID PRIMARY KEY,
+    profile_id UUID NOT NULL,
+    currency   TEXT NOT NULL,
+    status     TEXT NOT NULL,
+    actor      TEXT NOT NULL,
+    reason     TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX balance_status_change_profile_idx ON shares.balance_status_change (profile_id, created_at);
@@ -0,0 +1,15 @@
+CREATE TABLE shares.ledger (
+    entry_id    UUID PRIMARY KEY,
+    sequence    BIGSERIAL NOT NULL UNIQUE,
+    profile_id  UUID NOT NULL,
+    currency    TEXT NOT NULL,
+    delta       NUMERIC(38, 9) NOT NULL,
+    balance     NUMERIC(38, 9) NOT NULL,
+    reason      TEXT NOT NULL,
+    reference   TEXT NOT NULL DEFAULT '',
+    transfer_id UUID,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX ledger_profile_sequence_idx ON shares.ledger (profile_id, currency, sequence);
+CREATE INDEX ledger_transfer_idx ON shares.ledger (transfer_id) WHERE transfer_id IS NOT NULL;
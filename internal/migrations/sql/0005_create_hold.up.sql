@@ -0,0 +1,13 @@
+CREATE TABLE shares.hold (
+    hold_id    UUID PRIMARY KEY,
+    profile_id UUID NOT NULL,
+    currency   TEXT NOT NULL,
+    amount     NUMERIC(38, 9) NOT NULL,
+    captured   NUMERIC(38, 9) NOT NULL DEFAULT 0,
+    status     TEXT NOT NULL,
+    reference  TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX hold_profile_active_idx ON shares.hold (profile_id) WHERE status = 'active';
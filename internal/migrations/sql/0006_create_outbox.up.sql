@@ -0,0 +1,11 @@
+CREATE TABLE shares.outbox (
+    event_id     UUID PRIMARY KEY,
+    sequence     BIGSERIAL NOT NULL UNIQUE,
+    event_type   TEXT NOT NULL,
+    profile_id   UUID NOT NULL,
+    payload      JSONB NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    published_at TIMESTAMPTZ
+);
+
+CREATE INDEX outbox_unpublished_idx ON shares.outbox (sequence) WHERE published_at IS NULL;
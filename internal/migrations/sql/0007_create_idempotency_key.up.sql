@@ -0,0 +1,6 @@
+CREATE TABLE shares.idempotency_key (
+    key          TEXT PRIMARY KEY,
+    request_hash BYTEA NOT NULL,
+    response     BYTEA,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS shares.idempotency_key_created_at_idx;
+
+ALTER TABLE shares.idempotency_key DROP COLUMN IF EXISTS reserved_at;
@@ -0,0 +1,3 @@
+ALTER TABLE shares.idempotency_key ADD COLUMN reserved_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE INDEX idempotency_key_created_at_idx ON shares.idempotency_key (created_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS shares.outbox_published_idx;
+
+ALTER TABLE shares.outbox DROP COLUMN IF EXISTS txid;
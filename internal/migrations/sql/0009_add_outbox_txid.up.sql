@@ -0,0 +1,3 @@
+ALTER TABLE shares.outbox ADD COLUMN txid XID8 NOT NULL DEFAULT pg_current_xact_id();
+
+CREATE INDEX outbox_published_idx ON shares.outbox (published_at) WHERE published_at IS NOT NULL;
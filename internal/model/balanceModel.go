@@ -2,12 +2,110 @@
 package model
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 )
 
-// Balance struct represents a user model
+// BalanceStatus is the lifecycle state of a balance
+type BalanceStatus string
+
+// Balance statuses. A debit-frozen balance still accepts credits, a fully frozen one accepts nothing,
+// a closed balance is terminal.
+const (
+	StatusActive      BalanceStatus = "active"
+	StatusDebitFrozen BalanceStatus = "debit_frozen"
+	StatusFullyFrozen BalanceStatus = "fully_frozen"
+	StatusClosed      BalanceStatus = "closed"
+)
+
+// Balance struct represents a user model, a profile owns at most one balance per currency.
+// Available is Balance minus active holds, it is only filled when reading.
+// CreditLimit is how far Available may go below zero, it is zero unless an overdraft was granted.
+// Version grows by one with every change of the row, an update may require it to match.
 type Balance struct {
-	BalanceID uuid.UUID `json:"balance_id"`
+	BalanceID   uuid.UUID     `json:"balance_id"`
+	ProfileID   uuid.UUID     `json:"profile_id"`
+	Currency    Currency      `json:"currency"`
+	Balance     Money         `json:"balance"`
+	Available   Money         `json:"available"`
+	CreditLimit Money         `json:"credit_limit"`
+	Status      BalanceStatus `json:"status"`
+	Version     int64         `json:"version"`
+}
+
+// BalanceSort is the order of a balance listing, every order is total because ties are broken by profile ID and currency
+type BalanceSort string
+
+// Balance sort orders
+const (
+	SortByProfileID   BalanceSort = "profile_id"
+	SortByBalanceAsc  BalanceSort = "balance_asc"
+	SortByBalanceDesc BalanceSort = "balance_desc"
+)
+
+// BalanceCursor struct represents the position of the last balance of a page, the next page starts after it
+type BalanceCursor struct {
 	ProfileID uuid.UUID `json:"profile_id"`
-	Balance   float64   `json:"balance"`
+	Currency  Currency  `json:"currency"`
+	Balance   Money     `json:"balance"`
+}
+
+// BalanceFilter struct represents the parameters of a balance listing.
+// Empty ProfileIDs and Currency and nil MinBalance/MaxBalance do not filter, a nil After starts from the first page.
+type BalanceFilter struct {
+	ProfileIDs []uuid.UUID
+	Currency   Currency
+	MinBalance *Money
+	MaxBalance *Money
+	Sort       BalanceSort
+	After      *BalanceCursor
+	Limit      int
+}
+
+// StatusChange struct represents a status transition of a balance together with who made it and why
+type StatusChange struct {
+	ChangeID  uuid.UUID     `json:"change_id"`
+	ProfileID uuid.UUID     `json:"profile_id"`
+	Currency  Currency      `json:"currency"`
+	Status    BalanceStatus `json:"status"`
+	Actor     string        `json:"actor"`
+	Reason    string        `json:"reason"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+// CheckDebit returns an error if a balance in this status may not be debited
+func (s BalanceStatus) CheckDebit() error {
+	switch s {
+	case StatusActive:
+		return nil
+	case StatusClosed:
+		return ErrBalanceClosed
+	}
+	return ErrBalanceFrozen
+}
+
+// CheckCredit returns an error if a balance in this status may not be credited
+func (s BalanceStatus) CheckCredit() error {
+	switch s {
+	case StatusActive, StatusDebitFrozen:
+		return nil
+	case StatusClosed:
+		return ErrBalanceClosed
+	}
+	return ErrBalanceFrozen
+}
+
+// CheckTransition returns an error if a balance may not move from this status to next.
+// Only an active balance can be closed and nothing leaves the closed status.
+func (s BalanceStatus) CheckTransition(next BalanceStatus) error {
+	switch {
+	case s == StatusClosed:
+		return ErrBalanceClosed
+	case s == next:
+		return ErrInvalidStatusTransition
+	case next == StatusClosed && s != StatusActive:
+		return ErrBalanceFrozen
+	}
+	return nil
 }
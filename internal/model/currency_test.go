@@ -0,0 +1,43 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseCurrency function tests that only upper case ISO-4217 codes are accepted
+func TestParseCurrency(t *testing.T) {
+	currency, err := ParseCurrency("EUR")
+	require.NoError(t, err)
+	require.Equal(t, Currency("EUR"), currency)
+	for _, code := range []string{"", "eur", "EURO", "XXX", "XAU"} {
+		_, err = ParseCurrency(code)
+		require.ErrorIs(t, err, ErrInvalidCurrency, code)
+	}
+}
+
+// TestCurrencyCheckAmount function tests amounts against the decimal places of their currency
+func TestCurrencyCheckAmount(t *testing.T) {
+	testCases := []struct {
+		currency Currency
+		amount   string
+		valid    bool
+	}{
+		{"USD", "12.34", true},
+		{"USD", "12.345", false},
+		{"JPY", "1200", true},
+		{"JPY", "0.5", false},
+		{"KWD", "-1.125", true},
+		{"KWD", "1.1255", false},
+		{"CLF", "0.0001", true},
+	}
+	for _, tc := range testCases {
+		err := tc.currency.CheckAmount(MustParseMoney(tc.amount))
+		if tc.valid {
+			require.NoError(t, err, tc.amount)
+		} else {
+			require.ErrorIs(t, err, ErrCurrencyScale, tc.amount)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package model
+
+import "errors"
+
+// Domain errors shared by the repository, service and handler layers
+var (
+	// ErrNotFound is returned when the requested balance, hold or record does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists is returned when creating something that already exists
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrConflict is returned when a transaction lost a race with a concurrent one and can be retried
+	ErrConflict = errors.New("concurrent update, retry the request")
+	// ErrUnavailable is returned when the storage cannot be reached
+	ErrUnavailable = errors.New("storage unavailable")
+	// ErrInsufficientFunds is returned when an operation would take the available balance below its credit limit
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	// ErrInvalidCreditLimit is returned when a credit limit is negative
+	ErrInvalidCreditLimit = errors.New("credit limit must not be negative")
+	// ErrCreditLimitInUse is returned when lowering a credit limit below the overdraft the balance already uses
+	ErrCreditLimitInUse = errors.New("credit limit is lower than the overdraft in use")
+	// ErrInvalidAmount is returned when an amount that must be positive is zero or negative
+	ErrInvalidAmount = errors.New("amount must be positive")
+	// ErrInvalidCurrency is returned when a currency is not an upper case ISO-4217 code
+	ErrInvalidCurrency = errors.New("invalid ISO-4217 currency code")
+	// ErrCurrencyScale is returned when an amount has more decimal places than its currency
+	ErrCurrencyScale = errors.New("amount has more decimal places than its currency allows")
+	// ErrSameProfileTransfer is returned when a transfer has the same source and destination
+	ErrSameProfileTransfer = errors.New("cannot transfer to the same profile")
+	// ErrHoldNotActive is returned when capturing or releasing a hold that was already captured or released
+	ErrHoldNotActive = errors.New("hold is not active")
+	// ErrCaptureExceedsHold is returned when a capture is larger than the held amount
+	ErrCaptureExceedsHold = errors.New("capture exceeds held amount")
+	// ErrInvalidPageSize is returned when a paginated query is asked for less than one item
+	ErrInvalidPageSize = errors.New("page size must be positive")
+	// ErrInvalidSort is returned when a listing is asked for an unknown sort order
+	ErrInvalidSort = errors.New("unknown sort order")
+	// ErrBalanceFrozen is returned when an operation is blocked by a freeze of the balance
+	ErrBalanceFrozen = errors.New("balance is frozen")
+	// ErrBalanceClosed is returned when an operation targets a closed balance
+	ErrBalanceClosed = errors.New("balance is closed")
+	// ErrBalanceNotZero is returned when closing a balance that still holds or reserves funds
+	ErrBalanceNotZero = errors.New("only a zero balance without active holds can be closed")
+	// ErrInvalidStatusTransition is returned when a status change is not allowed from the current status
+	ErrInvalidStatusTransition = errors.New("invalid balance status transition")
+	// ErrActorRequired is returned when a status change does not say who made it
+	ErrActorRequired = errors.New("actor is required")
+	// ErrIdempotencyConflict is returned when an idempotency key is reused with a different request
+	ErrIdempotencyConflict = errors.New("idempotency key was used with a different request")
+	// ErrIdempotencyInProgress is returned when the first request with an idempotency key has not finished yet
+	ErrIdempotencyInProgress = errors.New("request with this idempotency key is still in progress")
+	// ErrVersionConflict is returned when a conditional update expects a version that is no longer current
+	ErrVersionConflict = errors.New("balance was changed by another request")
+	// ErrUnauthenticated is returned when a request has no credentials or they cannot be verified
+	ErrUnauthenticated = errors.New("missing or invalid credentials")
+	// ErrPermissionDenied is returned when the caller is not allowed to make a request
+	ErrPermissionDenied = errors.New("permission denied")
+)
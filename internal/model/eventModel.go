@@ -0,0 +1,41 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EventType names the kind of a domain event and the type of its payload
+type EventType string
+
+// Domain event types. BalanceChanged carries the LedgerEntry of every balance change,
+// TransferCompleted the Transfer and HoldCreated the Hold.
+const (
+	EventBalanceChanged    EventType = "BalanceChanged"
+	EventTransferCompleted EventType = "TransferCompleted"
+	EventHoldCreated       EventType = "HoldCreated"
+)
+
+// Event struct represents a domain event written to the outbox in the transaction of the change it describes.
+// Sequence orders the events of the outbox, ProfileID is the profile the event is about and the key it is published with.
+// Events are delivered at least once, consumers skip EventIDs they have already seen.
+type Event struct {
+	EventID   uuid.UUID       `json:"event_id"`
+	Sequence  int64           `json:"sequence"`
+	Type      EventType       `json:"type"`
+	ProfileID uuid.UUID       `json:"profile_id"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// NewEvent returns an event of eventType about profileID with payload encoded as JSON
+func NewEvent(eventType EventType, profileID uuid.UUID, payload interface{}) (*Event, error) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("Marshal: %w", err)
+	}
+	return &Event{EventID: uuid.New(), Type: eventType, ProfileID: profileID, Payload: encoded}, nil
+}
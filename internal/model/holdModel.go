@@ -0,0 +1,31 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// HoldStatus is the lifecycle state of a hold
+type HoldStatus string
+
+// Hold statuses, only active holds reduce the available balance
+const (
+	HoldActive   HoldStatus = "active"
+	HoldCaptured HoldStatus = "captured"
+	HoldReleased HoldStatus = "released"
+)
+
+// Hold struct represents funds reserved on a balance that are not debited yet.
+// Captured is the part of Amount that was actually debited, the rest is returned on capture.
+type Hold struct {
+	HoldID    uuid.UUID  `json:"hold_id"`
+	ProfileID uuid.UUID  `json:"profile_id"`
+	Currency  Currency   `json:"currency"`
+	Amount    Money      `json:"amount"`
+	Captured  Money      `json:"captured"`
+	Status    HoldStatus `json:"status"`
+	Reference string     `json:"reference"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
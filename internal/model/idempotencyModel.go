@@ -0,0 +1,16 @@
+package model
+
+import (
+	"time"
+)
+
+// IdempotencyRecord struct represents a stored idempotency key.
+// Response is nil while the first request with the key is still being processed, ReservedAt is when that request
+// reserved the key.
+type IdempotencyRecord struct {
+	Key         string    `json:"key"`
+	RequestHash []byte    `json:"request_hash"`
+	Response    []byte    `json:"response"`
+	CreatedAt   time.Time `json:"created_at"`
+	ReservedAt  time.Time `json:"reserved_at"`
+}
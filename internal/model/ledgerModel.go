@@ -0,0 +1,59 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Reason is a code describing why a balance changed
+type Reason string
+
+// Ledger reason codes
+const (
+	ReasonOpening     Reason = "opening"
+	ReasonAdjustment  Reason = "adjustment"
+	ReasonDeposit     Reason = "deposit"
+	ReasonWithdrawal  Reason = "withdrawal"
+	ReasonClosing     Reason = "closing"
+	ReasonTransferOut Reason = "transfer_out"
+	ReasonTransferIn  Reason = "transfer_in"
+	ReasonHoldCapture Reason = "hold_capture"
+)
+
+// LedgerEntry struct represents an immutable record of a single balance change.
+// TransferID links the two legs of a transfer and is uuid.Nil for other entries.
+type LedgerEntry struct {
+	EntryID    uuid.UUID `json:"entry_id"`
+	Sequence   int64     `json:"sequence"`
+	ProfileID  uuid.UUID `json:"profile_id"`
+	Currency   Currency  `json:"currency"`
+	Delta      Money     `json:"delta"`
+	Balance    Money     `json:"balance"`
+	Reason     Reason    `json:"reason"`
+	Reference  string    `json:"reference"`
+	TransferID uuid.UUID `json:"transfer_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// LedgerFilter struct represents the parameters of a ledger query.
+// An empty Currency lists every currency of the profile, zero From/To mean an open range
+// and AfterSequence is the exclusive cursor to continue from.
+type LedgerFilter struct {
+	ProfileID     uuid.UUID
+	Currency      Currency
+	From          time.Time
+	To            time.Time
+	AfterSequence int64
+	Limit         int
+}
+
+// Transfer struct represents a movement of funds from one profile to another, both balances are in Currency
+type Transfer struct {
+	TransferID    uuid.UUID `json:"transfer_id"`
+	FromProfileID uuid.UUID `json:"from_profile_id"`
+	ToProfileID   uuid.UUID `json:"to_profile_id"`
+	Currency      Currency  `json:"currency"`
+	Amount        Money     `json:"amount"`
+	Reference     string    `json:"reference"`
+}
@@ -0,0 +1,307 @@
+package model
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MoneyScale is the number of decimal places a Money value keeps exactly
+const MoneyScale = 9
+
+const nanosPerUnit = 1_000_000_000
+
+// Money errors
+var (
+	ErrMoneyFormat    = errors.New("invalid money format")
+	ErrMoneyPrecision = errors.New("money has more than 9 decimal places")
+	ErrMoneyOverflow  = errors.New("money overflow")
+	ErrMoneySign      = errors.New("money units and nanos must have the same sign")
+)
+
+var moneyPattern = regexp.MustCompile(`^([+-])?(\d+)(?:\.(\d+))?$`)
+
+// numericPattern matches the text pgtype.Numeric reports for a numeric column, an integer mantissa with a base-10
+// exponent such as "1234250000000e-9"
+var numericPattern = regexp.MustCompile(`^([+-])?(\d+)e([+-]?\d+)$`)
+
+// Money represents an exact decimal amount as whole units plus billionths of a unit (nanos).
+// Units and nanos always carry the same sign, so -1.5 is {-1, -500000000}.
+type Money struct {
+	units int64
+	nanos int32
+}
+
+// NewMoney creates a Money from units and nanos, nanos must be in (-1e9, 1e9) and share the sign of units
+func NewMoney(units int64, nanos int32) (Money, error) {
+	if nanos <= -nanosPerUnit || nanos >= nanosPerUnit {
+		return Money{}, fmt.Errorf("nanos %d: %w", nanos, ErrMoneyFormat)
+	}
+	if (units > 0 && nanos < 0) || (units < 0 && nanos > 0) {
+		return Money{}, ErrMoneySign
+	}
+	return Money{units: units, nanos: nanos}, nil
+}
+
+// ParseMoney parses a decimal string such as "-12.345", digits beyond MoneyScale are rejected rather than rounded
+func ParseMoney(s string) (Money, error) {
+	match := moneyPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if match == nil {
+		return Money{}, fmt.Errorf("%q: %w", s, ErrMoneyFormat)
+	}
+	units, err := strconv.ParseInt(match[2], 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%q: %w", s, ErrMoneyOverflow)
+	}
+	frac := strings.TrimRight(match[3], "0")
+	if len(frac) > MoneyScale {
+		return Money{}, fmt.Errorf("%q: %w", s, ErrMoneyPrecision)
+	}
+	var nanos int64
+	if frac != "" {
+		nanos, err = strconv.ParseInt(frac+strings.Repeat("0", MoneyScale-len(frac)), 10, 32)
+		if err != nil {
+			return Money{}, fmt.Errorf("%q: %w", s, ErrMoneyFormat)
+		}
+	}
+	if match[1] == "-" {
+		return Money{units: -units, nanos: int32(-nanos)}, nil
+	}
+	return Money{units: units, nanos: int32(nanos)}, nil
+}
+
+// MustParseMoney is like ParseMoney but panics on error, it is meant for constants and tests
+func MustParseMoney(s string) Money {
+	m, err := ParseMoney(s)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// Units returns the whole part of the amount
+func (m Money) Units() int64 {
+	return m.units
+}
+
+// Nanos returns the fractional part of the amount in billionths
+func (m Money) Nanos() int32 {
+	return m.nanos
+}
+
+// IsZero reports whether the amount is zero
+func (m Money) IsZero() bool {
+	return m.units == 0 && m.nanos == 0
+}
+
+// IsNegative reports whether the amount is below zero
+func (m Money) IsNegative() bool {
+	return m.units < 0 || m.nanos < 0
+}
+
+// Cmp compares two amounts and returns -1, 0 or +1
+func (m Money) Cmp(o Money) int {
+	switch {
+	case m.units < o.units:
+		return -1
+	case m.units > o.units:
+		return 1
+	case m.nanos < o.nanos:
+		return -1
+	case m.nanos > o.nanos:
+		return 1
+	}
+	return 0
+}
+
+// Neg returns the amount with the opposite sign
+func (m Money) Neg() (Money, error) {
+	if m.units == math.MinInt64 {
+		return Money{}, ErrMoneyOverflow
+	}
+	return Money{units: -m.units, nanos: -m.nanos}, nil
+}
+
+// Add returns m + o, or ErrMoneyOverflow if the result does not fit
+func (m Money) Add(o Money) (Money, error) {
+	units := m.units + o.units
+	if (o.units > 0 && units < m.units) || (o.units < 0 && units > m.units) {
+		return Money{}, ErrMoneyOverflow
+	}
+	nanos := m.nanos + o.nanos
+	switch {
+	case nanos >= nanosPerUnit:
+		if units == math.MaxInt64 {
+			return Money{}, ErrMoneyOverflow
+		}
+		units++
+		nanos -= nanosPerUnit
+	case nanos <= -nanosPerUnit:
+		if units == math.MinInt64 {
+			return Money{}, ErrMoneyOverflow
+		}
+		units--
+		nanos += nanosPerUnit
+	}
+	switch {
+	case units > 0 && nanos < 0:
+		units--
+		nanos += nanosPerUnit
+	case units < 0 && nanos > 0:
+		units++
+		nanos -= nanosPerUnit
+	}
+	return Money{units: units, nanos: nanos}, nil
+}
+
+// Sub returns m - o, or ErrMoneyOverflow if the result does not fit
+func (m Money) Sub(o Money) (Money, error) {
+	neg, err := o.Neg()
+	if err != nil {
+		return Money{}, err
+	}
+	return m.Add(neg)
+}
+
+// Round rounds the amount to the given number of decimal places using round-half-to-even (banker's rounding).
+// Scales outside [0, MoneyScale] are clamped.
+func (m Money) Round(scale int) (Money, error) {
+	if scale >= MoneyScale {
+		return m, nil
+	}
+	if scale < 0 {
+		scale = 0
+	}
+	step := int32(1)
+	for i := scale; i < MoneyScale; i++ {
+		step *= 10
+	}
+	rem := m.nanos % step
+	if rem == 0 {
+		return m, nil
+	}
+	truncated := Money{units: m.units, nanos: m.nanos - rem}
+	absRem := rem
+	if absRem < 0 {
+		absRem = -absRem
+	}
+	var odd bool
+	if step == nanosPerUnit {
+		odd = truncated.units%2 != 0
+	} else {
+		odd = (truncated.nanos/step)%2 != 0
+	}
+	if 2*int64(absRem) < int64(step) || (2*int64(absRem) == int64(step) && !odd) {
+		return truncated, nil
+	}
+	away := Money{nanos: step}
+	if m.IsNegative() {
+		away.nanos = -step
+	}
+	return truncated.Add(away)
+}
+
+// String formats the amount as a plain decimal without trailing zeros, e.g. "-12.5"
+func (m Money) String() string {
+	sign := ""
+	units, nanos := m.units, int64(m.nanos)
+	if m.IsNegative() {
+		sign = "-"
+		nanos = -nanos
+	}
+	whole := strconv.FormatInt(units, 10)
+	whole = strings.TrimPrefix(whole, "-")
+	if nanos == 0 {
+		return sign + whole
+	}
+	frac := strings.TrimRight(fmt.Sprintf("%09d", nanos), "0")
+	return sign + whole + "." + frac
+}
+
+// MarshalJSON encodes the amount as a JSON string so that no precision is lost in float parsers
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(m.String())), nil
+}
+
+// UnmarshalJSON decodes the amount from a JSON string or number
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	parsed, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Value implements driver.Valuer, the amount is sent to PostgreSQL as numeric text
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// Scan implements sql.Scanner for numeric columns, which pgx passes as plain decimals or in the mantissa and
+// exponent form of pgtype.Numeric
+func (m *Money) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case nil:
+		*m = Money{}
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into Money: %w", src, ErrMoneyFormat)
+	}
+	parsed, err := parseNumeric(s)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// parseNumeric parses the text of a numeric value, the mantissa and exponent form is rewritten as a plain decimal
+// and every other text is parsed by ParseMoney
+func parseNumeric(s string) (Money, error) {
+	match := numericPattern.FindStringSubmatch(s)
+	if match == nil {
+		return ParseMoney(s)
+	}
+	exp, err := strconv.ParseInt(match[3], 10, 32)
+	if err != nil {
+		return Money{}, fmt.Errorf("%q: %w", s, ErrMoneyFormat)
+	}
+	digits := strings.TrimLeft(match[2], "0")
+	trimmed := strings.TrimRight(digits, "0")
+	exp += int64(len(digits) - len(trimmed))
+	digits = trimmed
+	switch {
+	case digits == "":
+		return Money{}, nil
+	case exp < -MoneyScale:
+		return Money{}, fmt.Errorf("%q: %w", s, ErrMoneyPrecision)
+	case int64(len(digits))+exp > 19:
+		return Money{}, fmt.Errorf("%q: %w", s, ErrMoneyOverflow)
+	}
+	var plain string
+	switch point := len(digits) + int(exp); {
+	case exp >= 0:
+		plain = digits + strings.Repeat("0", int(exp))
+	case point > 0:
+		plain = digits[:point] + "." + digits[point:]
+	default:
+		plain = "0." + strings.Repeat("0", -point) + digits
+	}
+	return ParseMoney(match[1] + plain)
+}
@@ -0,0 +1,173 @@
+// Package model contains model tests in this case
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/jackc/pgtype"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseMoney function tests parsing of decimal strings
+func TestParseMoney(t *testing.T) {
+	testCases := []struct {
+		in    string
+		units int64
+		nanos int32
+	}{
+		{"0", 0, 0},
+		{"1234.25", 1234, 250000000},
+		{"-0.5", 0, -500000000},
+		{"-12.000000001", -12, -1},
+		{"+7.10", 7, 100000000},
+		{"0.123456789000", 0, 123456789},
+	}
+	for _, tc := range testCases {
+		m, err := ParseMoney(tc.in)
+		require.NoError(t, err, tc.in)
+		require.Equal(t, tc.units, m.Units(), tc.in)
+		require.Equal(t, tc.nanos, m.Nanos(), tc.in)
+	}
+}
+
+// TestParseMoneyErrors function tests that malformed or too precise input is rejected
+func TestParseMoneyErrors(t *testing.T) {
+	_, err := ParseMoney("0.1234567891")
+	require.ErrorIs(t, err, ErrMoneyPrecision)
+	_, err = ParseMoney("1e5")
+	require.ErrorIs(t, err, ErrMoneyFormat)
+	_, err = ParseMoney("99999999999999999999")
+	require.ErrorIs(t, err, ErrMoneyOverflow)
+	_, err = NewMoney(1, -1)
+	require.ErrorIs(t, err, ErrMoneySign)
+}
+
+// TestMoneyAddIsExact function tests that 0.1 + 0.2 is exactly 0.3
+func TestMoneyAddIsExact(t *testing.T) {
+	sum, err := MustParseMoney("0.1").Add(MustParseMoney("0.2"))
+	require.NoError(t, err)
+	require.Equal(t, MustParseMoney("0.3"), sum)
+	require.Equal(t, "0.3", sum.String())
+}
+
+// TestMoneyAddSub function tests carrying between units and nanos across zero
+func TestMoneyAddSub(t *testing.T) {
+	testCases := []struct {
+		a, b, sum, diff string
+	}{
+		{"1.75", "0.5", "2.25", "1.25"},
+		{"0.25", "1", "1.25", "-0.75"},
+		{"-1.5", "-0.75", "-2.25", "-0.75"},
+		{"-1.5", "2", "0.5", "-3.5"},
+		{"0.999999999", "0.000000001", "1", "0.999999998"},
+	}
+	for _, tc := range testCases {
+		a, b := MustParseMoney(tc.a), MustParseMoney(tc.b)
+		sum, err := a.Add(b)
+		require.NoError(t, err)
+		require.Equal(t, MustParseMoney(tc.sum), sum, "%s + %s", tc.a, tc.b)
+		diff, err := a.Sub(b)
+		require.NoError(t, err)
+		require.Equal(t, MustParseMoney(tc.diff), diff, "%s - %s", tc.a, tc.b)
+	}
+}
+
+// TestMoneyAddOverflow function tests overflow detection
+func TestMoneyAddOverflow(t *testing.T) {
+	maxMoney, err := NewMoney(9223372036854775807, 999999999)
+	require.NoError(t, err)
+	_, err = maxMoney.Add(MustParseMoney("0.000000001"))
+	require.ErrorIs(t, err, ErrMoneyOverflow)
+}
+
+// TestMoneyRound function tests round-half-to-even rounding
+func TestMoneyRound(t *testing.T) {
+	testCases := []struct {
+		in    string
+		scale int
+		out   string
+	}{
+		{"2.345", 2, "2.34"},
+		{"2.355", 2, "2.36"},
+		{"2.3451", 2, "2.35"},
+		{"-2.345", 2, "-2.34"},
+		{"-2.355", 2, "-2.36"},
+		{"0.5", 0, "0"},
+		{"1.5", 0, "2"},
+		{"-0.5", 0, "0"},
+		{"0.995", 2, "1"},
+		{"12.3", 9, "12.3"},
+	}
+	for _, tc := range testCases {
+		rounded, err := MustParseMoney(tc.in).Round(tc.scale)
+		require.NoError(t, err)
+		require.Equal(t, tc.out, rounded.String(), "%s at scale %d", tc.in, tc.scale)
+	}
+}
+
+// TestMoneyCmp function tests comparison
+func TestMoneyCmp(t *testing.T) {
+	require.Equal(t, -1, MustParseMoney("-0.1").Cmp(MustParseMoney("0")))
+	require.Equal(t, 1, MustParseMoney("1.000000001").Cmp(MustParseMoney("1")))
+	require.Equal(t, 0, MustParseMoney("3.10").Cmp(MustParseMoney("3.1")))
+	require.True(t, MustParseMoney("-0.000000001").IsNegative())
+	require.True(t, Money{}.IsZero())
+}
+
+// TestMoneyJSONAndSQL function tests the JSON and database/sql encodings
+func TestMoneyJSONAndSQL(t *testing.T) {
+	m := MustParseMoney("-1234.000000025")
+	data, err := json.Marshal(m)
+	require.NoError(t, err)
+	require.Equal(t, `"-1234.000000025"`, string(data))
+	var decoded Money
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Equal(t, m, decoded)
+
+	value, err := m.Value()
+	require.NoError(t, err)
+	var scanned Money
+	require.NoError(t, scanned.Scan(value))
+	require.Equal(t, m, scanned)
+	require.NoError(t, scanned.Scan([]byte("0.10")))
+	require.Equal(t, MustParseMoney("0.1"), scanned)
+}
+
+// TestMoneyScanNumeric function tests scanning the values pgx passes for numeric columns sent as text and as binary
+func TestMoneyScanNumeric(t *testing.T) {
+	for _, text := range []string{"1234.25", "1234.250000000", "0", "0.000000000", "-5.5", "0.000000001", "-0.01", "100", "9223372036854775807.999999999"} {
+		want := MustParseMoney(text)
+		var numeric pgtype.Numeric
+		require.NoError(t, numeric.DecodeText(nil, []byte(text)), text)
+		value, err := numeric.Value()
+		require.NoError(t, err, text)
+		var scanned Money
+		require.NoError(t, scanned.Scan(value), "%s as %v", text, value)
+		require.Equal(t, want, scanned, text)
+
+		binary, err := numeric.EncodeBinary(nil, nil)
+		require.NoError(t, err, text)
+		numeric = pgtype.Numeric{}
+		require.NoError(t, numeric.DecodeBinary(nil, binary), text)
+		value, err = numeric.Value()
+		require.NoError(t, err, text)
+		require.NoError(t, scanned.Scan(value), "%s as %v", text, value)
+		require.Equal(t, want, scanned, text)
+	}
+
+	// 1234.25 in a NUMERIC(38,9) column as PostgreSQL sends it: 2 base-10000 digits, weight 0, sign +, dscale 9
+	var numeric pgtype.Numeric
+	require.NoError(t, numeric.DecodeBinary(nil, []byte{0, 2, 0, 0, 0, 0, 0, 9, 0x04, 0xd2, 0x09, 0xc4}))
+	value, err := numeric.Value()
+	require.NoError(t, err)
+	var scanned Money
+	require.NoError(t, scanned.Scan(value))
+	require.Equal(t, MustParseMoney("1234.25"), scanned)
+
+	require.ErrorIs(t, scanned.Scan("1e-10"), ErrMoneyPrecision)
+	require.ErrorIs(t, scanned.Scan("1e19"), ErrMoneyOverflow)
+	require.ErrorIs(t, scanned.Scan("NaN"), ErrMoneyFormat)
+	require.NoError(t, scanned.Scan("15e2"))
+	require.Equal(t, MustParseMoney("1500"), scanned)
+}
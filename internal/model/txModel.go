@@ -0,0 +1,11 @@
+package model
+
+// IsolationLevel is the isolation level of a unit of work, the empty level is the storage default (read committed)
+type IsolationLevel string
+
+// Isolation levels, they are named like the PostgreSQL levels they map to
+const (
+	ReadCommitted  IsolationLevel = "read committed"
+	RepeatableRead IsolationLevel = "repeatable read"
+	Serializable   IsolationLevel = "serializable"
+)
@@ -3,79 +3,103 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/eugenshima/balance/internal/model"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
-	"github.com/sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-// PsqlConnection is a struct, which contains Pool variable
+// Statuses that accept debits and credits, they mirror model.BalanceStatus.CheckDebit and CheckCredit
+// so that guarded UPDATE statements can enforce them without a separate read
+var (
+	debitStatuses  = []string{string(model.StatusActive)}
+	creditStatuses = []string{string(model.StatusActive), string(model.StatusDebitFrozen)}
+)
+
+// PsqlConnection is a struct, which contains Pool variable.
+// tx is set for the repository a unit of work passes to its callback, see InTx.
 type PsqlConnection struct {
-	pool *pgxpool.Pool
+	pool         *pgxpool.Pool
+	transactions *prometheus.CounterVec
+	tx           pgx.Tx
 }
 
 // NewPsqlConnection constructor for PsqlConnection
 func NewPsqlConnection(pool *pgxpool.Pool) *PsqlConnection {
-	return &PsqlConnection{pool: pool}
+	return &PsqlConnection{
+		pool: pool,
+		transactions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "balance_db_transactions_total",
+			Help: "Database transactions by outcome.",
+		}, []string{"outcome"}),
+	}
 }
 
-// GetUserByID function returns user information by ID
-func (db *PsqlConnection) GetUserByID(ctx context.Context, profileID uuid.UUID) (*model.Balance, error) {
-	tx, err := db.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: "repeatable read"})
-	if err != nil {
-		return nil, fmt.Errorf("BeginTx: %w", err)
-	}
-	defer func() {
-		if err != nil {
-			err = tx.Rollback(ctx)
-			if err != nil {
-				logrus.Errorf("Rollback: %v", err)
-				return
-			}
-		} else {
-			err = tx.Commit(ctx)
-			if err != nil {
-				logrus.Errorf("Commit: %v", err)
-				return
-			}
-		}
-	}()
+// GetUserByID function returns the balance of a profile in currency
+func (db *PsqlConnection) GetUserByID(ctx context.Context, profileID uuid.UUID, currency model.Currency) (*model.Balance, error) {
 	var balance model.Balance
-	err = tx.QueryRow(ctx, "SELECT balance_id, profile_id, balance FROM shares.balance WHERE profile_id = $1", profileID).Scan(&balance.BalanceID, &balance.ProfileID, &balance.Balance)
-	if err != nil || balance.BalanceID == uuid.Nil {
-		return nil, fmt.Errorf("QueryRow(): %w", err)
+	err := db.inTx(ctx, pgx.TxOptions{IsoLevel: "repeatable read"}, func(tx pgx.Tx) error {
+		var status string
+		err := tx.QueryRow(ctx, `SELECT balance_id, profile_id, currency, balance, balance - held, credit_limit, status, version FROM shares.balance
+			WHERE profile_id = $1 AND currency = $2`, profileID, string(currency)).
+			Scan(&balance.BalanceID, &balance.ProfileID, (*string)(&balance.Currency), &balance.Balance, &balance.Available, &balance.CreditLimit, &status, &balance.Version)
+		if err != nil || balance.BalanceID == uuid.Nil {
+			return fmt.Errorf("QueryRow(): %w", dbError(err))
+		}
+		balance.Status = model.BalanceStatus(status)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return &balance, nil
 }
 
-// GetAll function executes SQL request to select all rows from Database
-func (db *PsqlConnection) GetAll(ctx context.Context) ([]*model.Balance, error) {
-	tx, err := db.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: "repeatable read"})
-	if err != nil {
-		return nil, fmt.Errorf("BeginTx: %w", err)
+// balanceOrders holds the keyset condition and ORDER BY clause of every sort order,
+// $4, $5 and $6 are the profile ID, balance and currency of the cursor
+var balanceOrders = map[model.BalanceSort]struct {
+	after   string
+	orderBy string
+}{
+	model.SortByProfileID:   {"(profile_id, currency) > ($4, $6)", "profile_id, currency"},
+	model.SortByBalanceAsc:  {"(balance, profile_id, currency) > ($5::numeric, $4, $6)", "balance, profile_id, currency"},
+	model.SortByBalanceDesc: {"(balance, profile_id, currency) < ($5::numeric, $4, $6)", "balance DESC, profile_id DESC, currency DESC"},
+}
+
+// GetAll function returns one page of balances matching filter using keyset pagination,
+// so the cost of a page does not depend on how far into the listing it is
+func (db *PsqlConnection) GetAll(ctx context.Context, filter model.BalanceFilter) ([]*model.Balance, error) {
+	order, ok := balanceOrders[filter.Sort]
+	if !ok {
+		return nil, fmt.Errorf("sort %q: %w", filter.Sort, model.ErrInvalidSort)
 	}
-	defer func() {
-		if err != nil {
-			err = tx.Rollback(ctx)
-			if err != nil {
-				logrus.Errorf("Rollback: %v", err)
-				return
-			}
-		} else {
-			err = tx.Commit(ctx)
-			if err != nil {
-				logrus.Errorf("Commit: %v", err)
-				return
-			}
-		}
-	}()
-	rows, err := tx.Query(ctx, "SELECT balance_id, profile_id, balance FROM shares.balance")
+	var profileIDs []string
+	for _, profileID := range filter.ProfileIDs {
+		profileIDs = append(profileIDs, profileID.String())
+	}
+	var afterProfileID *uuid.UUID
+	var afterBalance *model.Money
+	var afterCurrency *string
+	if filter.After != nil {
+		afterProfileID, afterBalance, afterCurrency = &filter.After.ProfileID, &filter.After.Balance, (*string)(&filter.After.Currency)
+	}
+	rows, err := db.query(ctx, `SELECT balance_id, profile_id, currency, balance, balance - held, credit_limit, status, version
+		FROM shares.balance
+		WHERE ($1::uuid[] IS NULL OR profile_id = ANY($1))
+			AND ($2::numeric IS NULL OR balance >= $2)
+			AND ($3::numeric IS NULL OR balance <= $3)
+			AND ($4::uuid IS NULL OR $5::numeric IS NULL OR $6::text IS NULL OR `+order.after+`)
+			AND ($7::text = '' OR currency = $7)
+		ORDER BY `+order.orderBy+`
+		LIMIT $8`, profileIDs, filter.MinBalance, filter.MaxBalance, afterProfileID, afterBalance, afterCurrency, string(filter.Currency), filter.Limit)
 	if err != nil {
-		return nil, fmt.Errorf("Query(): %w", err)
+		return nil, fmt.Errorf("Query(): %w", dbError(err))
 	}
 	defer rows.Close()
 
@@ -85,104 +109,543 @@ func (db *PsqlConnection) GetAll(ctx context.Context) ([]*model.Balance, error)
 	// go through each line
 	for rows.Next() {
 		user := &model.Balance{}
-		err := rows.Scan(&user.BalanceID, &user.ProfileID, &user.Balance)
+		var status string
+		err := rows.Scan(&user.BalanceID, &user.ProfileID, (*string)(&user.Currency), &user.Balance, &user.Available, &user.CreditLimit, &status, &user.Version)
 		if err != nil {
-			return nil, fmt.Errorf("Scan(): %w", err) // Returning error message
+			return nil, fmt.Errorf("Scan(): %w", dbError(err)) // Returning error message
 		}
+		user.Status = model.BalanceStatus(status)
 		results = append(results, user)
 	}
 	return results, rows.Err()
 }
 
-// UpdateBalance function updates user's balance information
-func (db *PsqlConnection) UpdateBalance(ctx context.Context, balance *model.Balance) error {
-	tx, err := db.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: "repeatable read"})
+// streamBatchSize is the number of rows StreamAll fetches from its cursor at a time
+const streamBatchSize = 500
+
+// StreamAll function calls fn for every balance in profile ID and currency order. The rows are fetched in batches from a cursor
+// inside one read only repeatable read transaction, so they all come from the same snapshot however long fn takes.
+// It stops at the first error of fn or when ctx is done.
+func (db *PsqlConnection) StreamAll(ctx context.Context, fn func(*model.Balance) error) error {
+	return db.inTx(ctx, pgx.TxOptions{IsoLevel: "repeatable read", AccessMode: "read only"}, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `DECLARE balance_export NO SCROLL CURSOR FOR
+			SELECT balance_id, profile_id, currency, balance, balance - held, credit_limit, status, version FROM shares.balance ORDER BY profile_id, currency`)
+		if err != nil {
+			return fmt.Errorf("Exec(): %w", dbError(err))
+		}
+		for {
+			fetched, err := fetchBalances(ctx, tx, fn)
+			if err != nil {
+				return err
+			}
+			if fetched < streamBatchSize {
+				break
+			}
+		}
+		// inside a unit of work the cursor would outlive the savepoint and block the next export
+		_, err = tx.Exec(ctx, "CLOSE balance_export")
+		if err != nil {
+			return fmt.Errorf("Exec(): %w", dbError(err))
+		}
+		return nil
+	})
+}
+
+// fetchBalances fetches the next batch of the balance_export cursor and calls fn for each row,
+// it returns the number of rows fetched
+func fetchBalances(ctx context.Context, tx pgx.Tx, fn func(*model.Balance) error) (int, error) {
+	rows, err := tx.Query(ctx, fmt.Sprintf("FETCH %d FROM balance_export", streamBatchSize))
+	if err != nil {
+		return 0, fmt.Errorf("Query(): %w", dbError(err))
+	}
+	defer rows.Close()
+	fetched := 0
+	for rows.Next() {
+		fetched++
+		balance := &model.Balance{}
+		var status string
+		err = rows.Scan(&balance.BalanceID, &balance.ProfileID, (*string)(&balance.Currency), &balance.Balance, &balance.Available, &balance.CreditLimit, &status, &balance.Version)
+		if err != nil {
+			return fetched, fmt.Errorf("Scan(): %w", dbError(err))
+		}
+		balance.Status = model.BalanceStatus(status)
+		err = fn(balance)
+		if err != nil {
+			return fetched, err
+		}
+	}
+	err = rows.Err()
 	if err != nil {
-		return fmt.Errorf("BeginTx: %w", err)
+		return fetched, fmt.Errorf("Rows(): %w", dbError(err))
 	}
-	defer func() {
+	return fetched, nil
+}
+
+// UpdateBalance function updates user's balance information.
+// A non-zero balance.Version must match the stored version, otherwise model.ErrVersionConflict is returned.
+// On success balance holds the stored row including the new version.
+func (db *PsqlConnection) UpdateBalance(ctx context.Context, balance *model.Balance) error {
+	return db.inTx(ctx, pgx.TxOptions{IsoLevel: "repeatable read"}, func(tx pgx.Tx) error {
+		var previous, held model.Money
+		var status string
+		var version int64
+		err := tx.QueryRow(ctx, `SELECT balance_id, balance, held, credit_limit, status, version FROM shares.balance
+			WHERE profile_id = $1 AND currency = $2 FOR UPDATE`, balance.ProfileID, string(balance.Currency)).
+			Scan(&balance.BalanceID, &previous, &held, &balance.CreditLimit, &status, &version)
+		if err != nil || balance.ProfileID == uuid.Nil {
+			return fmt.Errorf("QueryRow(): %w", dbError(err))
+		}
+		if balance.Version != 0 && balance.Version != version {
+			return model.ErrVersionConflict
+		}
+		balance.Status = model.BalanceStatus(status)
+		if balance.Balance.Cmp(previous) < 0 {
+			err = balance.Status.CheckDebit()
+		} else {
+			err = balance.Status.CheckCredit()
+		}
 		if err != nil {
-			err = tx.Rollback(ctx)
+			return err
+		}
+		// a decrease may only spend the available balance and the credit limit, funds reserved by holds stay untouched
+		if balance.Balance.Cmp(previous) < 0 {
+			err = checkCreditLimit(balance.Balance, held, balance.CreditLimit)
 			if err != nil {
-				logrus.Errorf("Rollback: %v", err)
-				return
+				return err
 			}
-		} else {
-			err = tx.Commit(ctx)
+		}
+		err = tx.QueryRow(ctx, "UPDATE shares.balance SET balance = $1, version = version + 1 WHERE balance_id = $2 RETURNING balance - held, version",
+			balance.Balance, balance.BalanceID).Scan(&balance.Available, &balance.Version)
+		if err != nil {
+			return fmt.Errorf("QueryRow(): %w", dbError(err))
+		}
+		delta, err := balance.Balance.Sub(previous)
+		if err != nil {
+			return fmt.Errorf("Sub(): %w", err)
+		}
+		err = insertLedgerEntry(ctx, tx, &model.LedgerEntry{
+			ProfileID: balance.ProfileID,
+			Currency:  balance.Currency,
+			Delta:     delta,
+			Balance:   balance.Balance,
+			Reason:    model.ReasonAdjustment,
+		})
+		if err != nil {
+			return fmt.Errorf("insertLedgerEntry: %w", err)
+		}
+		return nil
+	})
+}
+
+// CreateBalance function creates user's balance in balance.Currency, a profile may own one balance per currency.
+// A new balance has no credit limit, so its opening balance must not be negative.
+func (db *PsqlConnection) CreateBalance(ctx context.Context, balance *model.Balance) error {
+	if balance.Balance.IsNegative() {
+		return model.ErrInsufficientFunds
+	}
+	return db.inTx(ctx, pgx.TxOptions{IsoLevel: "repeatable read"}, func(tx pgx.Tx) error {
+		balance.Status = model.StatusActive
+		balance.Available, balance.CreditLimit = balance.Balance, model.Money{}
+		err := tx.QueryRow(ctx, "INSERT INTO shares.balance (balance_id, profile_id, currency, balance, status) VALUES ($1, $2, $3, $4, $5) RETURNING version",
+			balance.BalanceID, balance.ProfileID, string(balance.Currency), balance.Balance, string(balance.Status)).Scan(&balance.Version)
+		if err != nil {
+			return fmt.Errorf("QueryRow(): %w", dbError(err))
+		}
+		err = insertLedgerEntry(ctx, tx, &model.LedgerEntry{
+			ProfileID: balance.ProfileID,
+			Currency:  balance.Currency,
+			Delta:     balance.Balance,
+			Balance:   balance.Balance,
+			Reason:    model.ReasonOpening,
+		})
+		if err != nil {
+			return fmt.Errorf("insertLedgerEntry: %w", err)
+		}
+		return nil
+	})
+}
+
+// ChangeStatus function moves a balance to change.Status and records who made the change and why.
+// Balances are never deleted: closing requires a zero balance without active holds and appends a closing ledger entry.
+func (db *PsqlConnection) ChangeStatus(ctx context.Context, change *model.StatusChange) (*model.Balance, error) {
+	var balance model.Balance
+	err := db.inTx(ctx, pgx.TxOptions{IsoLevel: "read committed"}, func(tx pgx.Tx) error {
+		var status string
+		err := tx.QueryRow(ctx, `SELECT balance_id, profile_id, currency, balance, balance - held, credit_limit, status FROM shares.balance
+			WHERE profile_id = $1 AND currency = $2 FOR UPDATE`, change.ProfileID, string(change.Currency)).
+			Scan(&balance.BalanceID, &balance.ProfileID, (*string)(&balance.Currency), &balance.Balance, &balance.Available, &balance.CreditLimit, &status)
+		if err != nil {
+			return fmt.Errorf("QueryRow(): %w", dbError(err))
+		}
+		err = model.BalanceStatus(status).CheckTransition(change.Status)
+		if err != nil {
+			return err
+		}
+		if change.Status == model.StatusClosed && (!balance.Balance.IsZero() || !balance.Available.IsZero()) {
+			return model.ErrBalanceNotZero
+		}
+		err = tx.QueryRow(ctx, "UPDATE shares.balance SET status = $1, version = version + 1 WHERE balance_id = $2 RETURNING version",
+			string(change.Status), balance.BalanceID).Scan(&balance.Version)
+		if err != nil {
+			return fmt.Errorf("QueryRow(): %w", dbError(err))
+		}
+		balance.Status = change.Status
+		change.ChangeID = uuid.New()
+		err = tx.QueryRow(ctx, `INSERT INTO shares.balance_status_change (change_id, profile_id, currency, status, actor, reason)
+			VALUES ($1, $2, $3, $4, $5, $6) RETURNING created_at`,
+			change.ChangeID, change.ProfileID, string(change.Currency), string(change.Status), change.Actor, change.Reason).Scan(&change.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("QueryRow(): %w", dbError(err))
+		}
+		if change.Status == model.StatusClosed {
+			err = insertLedgerEntry(ctx, tx, &model.LedgerEntry{
+				ProfileID: change.ProfileID,
+				Currency:  change.Currency,
+				Reason:    model.ReasonClosing,
+				Reference: change.Reason,
+			})
 			if err != nil {
-				logrus.Errorf("Commit: %v", err)
-				return
+				return fmt.Errorf("insertLedgerEntry: %w", err)
 			}
 		}
-	}()
-	err = tx.QueryRow(ctx, "SELECT balance_id FROM shares.balance WHERE profile_id = $1", balance.ProfileID).Scan(&balance.BalanceID)
-	if err != nil || balance.ProfileID == uuid.Nil {
-		return fmt.Errorf("QueryRow(): %w", err)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &balance, nil
+}
+
+// Deposit function adds a positive amount to user's balance in currency and returns the resulting balance
+func (db *PsqlConnection) Deposit(ctx context.Context, profileID uuid.UUID, currency model.Currency, amount model.Money, reference string) (*model.Balance, error) {
+	return db.applyDelta(ctx, &model.LedgerEntry{
+		ProfileID: profileID,
+		Currency:  currency,
+		Delta:     amount,
+		Reason:    model.ReasonDeposit,
+		Reference: reference,
+	})
+}
+
+// Withdraw function subtracts a positive amount from user's balance in currency and returns the resulting balance,
+// model.ErrInsufficientFunds is returned if the available balance would go below its credit limit
+func (db *PsqlConnection) Withdraw(ctx context.Context, profileID uuid.UUID, currency model.Currency, amount model.Money, reference string) (*model.Balance, error) {
+	delta, err := amount.Neg()
+	if err != nil {
+		return nil, fmt.Errorf("Neg(): %w", err)
+	}
+	return db.applyDelta(ctx, &model.LedgerEntry{
+		ProfileID: profileID,
+		Currency:  currency,
+		Delta:     delta,
+		Reason:    model.ReasonWithdrawal,
+		Reference: reference,
+	})
+}
+
+// applyDelta adds entry.Delta to the balance in a single UPDATE statement and records the entry in the ledger.
+// A negative delta may only spend the available balance (balance - held) and the credit limit,
+// the status must allow the direction.
+// The transaction runs in read committed on purpose: concurrent deltas on the same row are serialized
+// by the row lock instead of failing with a serialization error.
+func (db *PsqlConnection) applyDelta(ctx context.Context, entry *model.LedgerEntry) (*model.Balance, error) {
+	var balance model.Balance
+	err := db.inTx(ctx, pgx.TxOptions{IsoLevel: "read committed"}, func(tx pgx.Tx) error {
+		debit := entry.Delta.IsNegative()
+		statuses := creditStatuses
+		if debit {
+			statuses = debitStatuses
+		}
+		var status string
+		err := tx.QueryRow(ctx, `UPDATE shares.balance SET balance = balance + $1::numeric, version = version + 1
+			WHERE profile_id = $2 AND currency = $3 AND status = ANY($4) AND ($1::numeric >= 0 OR balance - held + credit_limit + $1::numeric >= 0)
+			RETURNING balance_id, profile_id, currency, balance, balance - held, credit_limit, status, version`, entry.Delta, entry.ProfileID, string(entry.Currency), statuses).
+			Scan(&balance.BalanceID, &balance.ProfileID, (*string)(&balance.Currency), &balance.Balance, &balance.Available, &balance.CreditLimit, &status, &balance.Version)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return rejectedUpdateError(ctx, tx, entry.ProfileID, entry.Currency, debit)
+		}
+		if err != nil {
+			return fmt.Errorf("QueryRow(): %w", dbError(err))
+		}
+		balance.Status = model.BalanceStatus(status)
+		entry.Balance = balance.Balance
+		err = insertLedgerEntry(ctx, tx, entry)
+		if err != nil {
+			return fmt.Errorf("insertLedgerEntry: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &balance, nil
+}
+
+// checkCreditLimit returns model.ErrInsufficientFunds if balance minus held is below -creditLimit,
+// it mirrors the balance_within_credit_limit check constraint of shares.balance
+func checkCreditLimit(balance, held, creditLimit model.Money) error {
+	available, err := balance.Sub(held)
+	if err != nil {
+		return fmt.Errorf("Sub(): %w", err)
+	}
+	available, err = available.Add(creditLimit)
+	if err != nil {
+		return fmt.Errorf("Add(): %w", err)
 	}
-	tag, err := tx.Exec(ctx, "UPDATE shares.balance SET balance = $1 WHERE balance_id = $2", balance.Balance, balance.BalanceID)
-	if err != nil || tag.RowsAffected() == 0 {
-		return fmt.Errorf("exec: %w", err)
+	if available.IsNegative() {
+		return model.ErrInsufficientFunds
 	}
 	return nil
 }
 
-// CreateBalance function creates user's balance
-func (db *PsqlConnection) CreateBalance(ctx context.Context, balance *model.Balance) error {
-	tx, err := db.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: "repeatable read"})
+// rejectedUpdateError is called after a guarded UPDATE matched no rows and tells apart
+// a missing balance (wrapped pgx.ErrNoRows), a status that blocks the operation and a lack of funds (model.ErrInsufficientFunds)
+func rejectedUpdateError(ctx context.Context, tx pgx.Tx, profileID uuid.UUID, currency model.Currency, debit bool) error {
+	var status string
+	err := tx.QueryRow(ctx, "SELECT status FROM shares.balance WHERE profile_id = $1 AND currency = $2", profileID, string(currency)).Scan(&status)
+	if err != nil {
+		return fmt.Errorf("QueryRow(): %w", dbError(err))
+	}
+	if debit {
+		err = model.BalanceStatus(status).CheckDebit()
+	} else {
+		err = model.BalanceStatus(status).CheckCredit()
+	}
+	if err != nil {
+		return err
+	}
+	return model.ErrInsufficientFunds
+}
+
+// insertLedgerEntry writes an entry to the ledger inside tx and fills its ID, sequence and creation time,
+// a BalanceChanged event carrying the entry goes to the outbox in the same transaction
+func insertLedgerEntry(ctx context.Context, tx pgx.Tx, entry *model.LedgerEntry) error {
+	entry.EntryID = uuid.New()
+	var transferID *uuid.UUID
+	if entry.TransferID != uuid.Nil {
+		transferID = &entry.TransferID
+	}
+	err := tx.QueryRow(ctx, `INSERT INTO shares.ledger (entry_id, profile_id, currency, delta, balance, reason, reference, transfer_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING sequence, created_at`,
+		entry.EntryID, entry.ProfileID, string(entry.Currency), entry.Delta, entry.Balance, string(entry.Reason), entry.Reference, transferID).
+		Scan(&entry.Sequence, &entry.CreatedAt)
 	if err != nil {
-		return fmt.Errorf("BeginTx: %w", err)
+		return fmt.Errorf("QueryRow(): %w", dbError(err))
 	}
-	defer func() {
+	// The notification is delivered when tx commits and is dropped if it rolls back
+	_, err = tx.Exec(ctx, "SELECT pg_notify($1, $2)", balanceChannel, entry.ProfileID.String())
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", dbError(err))
+	}
+	err = insertEvent(ctx, tx, model.EventBalanceChanged, entry.ProfileID, entry)
+	if err != nil {
+		return fmt.Errorf("insertEvent: %w", err)
+	}
+	return nil
+}
+
+// ListTransactions function returns ledger entries of a profile ordered by sequence
+func (db *PsqlConnection) ListTransactions(ctx context.Context, filter model.LedgerFilter) ([]*model.LedgerEntry, error) {
+	var from, to *time.Time
+	if !filter.From.IsZero() {
+		from = &filter.From
+	}
+	if !filter.To.IsZero() {
+		to = &filter.To
+	}
+	rows, err := db.query(ctx, `SELECT entry_id, sequence, profile_id, currency, delta, balance, reason, reference, transfer_id, created_at
+		FROM shares.ledger
+		WHERE profile_id = $1 AND sequence > $2
+			AND ($3::timestamptz IS NULL OR created_at >= $3)
+			AND ($4::timestamptz IS NULL OR created_at < $4)
+			AND ($5::text = '' OR currency = $5)
+		ORDER BY sequence
+		LIMIT $6`, filter.ProfileID, filter.AfterSequence, from, to, string(filter.Currency), filter.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("Query(): %w", dbError(err))
+	}
+	defer rows.Close()
+
+	var results []*model.LedgerEntry
+	for rows.Next() {
+		entry := &model.LedgerEntry{}
+		var reason string
+		var transferID *uuid.UUID
+		err := rows.Scan(&entry.EntryID, &entry.Sequence, &entry.ProfileID, (*string)(&entry.Currency), &entry.Delta, &entry.Balance,
+			&reason, &entry.Reference, &transferID, &entry.CreatedAt)
 		if err != nil {
-			err = tx.Rollback(ctx)
-			if err != nil {
-				logrus.Errorf("Rollback: %v", err)
-				return
-			}
-		} else {
-			err = tx.Commit(ctx)
-			if err != nil {
-				logrus.Errorf("Commit: %v", err)
-				return
-			}
+			return nil, fmt.Errorf("Scan(): %w", dbError(err))
+		}
+		entry.Reason = model.Reason(reason)
+		if transferID != nil {
+			entry.TransferID = *transferID
 		}
-	}()
-	_, err = tx.Exec(ctx, "INSERT INTO shares.balance VALUES ($1, $2, $3)", balance.BalanceID, balance.ProfileID, balance.Balance)
+		results = append(results, entry)
+	}
+	return results, rows.Err()
+}
+
+// LatestSequence function returns the sequence of the newest ledger entry of a profile in currency, 0 if it has none
+func (db *PsqlConnection) LatestSequence(ctx context.Context, profileID uuid.UUID, currency model.Currency) (int64, error) {
+	var sequence int64
+	err := db.queryRow(ctx, "SELECT COALESCE(max(sequence), 0) FROM shares.ledger WHERE profile_id = $1 AND currency = $2",
+		profileID, string(currency)).Scan(&sequence)
 	if err != nil {
-		return fmt.Errorf("exec: %w", err)
+		return 0, fmt.Errorf("QueryRow(): %w", dbError(err))
 	}
-	return nil
+	return sequence, nil
 }
 
-// DeleteBalance function deletes user's balance
-func (db *PsqlConnection) DeleteBalance(ctx context.Context, ProfileID uuid.UUID) error {
-	tx, err := db.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: "repeatable read"})
+// SetCreditLimit function sets how far the available balance of a profile in currency may go below zero
+// and returns the resulting balance. A limit lower than the overdraft already in use fails with model.ErrCreditLimitInUse
+// and closed balances keep their limit.
+func (db *PsqlConnection) SetCreditLimit(ctx context.Context, profileID uuid.UUID, currency model.Currency, creditLimit model.Money) (*model.Balance, error) {
+	var balance model.Balance
+	err := db.inTx(ctx, pgx.TxOptions{IsoLevel: "read committed"}, func(tx pgx.Tx) error {
+		var held model.Money
+		var status string
+		err := tx.QueryRow(ctx, `SELECT balance_id, profile_id, currency, balance, held, status FROM shares.balance
+			WHERE profile_id = $1 AND currency = $2 FOR UPDATE`, profileID, string(currency)).
+			Scan(&balance.BalanceID, &balance.ProfileID, (*string)(&balance.Currency), &balance.Balance, &held, &status)
+		if err != nil {
+			return fmt.Errorf("QueryRow(): %w", dbError(err))
+		}
+		balance.Status = model.BalanceStatus(status)
+		if balance.Status == model.StatusClosed {
+			return model.ErrBalanceClosed
+		}
+		err = checkCreditLimit(balance.Balance, held, creditLimit)
+		if errors.Is(err, model.ErrInsufficientFunds) {
+			return model.ErrCreditLimitInUse
+		}
+		if err != nil {
+			return err
+		}
+		err = tx.QueryRow(ctx, `UPDATE shares.balance SET credit_limit = $1, version = version + 1 WHERE balance_id = $2
+			RETURNING balance - held, credit_limit, version`, creditLimit, balance.BalanceID).
+			Scan(&balance.Available, &balance.CreditLimit, &balance.Version)
+		if err != nil {
+			return fmt.Errorf("QueryRow(): %w", dbError(err))
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("BeginTx: %w", err)
+		return nil, err
 	}
-	defer func() {
+	return &balance, nil
+}
+
+// Transfer function moves funds between the transfer.Currency balances of two profiles in one transaction
+// and returns both resulting balances. Rows are locked in profile_id order,
+// so two opposite transfers between the same profiles cannot deadlock.
+func (db *PsqlConnection) Transfer(ctx context.Context, transfer *model.Transfer) (*model.Balance, *model.Balance, error) {
+	var from, to *model.Balance
+	err := db.inTx(ctx, pgx.TxOptions{IsoLevel: "read committed"}, func(tx pgx.Tx) error {
+		locked, held, err := lockTransferBalances(ctx, tx, transfer)
+		if err != nil {
+			return err
+		}
+		from, to = locked[transfer.FromProfileID], locked[transfer.ToProfileID]
+		if from == nil || to == nil {
+			return fmt.Errorf("QueryRow(): %w", dbError(pgx.ErrNoRows))
+		}
+		err = from.Status.CheckDebit()
+		if err != nil {
+			return err
+		}
+		err = to.Status.CheckCredit()
+		if err != nil {
+			return err
+		}
+		from.Balance, err = from.Balance.Sub(transfer.Amount)
 		if err != nil {
-			err = tx.Rollback(ctx)
+			return fmt.Errorf("Sub(): %w", err)
+		}
+		from.Available, err = from.Balance.Sub(held[from.ProfileID])
+		if err != nil {
+			return fmt.Errorf("Sub(): %w", err)
+		}
+		err = checkCreditLimit(from.Balance, held[from.ProfileID], from.CreditLimit)
+		if err != nil {
+			return err
+		}
+		to.Balance, err = to.Balance.Add(transfer.Amount)
+		if err != nil {
+			return fmt.Errorf("Add(): %w", err)
+		}
+		to.Available, err = to.Balance.Sub(held[to.ProfileID])
+		if err != nil {
+			return fmt.Errorf("Sub(): %w", err)
+		}
+		debit, err := transfer.Amount.Neg()
+		if err != nil {
+			return fmt.Errorf("Neg(): %w", err)
+		}
+		legs := []struct {
+			balance *model.Balance
+			delta   model.Money
+			reason  model.Reason
+		}{
+			{from, debit, model.ReasonTransferOut},
+			{to, transfer.Amount, model.ReasonTransferIn},
+		}
+		for _, leg := range legs {
+			err = tx.QueryRow(ctx, "UPDATE shares.balance SET balance = $1, version = version + 1 WHERE balance_id = $2 RETURNING version",
+				leg.balance.Balance, leg.balance.BalanceID).Scan(&leg.balance.Version)
 			if err != nil {
-				logrus.Errorf("Rollback: %v", err)
-				return
+				return fmt.Errorf("QueryRow(): %w", dbError(err))
 			}
-		} else {
-			err = tx.Commit(ctx)
+			err = insertLedgerEntry(ctx, tx, &model.LedgerEntry{
+				ProfileID:  leg.balance.ProfileID,
+				Currency:   transfer.Currency,
+				Delta:      leg.delta,
+				Balance:    leg.balance.Balance,
+				Reason:     leg.reason,
+				Reference:  transfer.Reference,
+				TransferID: transfer.TransferID,
+			})
 			if err != nil {
-				logrus.Errorf("Commit: %v", err)
-				return
+				return fmt.Errorf("insertLedgerEntry: %w", err)
 			}
 		}
-	}()
-	balanceID := uuid.New()
-	err = tx.QueryRow(ctx, "SELECT balance_id FROM shares.balance WHERE profile_id = $1", ProfileID).Scan(&balanceID)
-	if err != nil || ProfileID == uuid.Nil {
-		return fmt.Errorf("QueryRow(): %w", err)
+		err = insertEvent(ctx, tx, model.EventTransferCompleted, transfer.FromProfileID, transfer)
+		if err != nil {
+			return fmt.Errorf("insertEvent: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
 	}
-	tag, err := tx.Exec(ctx, "DELETE FROM shares.balance WHERE balance_id = $1", balanceID)
-	if err != nil || tag.RowsAffected() == 0 {
-		return fmt.Errorf("exec: %w", err)
+	return from, to, nil
+}
+
+// lockTransferBalances locks the transfer.Currency balances of both profiles of transfer in profile_id order and returns
+// them and their held amounts by profile ID, a balance that does not exist is missing from both maps
+func lockTransferBalances(ctx context.Context, tx pgx.Tx, transfer *model.Transfer) (map[uuid.UUID]*model.Balance, map[uuid.UUID]model.Money, error) {
+	rows, err := tx.Query(ctx, `SELECT balance_id, profile_id, currency, balance, held, credit_limit, status FROM shares.balance
+		WHERE profile_id IN ($1, $2) AND currency = $3 ORDER BY profile_id FOR UPDATE`,
+		transfer.FromProfileID, transfer.ToProfileID, string(transfer.Currency))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Query(): %w", dbError(err))
 	}
-	return nil
+	defer rows.Close()
+	locked := make(map[uuid.UUID]*model.Balance, 2)
+	held := make(map[uuid.UUID]model.Money, 2)
+	for rows.Next() {
+		balance := &model.Balance{}
+		var onHold model.Money
+		var status string
+		err = rows.Scan(&balance.BalanceID, &balance.ProfileID, (*string)(&balance.Currency), &balance.Balance, &onHold, &balance.CreditLimit, &status)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Scan(): %w", dbError(err))
+		}
+		balance.Status = model.BalanceStatus(status)
+		locked[balance.ProfileID] = balance
+		held[balance.ProfileID] = onHold
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("rows: %w", dbError(err))
+	}
+	return locked, held, nil
 }
@@ -3,78 +3,794 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/eugenshima/balance/internal/migrations"
 	"github.com/eugenshima/balance/internal/model"
+	"github.com/eugenshima/balance/internal/repository/repositorytest"
+	"github.com/eugenshima/balance/internal/service"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/require"
 )
 
 var rps *PsqlConnection
 
+const testCurrency = model.Currency("USD")
+
 var testEntity = model.Balance{
 	BalanceID: uuid.New(),
-	Balance:   1234.25,
+	Currency:  testCurrency,
+	Balance:   model.MustParseMoney("1234.25"),
 }
 
 var wrongTestEntity = model.Balance{
 	BalanceID: uuid.Nil,
-	Balance:   1234.75,
+	Currency:  testCurrency,
+	Balance:   model.MustParseMoney("1234.75"),
 }
 
-// TestPgxCreateDeleteBalance function tests create and delete methods
-func TestPgxCreateDeleteBalance(t *testing.T) {
-	err := rps.CreateBalance(context.Background(), &testEntity)
+// TestPgxCreateCloseBalance function tests create and close methods
+func TestPgxCreateCloseBalance(t *testing.T) {
+	skipWithoutDB(t)
+	entity := testEntity
+	entity.ProfileID = uuid.New()
+	err := rps.CreateBalance(context.Background(), &entity)
+	require.NoError(t, err)
+	_, err = rps.ChangeStatus(context.Background(), &model.StatusChange{ProfileID: entity.ProfileID, Currency: testCurrency, Status: model.StatusClosed, Actor: "test"})
+	require.ErrorIs(t, err, model.ErrBalanceNotZero)
+	_, err = rps.Withdraw(context.Background(), entity.ProfileID, testCurrency, entity.Balance, "")
+	require.NoError(t, err)
+	result, err := rps.ChangeStatus(context.Background(), &model.StatusChange{ProfileID: entity.ProfileID, Currency: testCurrency, Status: model.StatusClosed, Actor: "test"})
 	require.NoError(t, err)
-	err = rps.DeleteBalance(context.Background(), testEntity.BalanceID)
+	require.Equal(t, model.StatusClosed, result.Status)
+	result, err = rps.GetUserByID(context.Background(), entity.ProfileID, testCurrency)
 	require.NoError(t, err)
+	require.Equal(t, model.StatusClosed, result.Status)
 }
 
-// TestPgxDeleteNilBalance function tests nil deletion of delete method
-func TestPgxDeleteNilBalance(t *testing.T) {
-	err := rps.DeleteBalance(context.Background(), wrongTestEntity.BalanceID)
+// TestPgxCloseNilBalance function tests closing a missing balance
+func TestPgxCloseNilBalance(t *testing.T) {
+	skipWithoutDB(t)
+	_, err := rps.ChangeStatus(context.Background(), &model.StatusChange{ProfileID: wrongTestEntity.ProfileID, Currency: testCurrency, Status: model.StatusClosed, Actor: "test"})
 	require.Error(t, err)
 }
 
 // TestPgxUpdateBalance function tests update method
 func TestPgxUpdateBalance(t *testing.T) {
-	err := rps.CreateBalance(context.Background(), &testEntity)
+	skipWithoutDB(t)
+	entity := testEntity
+	entity.BalanceID, entity.ProfileID = uuid.New(), uuid.New()
+	err := rps.CreateBalance(context.Background(), &entity)
 	require.NoError(t, err)
-	testEntity.Balance = 4321
-	err = rps.UpdateBalance(context.Background(), &testEntity)
-	require.NoError(t, err)
-	err = rps.DeleteBalance(context.Background(), testEntity.BalanceID)
+	entity.Balance = model.MustParseMoney("4321")
+	err = rps.UpdateBalance(context.Background(), &entity)
 	require.NoError(t, err)
 }
 
 // TestPgxErrorUpdateBalance function tests error update method
 func TestPgxErrorUpdateBalance(t *testing.T) {
-	err := rps.UpdateBalance(context.Background(), &testEntity)
+	skipWithoutDB(t)
+	err := rps.UpdateBalance(context.Background(), &wrongTestEntity)
 	require.Error(t, err)
 }
 
 // TestGetBalanceByID function tests get method
 func TestGetBalanceByID(t *testing.T) {
-	err := rps.CreateBalance(context.Background(), &testEntity)
+	skipWithoutDB(t)
+	entity := testEntity
+	entity.BalanceID, entity.ProfileID = uuid.New(), uuid.New()
+	err := rps.CreateBalance(context.Background(), &entity)
 	require.NoError(t, err)
-	testResult, err := rps.GetUserByID(context.Background(), testEntity.BalanceID)
+	testResult, err := rps.GetUserByID(context.Background(), entity.ProfileID, testCurrency)
 	require.NoError(t, err)
 	require.NotNil(t, testResult)
-	err = rps.DeleteBalance(context.Background(), testEntity.BalanceID)
-	require.NoError(t, err)
+	require.Equal(t, model.StatusActive, testResult.Status)
 }
 
 // TestGetBalanceByWrongID function tests error get method
 func TestGetBalanceByWrongID(t *testing.T) {
-	testResult, err := rps.GetUserByID(context.Background(), testEntity.BalanceID)
+	skipWithoutDB(t)
+	testResult, err := rps.GetUserByID(context.Background(), uuid.New(), testCurrency)
 	require.Error(t, err)
 	require.Nil(t, testResult)
 }
 
 // TestGetAllBalances function tests get all method
 func TestGetAllBalances(t *testing.T) {
-	testResult, err := rps.GetAll(context.Background())
+	skipWithoutDB(t)
+	testResult, err := rps.GetAll(context.Background(), model.BalanceFilter{Sort: model.SortByProfileID, Limit: 10})
 	require.NoError(t, err)
 	require.NotNil(t, testResult)
 }
+
+// TestPgxGetAllKeyset function tests keyset pagination over filtered and sorted balances
+func TestPgxGetAllKeyset(t *testing.T) {
+	skipWithoutDB(t)
+	amounts := []string{"5", "7", "7", "1"}
+	profileIDs := make([]uuid.UUID, 0, len(amounts))
+	for _, amount := range amounts {
+		entity := model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: testCurrency, Balance: model.MustParseMoney(amount)}
+		require.NoError(t, rps.CreateBalance(context.Background(), &entity))
+		profileIDs = append(profileIDs, entity.ProfileID)
+	}
+	minBalance := model.MustParseMoney("2")
+	filter := model.BalanceFilter{ProfileIDs: profileIDs, MinBalance: &minBalance, Sort: model.SortByBalanceDesc, Limit: 2}
+
+	var seen []*model.Balance
+	for {
+		page, err := rps.GetAll(context.Background(), filter)
+		require.NoError(t, err)
+		if len(page) == 0 {
+			break
+		}
+		seen = append(seen, page...)
+		last := page[len(page)-1]
+		filter.After = &model.BalanceCursor{ProfileID: last.ProfileID, Currency: last.Currency, Balance: last.Balance}
+	}
+	require.Len(t, seen, 3)
+	for i := 1; i < len(seen); i++ {
+		require.GreaterOrEqual(t, seen[i-1].Balance.Cmp(seen[i].Balance), 0)
+	}
+	require.Equal(t, model.MustParseMoney("5"), seen[2].Balance)
+
+	_, err := rps.GetAll(context.Background(), model.BalanceFilter{Sort: "volume", Limit: 1})
+	require.ErrorIs(t, err, model.ErrInvalidSort)
+}
+
+// TestPgxStreamAll function tests that streaming visits every balance once in profile ID order
+func TestPgxStreamAll(t *testing.T) {
+	skipWithoutDB(t)
+	created := map[uuid.UUID]bool{}
+	for i := 0; i < 3; i++ {
+		entity := model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: testCurrency}
+		require.NoError(t, rps.CreateBalance(context.Background(), &entity))
+		created[entity.ProfileID] = true
+	}
+	var streamed []uuid.UUID
+	err := rps.StreamAll(context.Background(), func(balance *model.Balance) error {
+		streamed = append(streamed, balance.ProfileID)
+		delete(created, balance.ProfileID)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Empty(t, created)
+	for i := 1; i < len(streamed); i++ {
+		require.Less(t, streamed[i-1].String(), streamed[i].String())
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = rps.StreamAll(context.Background(), func(*model.Balance) error {
+		calls++
+		return stop
+	})
+	require.ErrorIs(t, err, stop)
+	require.Equal(t, 1, calls)
+}
+
+// TestPgxBalanceWatcher function tests that committed ledger entries signal the subscribers of their profile only
+func TestPgxBalanceWatcher(t *testing.T) {
+	skipWithoutDB(t)
+	entity := model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: testCurrency}
+	require.NoError(t, rps.CreateBalance(context.Background(), &entity))
+	watcher := NewBalanceWatcher(rps.pool)
+	changed, unsubscribe := watcher.Subscribe(entity.ProfileID)
+	defer unsubscribe()
+	other, unsubscribeOther := watcher.Subscribe(uuid.New())
+	defer unsubscribeOther()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watcher.Run(ctx)
+
+	// The first signal is sent once listening starts
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watcher did not start listening")
+	}
+	<-other
+
+	balance, err := rps.Deposit(context.Background(), entity.ProfileID, testCurrency, model.MustParseMoney("3"), "watch")
+	require.NoError(t, err)
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deposit was not signalled")
+	}
+	select {
+	case <-other:
+		t.Fatal("another profile was signalled")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	latest, err := rps.LatestSequence(context.Background(), entity.ProfileID, testCurrency)
+	require.NoError(t, err)
+	entries, err := rps.ListTransactions(context.Background(), model.LedgerFilter{ProfileID: entity.ProfileID, AfterSequence: latest - 1, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, balance.Balance, entries[0].Balance)
+}
+
+// TestPgxBalancePrecision function tests that amounts are stored without float drift
+func TestPgxBalancePrecision(t *testing.T) {
+	skipWithoutDB(t)
+	entity := model.Balance{
+		BalanceID: uuid.New(),
+		ProfileID: uuid.New(),
+		Currency:  testCurrency,
+		Balance:   model.MustParseMoney("0.1"),
+	}
+	err := rps.CreateBalance(context.Background(), &entity)
+	require.NoError(t, err)
+	entity.Balance, err = entity.Balance.Add(model.MustParseMoney("0.2"))
+	require.NoError(t, err)
+	err = rps.UpdateBalance(context.Background(), &entity)
+	require.NoError(t, err)
+	result, err := rps.GetUserByID(context.Background(), entity.ProfileID, testCurrency)
+	require.NoError(t, err)
+	require.Equal(t, model.MustParseMoney("0.3"), result.Balance)
+}
+
+// TestPgxDepositWithdraw function tests deposit and withdraw methods
+func TestPgxDepositWithdraw(t *testing.T) {
+	skipWithoutDB(t)
+	entity := model.Balance{
+		BalanceID: uuid.New(),
+		ProfileID: uuid.New(),
+		Currency:  testCurrency,
+		Balance:   model.MustParseMoney("10"),
+	}
+	err := rps.CreateBalance(context.Background(), &entity)
+	require.NoError(t, err)
+	result, err := rps.Deposit(context.Background(), entity.ProfileID, testCurrency, model.MustParseMoney("2.5"), "deposit-1")
+	require.NoError(t, err)
+	require.Equal(t, model.MustParseMoney("12.5"), result.Balance)
+	result, err = rps.Withdraw(context.Background(), entity.ProfileID, testCurrency, model.MustParseMoney("12.5"), "withdrawal-1")
+	require.NoError(t, err)
+	require.True(t, result.Balance.IsZero())
+	_, err = rps.Withdraw(context.Background(), entity.ProfileID, testCurrency, model.MustParseMoney("0.01"), "withdrawal-2")
+	require.ErrorIs(t, err, model.ErrInsufficientFunds)
+}
+
+// TestPgxDepositUnknownProfile function tests deposit to a missing balance
+func TestPgxDepositUnknownProfile(t *testing.T) {
+	skipWithoutDB(t)
+	_, err := rps.Deposit(context.Background(), uuid.New(), testCurrency, model.MustParseMoney("1"), "")
+	require.ErrorIs(t, err, model.ErrNotFound)
+	require.NotErrorIs(t, err, model.ErrInsufficientFunds)
+}
+
+// TestPgxConcurrentDeposits function tests that concurrent deltas are not lost
+func TestPgxConcurrentDeposits(t *testing.T) {
+	skipWithoutDB(t)
+	entity := model.Balance{
+		BalanceID: uuid.New(),
+		ProfileID: uuid.New(),
+		Currency:  testCurrency,
+	}
+	err := rps.CreateBalance(context.Background(), &entity)
+	require.NoError(t, err)
+	const workers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, depositErr := rps.Deposit(context.Background(), entity.ProfileID, testCurrency, model.MustParseMoney("0.1"), "")
+			errs <- depositErr
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for depositErr := range errs {
+		require.NoError(t, depositErr)
+	}
+	result, err := rps.GetUserByID(context.Background(), entity.ProfileID, testCurrency)
+	require.NoError(t, err)
+	require.Equal(t, model.MustParseMoney("2"), result.Balance)
+}
+
+// TestPgxLedgerRecordsEveryChange function tests that each mutation writes a ledger entry
+func TestPgxLedgerRecordsEveryChange(t *testing.T) {
+	skipWithoutDB(t)
+	entity := model.Balance{
+		BalanceID: uuid.New(),
+		ProfileID: uuid.New(),
+		Currency:  testCurrency,
+		Balance:   model.MustParseMoney("100"),
+	}
+	err := rps.CreateBalance(context.Background(), &entity)
+	require.NoError(t, err)
+	_, err = rps.Deposit(context.Background(), entity.ProfileID, testCurrency, model.MustParseMoney("12.5"), "dep-1")
+	require.NoError(t, err)
+	_, err = rps.Withdraw(context.Background(), entity.ProfileID, testCurrency, model.MustParseMoney("200"), "wd-1")
+	require.ErrorIs(t, err, model.ErrInsufficientFunds)
+	entity.Balance = model.MustParseMoney("412.5")
+	err = rps.UpdateBalance(context.Background(), &entity)
+	require.NoError(t, err)
+
+	entries, err := rps.ListTransactions(context.Background(), model.LedgerFilter{ProfileID: entity.ProfileID, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.Equal(t, model.ReasonOpening, entries[0].Reason)
+	require.Equal(t, model.MustParseMoney("100"), entries[0].Balance)
+	require.Equal(t, model.ReasonDeposit, entries[1].Reason)
+	require.Equal(t, "dep-1", entries[1].Reference)
+	require.Equal(t, model.MustParseMoney("112.5"), entries[1].Balance)
+	require.Equal(t, model.ReasonAdjustment, entries[2].Reason)
+	require.Equal(t, model.MustParseMoney("300"), entries[2].Delta)
+	require.Equal(t, model.MustParseMoney("412.5"), entries[2].Balance)
+
+	page, err := rps.ListTransactions(context.Background(), model.LedgerFilter{ProfileID: entity.ProfileID, AfterSequence: entries[0].Sequence, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	require.Equal(t, entries[1].EntryID, page[0].EntryID)
+
+	page, err = rps.ListTransactions(context.Background(), model.LedgerFilter{ProfileID: entity.ProfileID, From: entries[2].CreatedAt.Add(time.Hour), Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, page)
+}
+
+// TestPgxTransfer function tests transfer method and its ledger legs
+func TestPgxTransfer(t *testing.T) {
+	skipWithoutDB(t)
+	from := model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: testCurrency, Balance: model.MustParseMoney("50")}
+	to := model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: testCurrency, Balance: model.MustParseMoney("5")}
+	require.NoError(t, rps.CreateBalance(context.Background(), &from))
+	require.NoError(t, rps.CreateBalance(context.Background(), &to))
+
+	transfer := &model.Transfer{
+		TransferID:    uuid.New(),
+		FromProfileID: from.ProfileID,
+		ToProfileID:   to.ProfileID,
+		Currency:      testCurrency,
+		Amount:        model.MustParseMoney("20.25"),
+		Reference:     "payout-1",
+	}
+	fromResult, toResult, err := rps.Transfer(context.Background(), transfer)
+	require.NoError(t, err)
+	require.Equal(t, model.MustParseMoney("29.75"), fromResult.Balance)
+	require.Equal(t, model.MustParseMoney("25.25"), toResult.Balance)
+
+	for _, profileID := range []uuid.UUID{from.ProfileID, to.ProfileID} {
+		entries, listErr := rps.ListTransactions(context.Background(), model.LedgerFilter{ProfileID: profileID, Limit: 10})
+		require.NoError(t, listErr)
+		require.Len(t, entries, 2)
+		require.Equal(t, transfer.TransferID, entries[1].TransferID)
+	}
+
+	transfer.TransferID = uuid.New()
+	transfer.Amount = model.MustParseMoney("29.76")
+	_, _, err = rps.Transfer(context.Background(), transfer)
+	require.ErrorIs(t, err, model.ErrInsufficientFunds)
+
+	transfer.ToProfileID = uuid.New()
+	transfer.Amount = model.MustParseMoney("1")
+	_, _, err = rps.Transfer(context.Background(), transfer)
+	require.Error(t, err)
+
+}
+
+// TestPgxOppositeTransfersDoNotDeadlock function tests concurrent transfers in both directions
+func TestPgxOppositeTransfersDoNotDeadlock(t *testing.T) {
+	skipWithoutDB(t)
+	a := model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: testCurrency, Balance: model.MustParseMoney("100")}
+	b := model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: testCurrency, Balance: model.MustParseMoney("100")}
+	require.NoError(t, rps.CreateBalance(context.Background(), &a))
+	require.NoError(t, rps.CreateBalance(context.Background(), &b))
+
+	const rounds = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*rounds)
+	for i := 0; i < rounds; i++ {
+		for _, pair := range [][2]uuid.UUID{{a.ProfileID, b.ProfileID}, {b.ProfileID, a.ProfileID}} {
+			wg.Add(1)
+			go func(from, to uuid.UUID) {
+				defer wg.Done()
+				_, _, transferErr := rps.Transfer(context.Background(), &model.Transfer{
+					TransferID:    uuid.New(),
+					FromProfileID: from,
+					ToProfileID:   to,
+					Currency:      testCurrency,
+					Amount:        model.MustParseMoney("1"),
+				})
+				errs <- transferErr
+			}(pair[0], pair[1])
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for transferErr := range errs {
+		require.NoError(t, transferErr)
+	}
+	resultA, err := rps.GetUserByID(context.Background(), a.ProfileID, testCurrency)
+	require.NoError(t, err)
+	require.Equal(t, model.MustParseMoney("100"), resultA.Balance)
+
+}
+
+func TestPgxHolds(t *testing.T) {
+	skipWithoutDB(t)
+	entity := model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: testCurrency, Balance: model.MustParseMoney("100")}
+	require.NoError(t, rps.CreateBalance(context.Background(), &entity))
+
+	hold := &model.Hold{HoldID: uuid.New(), ProfileID: entity.ProfileID, Currency: testCurrency, Amount: model.MustParseMoney("60"), Reference: "order-1"}
+	require.NoError(t, rps.CreateHold(context.Background(), hold))
+	require.Equal(t, model.HoldActive, hold.Status)
+
+	result, err := rps.GetUserByID(context.Background(), entity.ProfileID, testCurrency)
+	require.NoError(t, err)
+	require.Equal(t, model.MustParseMoney("100"), result.Balance)
+	require.Equal(t, model.MustParseMoney("40"), result.Available)
+
+	_, err = rps.Withdraw(context.Background(), entity.ProfileID, testCurrency, model.MustParseMoney("40.01"), "")
+	require.ErrorIs(t, err, model.ErrInsufficientFunds)
+	err = rps.CreateHold(context.Background(), &model.Hold{HoldID: uuid.New(), ProfileID: entity.ProfileID, Currency: testCurrency, Amount: model.MustParseMoney("41")})
+	require.ErrorIs(t, err, model.ErrInsufficientFunds)
+	err = rps.UpdateBalance(context.Background(), &model.Balance{ProfileID: entity.ProfileID, Currency: testCurrency, Balance: model.MustParseMoney("59")})
+	require.ErrorIs(t, err, model.ErrInsufficientFunds)
+
+	_, _, err = rps.CaptureHold(context.Background(), hold.HoldID, model.MustParseMoney("60.5"))
+	require.ErrorIs(t, err, model.ErrCaptureExceedsHold)
+	captured, balance, err := rps.CaptureHold(context.Background(), hold.HoldID, model.MustParseMoney("45.5"))
+	require.NoError(t, err)
+	require.Equal(t, model.HoldCaptured, captured.Status)
+	require.Equal(t, model.MustParseMoney("45.5"), captured.Captured)
+	require.Equal(t, model.MustParseMoney("54.5"), balance.Balance)
+	require.Equal(t, model.MustParseMoney("54.5"), balance.Available)
+	_, err = rps.ReleaseHold(context.Background(), hold.HoldID)
+	require.ErrorIs(t, err, model.ErrHoldNotActive)
+
+	second := &model.Hold{HoldID: uuid.New(), ProfileID: entity.ProfileID, Currency: testCurrency, Amount: model.MustParseMoney("50")}
+	require.NoError(t, rps.CreateHold(context.Background(), second))
+	released, err := rps.ReleaseHold(context.Background(), second.HoldID)
+	require.NoError(t, err)
+	require.Equal(t, model.HoldReleased, released.Status)
+	result, err = rps.GetUserByID(context.Background(), entity.ProfileID, testCurrency)
+	require.NoError(t, err)
+	require.Equal(t, model.MustParseMoney("54.5"), result.Available)
+
+	entries, err := rps.ListTransactions(context.Background(), model.LedgerFilter{ProfileID: entity.ProfileID, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, model.ReasonHoldCapture, entries[1].Reason)
+	require.Equal(t, "order-1", entries[1].Reference)
+
+}
+
+func TestPgxFrozenBalance(t *testing.T) {
+	skipWithoutDB(t)
+	entity := model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: testCurrency, Balance: model.MustParseMoney("10")}
+	other := model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: testCurrency, Balance: model.MustParseMoney("10")}
+	require.NoError(t, rps.CreateBalance(context.Background(), &entity))
+	require.NoError(t, rps.CreateBalance(context.Background(), &other))
+
+	_, err := rps.ChangeStatus(context.Background(), &model.StatusChange{ProfileID: entity.ProfileID, Currency: testCurrency, Status: model.StatusDebitFrozen, Actor: "compliance", Reason: "review"})
+	require.NoError(t, err)
+	_, err = rps.Withdraw(context.Background(), entity.ProfileID, testCurrency, model.MustParseMoney("1"), "")
+	require.ErrorIs(t, err, model.ErrBalanceFrozen)
+	err = rps.CreateHold(context.Background(), &model.Hold{HoldID: uuid.New(), ProfileID: entity.ProfileID, Currency: testCurrency, Amount: model.MustParseMoney("1")})
+	require.ErrorIs(t, err, model.ErrBalanceFrozen)
+	_, _, err = rps.Transfer(context.Background(), &model.Transfer{TransferID: uuid.New(), Currency: testCurrency, FromProfileID: entity.ProfileID, ToProfileID: other.ProfileID, Amount: model.MustParseMoney("1")})
+	require.ErrorIs(t, err, model.ErrBalanceFrozen)
+	result, err := rps.Deposit(context.Background(), entity.ProfileID, testCurrency, model.MustParseMoney("1"), "")
+	require.NoError(t, err)
+	require.Equal(t, model.MustParseMoney("11"), result.Balance)
+
+	_, err = rps.ChangeStatus(context.Background(), &model.StatusChange{ProfileID: entity.ProfileID, Currency: testCurrency, Status: model.StatusFullyFrozen, Actor: "compliance"})
+	require.NoError(t, err)
+	_, err = rps.Deposit(context.Background(), entity.ProfileID, testCurrency, model.MustParseMoney("1"), "")
+	require.ErrorIs(t, err, model.ErrBalanceFrozen)
+	entity.Balance = model.MustParseMoney("12")
+	require.ErrorIs(t, rps.UpdateBalance(context.Background(), &entity), model.ErrBalanceFrozen)
+	_, err = rps.ChangeStatus(context.Background(), &model.StatusChange{ProfileID: entity.ProfileID, Currency: testCurrency, Status: model.StatusClosed, Actor: "compliance"})
+	require.ErrorIs(t, err, model.ErrBalanceFrozen)
+
+	_, err = rps.ChangeStatus(context.Background(), &model.StatusChange{ProfileID: entity.ProfileID, Currency: testCurrency, Status: model.StatusActive, Actor: "compliance"})
+	require.NoError(t, err)
+	_, err = rps.Withdraw(context.Background(), entity.ProfileID, testCurrency, model.MustParseMoney("1"), "")
+	require.NoError(t, err)
+}
+
+func TestPgxOptimisticVersion(t *testing.T) {
+	skipWithoutDB(t)
+	entity := model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: testCurrency, Balance: model.MustParseMoney("10")}
+	require.NoError(t, rps.CreateBalance(context.Background(), &entity))
+	require.Equal(t, int64(1), entity.Version)
+
+	first, err := rps.GetUserByID(context.Background(), entity.ProfileID, testCurrency)
+	require.NoError(t, err)
+	second := *first
+
+	first.Balance = model.MustParseMoney("20")
+	require.NoError(t, rps.UpdateBalance(context.Background(), first))
+	require.Equal(t, int64(2), first.Version)
+
+	second.Balance = model.MustParseMoney("30")
+	require.ErrorIs(t, rps.UpdateBalance(context.Background(), &second), model.ErrVersionConflict)
+
+	result, err := rps.Deposit(context.Background(), entity.ProfileID, testCurrency, model.MustParseMoney("1"), "")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), result.Version)
+	second.Version = 0
+	require.NoError(t, rps.UpdateBalance(context.Background(), &second))
+	require.Equal(t, int64(4), second.Version)
+}
+
+func TestPgxCreateDuplicateBalance(t *testing.T) {
+	skipWithoutDB(t)
+	entity := model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: testCurrency}
+	require.NoError(t, rps.CreateBalance(context.Background(), &entity))
+	duplicate := model.Balance{BalanceID: uuid.New(), ProfileID: entity.ProfileID, Currency: testCurrency}
+	require.ErrorIs(t, rps.CreateBalance(context.Background(), &duplicate), model.ErrAlreadyExists)
+}
+
+func TestPgxMultiCurrencyBalances(t *testing.T) {
+	skipWithoutDB(t)
+	usd := model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: "USD", Balance: model.MustParseMoney("10")}
+	eur := model.Balance{BalanceID: uuid.New(), ProfileID: usd.ProfileID, Currency: "EUR", Balance: model.MustParseMoney("3")}
+	require.NoError(t, rps.CreateBalance(context.Background(), &usd))
+	require.NoError(t, rps.CreateBalance(context.Background(), &eur))
+
+	result, err := rps.Deposit(context.Background(), usd.ProfileID, "EUR", model.MustParseMoney("1.5"), "")
+	require.NoError(t, err)
+	require.Equal(t, model.MustParseMoney("4.5"), result.Balance)
+	result, err = rps.GetUserByID(context.Background(), usd.ProfileID, "USD")
+	require.NoError(t, err)
+	require.Equal(t, model.MustParseMoney("10"), result.Balance)
+	_, err = rps.GetUserByID(context.Background(), usd.ProfileID, "GBP")
+	require.ErrorIs(t, err, model.ErrNotFound)
+
+	entries, err := rps.ListTransactions(context.Background(), model.LedgerFilter{ProfileID: usd.ProfileID, Currency: "EUR", Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, model.Currency("EUR"), entries[1].Currency)
+
+	page, err := rps.GetAll(context.Background(), model.BalanceFilter{ProfileIDs: []uuid.UUID{usd.ProfileID}, Sort: model.SortByProfileID, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	page, err = rps.GetAll(context.Background(), model.BalanceFilter{ProfileIDs: []uuid.UUID{usd.ProfileID}, Currency: "USD", Sort: model.SortByProfileID, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+}
+
+func TestPgxCreditLimit(t *testing.T) {
+	skipWithoutDB(t)
+	negative := model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: testCurrency, Balance: model.MustParseMoney("-1")}
+	require.ErrorIs(t, rps.CreateBalance(context.Background(), &negative), model.ErrInsufficientFunds)
+
+	entity := model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: testCurrency, Balance: model.MustParseMoney("10")}
+	other := model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: testCurrency}
+	require.NoError(t, rps.CreateBalance(context.Background(), &entity))
+	require.NoError(t, rps.CreateBalance(context.Background(), &other))
+	_, err := rps.Withdraw(context.Background(), entity.ProfileID, testCurrency, model.MustParseMoney("10.01"), "")
+	require.ErrorIs(t, err, model.ErrInsufficientFunds)
+	err = rps.UpdateBalance(context.Background(), &model.Balance{ProfileID: entity.ProfileID, Currency: testCurrency, Balance: model.MustParseMoney("-1")})
+	require.ErrorIs(t, err, model.ErrInsufficientFunds)
+
+	result, err := rps.SetCreditLimit(context.Background(), entity.ProfileID, testCurrency, model.MustParseMoney("50"))
+	require.NoError(t, err)
+	require.Equal(t, model.MustParseMoney("50"), result.CreditLimit)
+	result, err = rps.Withdraw(context.Background(), entity.ProfileID, testCurrency, model.MustParseMoney("40"), "")
+	require.NoError(t, err)
+	require.Equal(t, model.MustParseMoney("-30"), result.Balance)
+	require.Equal(t, model.MustParseMoney("-30"), result.Available)
+	require.NoError(t, rps.CreateHold(context.Background(), &model.Hold{HoldID: uuid.New(), ProfileID: entity.ProfileID, Currency: testCurrency, Amount: model.MustParseMoney("15")}))
+	_, _, err = rps.Transfer(context.Background(), &model.Transfer{TransferID: uuid.New(), FromProfileID: entity.ProfileID, ToProfileID: other.ProfileID,
+		Currency: testCurrency, Amount: model.MustParseMoney("5.01")})
+	require.ErrorIs(t, err, model.ErrInsufficientFunds)
+	_, _, err = rps.Transfer(context.Background(), &model.Transfer{TransferID: uuid.New(), FromProfileID: entity.ProfileID, ToProfileID: other.ProfileID,
+		Currency: testCurrency, Amount: model.MustParseMoney("5")})
+	require.NoError(t, err)
+
+	_, err = rps.SetCreditLimit(context.Background(), entity.ProfileID, testCurrency, model.MustParseMoney("49.99"))
+	require.ErrorIs(t, err, model.ErrCreditLimitInUse)
+	_, err = rps.Deposit(context.Background(), entity.ProfileID, testCurrency, model.MustParseMoney("50"), "")
+	require.NoError(t, err)
+	result, err = rps.SetCreditLimit(context.Background(), entity.ProfileID, testCurrency, model.Money{})
+	require.NoError(t, err)
+	require.Equal(t, model.MustParseMoney("0"), result.Available)
+
+	_, err = rps.SetCreditLimit(context.Background(), uuid.New(), testCurrency, model.MustParseMoney("1"))
+	require.ErrorIs(t, err, model.ErrNotFound)
+}
+
+func TestPgxOutbox(t *testing.T) {
+	skipWithoutDB(t)
+	from := model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: testCurrency, Balance: model.MustParseMoney("10")}
+	to := model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: testCurrency}
+	require.NoError(t, rps.CreateBalance(context.Background(), &from))
+	require.NoError(t, rps.CreateBalance(context.Background(), &to))
+	require.NoError(t, rps.CreateHold(context.Background(), &model.Hold{HoldID: uuid.New(), ProfileID: from.ProfileID, Currency: testCurrency, Amount: model.MustParseMoney("1")}))
+	_, _, err := rps.Transfer(context.Background(), &model.Transfer{TransferID: uuid.New(), FromProfileID: from.ProfileID, ToProfileID: to.ProfileID,
+		Currency: testCurrency, Amount: model.MustParseMoney("2")})
+	require.NoError(t, err)
+	_, err = rps.Withdraw(context.Background(), from.ProfileID, testCurrency, model.MustParseMoney("100"), "")
+	require.ErrorIs(t, err, model.ErrInsufficientFunds)
+
+	failure := errors.New("broker down")
+	_, err = rps.PublishOutbox(context.Background(), 100, func(context.Context, []*model.Event) error { return failure })
+	require.ErrorIs(t, err, failure)
+
+	var types []model.EventType
+	var last int64
+	for {
+		published, err := rps.PublishOutbox(context.Background(), 2, func(_ context.Context, events []*model.Event) error {
+			for _, event := range events {
+				require.Greater(t, event.Sequence, last)
+				last = event.Sequence
+				if event.ProfileID == from.ProfileID {
+					types = append(types, event.Type)
+				}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		if published == 0 {
+			break
+		}
+	}
+	require.Equal(t, []model.EventType{model.EventBalanceChanged, model.EventHoldCreated, model.EventBalanceChanged, model.EventTransferCompleted}, types)
+}
+
+// TestPgxOutboxCommitOrder tests that the relay waits for a transaction still writing events
+// instead of publishing the later events of other transactions ahead of it
+func TestPgxOutboxCommitOrder(t *testing.T) {
+	skipWithoutDB(t)
+	first := model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: testCurrency}
+	second := model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: testCurrency}
+	require.NoError(t, rps.CreateBalance(context.Background(), &first))
+	require.NoError(t, rps.CreateBalance(context.Background(), &second))
+	var profiles []uuid.UUID
+	publishAll := func() {
+		for {
+			published, err := rps.PublishOutbox(context.Background(), 100, func(_ context.Context, events []*model.Event) error {
+				for _, event := range events {
+					if event.ProfileID == first.ProfileID || event.ProfileID == second.ProfileID {
+						profiles = append(profiles, event.ProfileID)
+					}
+				}
+				return nil
+			})
+			require.NoError(t, err)
+			if published == 0 {
+				return
+			}
+		}
+	}
+	publishAll()
+	err := rps.InTx(context.Background(), model.ReadCommitted, func(tx service.BalanceRepository) error {
+		_, err := tx.Deposit(context.Background(), first.ProfileID, testCurrency, model.MustParseMoney("1"), "")
+		if err != nil {
+			return err
+		}
+		_, err = rps.Deposit(context.Background(), second.ProfileID, testCurrency, model.MustParseMoney("1"), "")
+		if err != nil {
+			return err
+		}
+		publishAll()
+		require.Empty(t, profiles)
+		return nil
+	})
+	require.NoError(t, err)
+	publishAll()
+	require.Equal(t, []uuid.UUID{first.ProfileID, second.ProfileID}, profiles)
+
+	deleted, err := rps.DeleteOutboxEvents(context.Background(), 0)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, deleted, int64(2))
+}
+
+func TestPgxMetrics(t *testing.T) {
+	skipWithoutDB(t)
+	const currency = model.Currency("NOK")
+	funded := model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: currency, Balance: model.MustParseMoney("5.5")}
+	empty := model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: currency}
+	require.NoError(t, rps.CreateBalance(context.Background(), &funded))
+	require.NoError(t, rps.CreateBalance(context.Background(), &empty))
+	_, err := rps.Withdraw(context.Background(), empty.ProfileID, currency, model.MustParseMoney("1"), "")
+	require.ErrorIs(t, err, model.ErrInsufficientFunds)
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(rps, NewPoolCollector(rps.pool))
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	values := map[string]float64{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			key := family.GetName()
+			for _, label := range metric.GetLabel() {
+				key += "/" + label.GetValue()
+			}
+			values[key] = metric.GetGauge().GetValue() + metric.GetCounter().GetValue()
+		}
+	}
+	require.Equal(t, 5.5, values["balance_liabilities/NOK"])
+	require.Equal(t, float64(1), values["balance_non_positive_balances/NOK"])
+	require.Greater(t, values["balance_db_transactions_total/commit"], float64(0))
+	require.Greater(t, values["balance_db_transactions_total/rollback"], float64(0))
+	require.Greater(t, values["pgxpool_acquires_total"], float64(0))
+}
+
+// TestPgxConformance runs the conformance suite shared with the in-memory repository
+func TestPgxConformance(t *testing.T) {
+	skipWithoutDB(t)
+	repositorytest.Run(t, rps)
+}
+
+func TestPgxIdempotencyKeyLease(t *testing.T) {
+	skipWithoutDB(t)
+	key := "lease-" + uuid.NewString()
+	record := &model.IdempotencyRecord{Key: key, RequestHash: []byte{1}}
+	existing, err := rps.ReserveIdempotencyKey(context.Background(), record, time.Hour)
+	require.NoError(t, err)
+	require.Nil(t, existing)
+	existing, err = rps.ReserveIdempotencyKey(context.Background(), record, time.Hour)
+	require.NoError(t, err)
+	require.NotNil(t, existing)
+	require.Nil(t, existing.Response)
+
+	// a reservation older than the lease is taken over, a stored response never is
+	existing, err = rps.ReserveIdempotencyKey(context.Background(), &model.IdempotencyRecord{Key: key, RequestHash: []byte{2}}, 0)
+	require.NoError(t, err)
+	require.Nil(t, existing)
+	require.NoError(t, rps.CompleteIdempotencyKey(context.Background(), key, []byte("response")))
+	existing, err = rps.ReserveIdempotencyKey(context.Background(), record, 0)
+	require.NoError(t, err)
+	require.Equal(t, []byte{2}, existing.RequestHash)
+	require.Equal(t, []byte("response"), existing.Response)
+
+	deleted, err := rps.DeleteIdempotencyKeys(context.Background(), time.Hour)
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+	_, err = rps.DeleteIdempotencyKeys(context.Background(), 0)
+	require.NoError(t, err)
+	existing, err = rps.ReserveIdempotencyKey(context.Background(), record, time.Hour)
+	require.NoError(t, err)
+	require.Nil(t, existing)
+}
+
+// TestPgxMigrateLegacySchema tests that a balance table set up before the migrations existed is converted in place
+func TestPgxMigrateLegacySchema(t *testing.T) {
+	skipWithoutDB(t)
+	ctx := context.Background()
+	database := "legacy_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	_, err := rps.pool.Exec(ctx, "CREATE DATABASE "+database)
+	require.NoError(t, err)
+	cfg := rps.pool.Config()
+	cfg.ConnConfig.Database = database
+	pool, err := pgxpool.ConnectConfig(ctx, cfg)
+	require.NoError(t, err)
+	defer pool.Close()
+	funded, overdrawn := uuid.New(), uuid.New()
+	_, err = pool.Exec(ctx, `CREATE SCHEMA shares;
+		CREATE TABLE shares.balance (balance_id UUID PRIMARY KEY, profile_id UUID UNIQUE, balance DOUBLE PRECISION);`)
+	require.NoError(t, err)
+	_, err = pool.Exec(ctx, "INSERT INTO shares.balance VALUES ($1, $2, 0.1 + 0.2), ($3, $4, -5)", uuid.New(), funded, uuid.New(), overdrawn)
+	require.NoError(t, err)
+
+	migrator, err := migrations.NewMigrator(pool, "EUR")
+	require.NoError(t, err)
+	applied, err := migrator.Up(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, applied)
+	require.Equal(t, int64(3), applied[0].Version)
+
+	legacy := NewPsqlConnection(pool)
+	balance, err := legacy.GetUserByID(ctx, funded, "EUR")
+	require.NoError(t, err)
+	require.Equal(t, model.MustParseMoney("0.3"), balance.Balance)
+	balance, err = legacy.GetUserByID(ctx, overdrawn, "EUR")
+	require.NoError(t, err)
+	require.Equal(t, model.MustParseMoney("-5"), balance.Balance)
+	require.Equal(t, model.MustParseMoney("5"), balance.CreditLimit)
+	require.NoError(t, legacy.CreateBalance(ctx, &model.Balance{BalanceID: uuid.New(), ProfileID: funded, Currency: "USD"}))
+}
@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/eugenshima/balance/internal/model"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// PostgreSQL error codes translated by dbError
+const (
+	uniqueViolation      = "23505"
+	checkViolation       = "23514"
+	serializationFailure = "40001"
+	deadlockDetected     = "40P01"
+	adminShutdown        = "57P01"
+	cannotConnectNow     = "57P03"
+	tooManyConnections   = "53300"
+	connectionException  = "08"
+)
+
+// creditLimitConstraint is the check constraint of shares.balance that keeps balance - held from going below -credit_limit
+const creditLimitConstraint = "balance_within_credit_limit"
+
+// dbError translates driver errors into model errors, so that callers can tell
+// a missing row or a duplicate from a database that is down. Other errors are returned unchanged.
+func dbError(err error) error {
+	var pgErr *pgconn.PgError
+	var netErr net.Error
+	switch {
+	case err == nil, errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return err
+	case errors.Is(err, pgx.ErrNoRows):
+		return fmt.Errorf("%w: %v", model.ErrNotFound, err)
+	case errors.As(err, &pgErr):
+		switch {
+		case pgErr.Code == uniqueViolation:
+			return fmt.Errorf("%w: %v", model.ErrAlreadyExists, err)
+		case pgErr.Code == checkViolation && pgErr.ConstraintName == creditLimitConstraint:
+			return fmt.Errorf("%w: %v", model.ErrInsufficientFunds, err)
+		case pgErr.Code == serializationFailure, pgErr.Code == deadlockDetected:
+			return fmt.Errorf("%w: %v", model.ErrConflict, err)
+		case strings.HasPrefix(pgErr.Code, connectionException), pgErr.Code == adminShutdown,
+			pgErr.Code == cannotConnectNow, pgErr.Code == tooManyConnections:
+			return fmt.Errorf("%w: %v", model.ErrUnavailable, err)
+		}
+	case pgconn.Timeout(err), errors.As(err, &netErr):
+		return fmt.Errorf("%w: %v", model.ErrUnavailable, err)
+	}
+	return err
+}
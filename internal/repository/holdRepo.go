@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/eugenshima/balance/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+// CreateHold function reserves hold.Amount of the available balance and credit limit, a hold counts as a debit for the status check.
+// The held column of shares.balance is kept equal to the sum of active holds, so every check
+// against the available balance only needs the locked balance row.
+func (db *PsqlConnection) CreateHold(ctx context.Context, hold *model.Hold) error {
+	return db.inTx(ctx, pgx.TxOptions{IsoLevel: "read committed"}, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `UPDATE shares.balance SET held = held + $1::numeric, version = version + 1
+			WHERE profile_id = $2 AND currency = $3 AND status = ANY($4) AND balance - held + credit_limit - $1::numeric >= 0`,
+			hold.Amount, hold.ProfileID, string(hold.Currency), debitStatuses)
+		if err != nil {
+			return fmt.Errorf("exec: %w", dbError(err))
+		}
+		if tag.RowsAffected() == 0 {
+			return rejectedUpdateError(ctx, tx, hold.ProfileID, hold.Currency, true)
+		}
+		hold.Status = model.HoldActive
+		hold.Captured = model.Money{}
+		err = tx.QueryRow(ctx, `INSERT INTO shares.hold (hold_id, profile_id, currency, amount, captured, status, reference)
+			VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING created_at, updated_at`,
+			hold.HoldID, hold.ProfileID, string(hold.Currency), hold.Amount, hold.Captured, string(hold.Status), hold.Reference).
+			Scan(&hold.CreatedAt, &hold.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("QueryRow(): %w", dbError(err))
+		}
+		err = insertEvent(ctx, tx, model.EventHoldCreated, hold.ProfileID, hold)
+		if err != nil {
+			return fmt.Errorf("insertEvent: %w", err)
+		}
+		return nil
+	})
+}
+
+// CaptureHold function debits amount from the balance and closes the hold, the uncaptured rest is released.
+// A zero amount captures the whole hold. The capture is refused while the balance is frozen.
+func (db *PsqlConnection) CaptureHold(ctx context.Context, holdID uuid.UUID, amount model.Money) (*model.Hold, *model.Balance, error) {
+	var hold *model.Hold
+	var balance model.Balance
+	err := db.inTx(ctx, pgx.TxOptions{IsoLevel: "read committed"}, func(tx pgx.Tx) error {
+		var err error
+		hold, err = lockActiveHold(ctx, tx, holdID)
+		if err != nil {
+			return err
+		}
+		if amount.IsZero() {
+			amount = hold.Amount
+		}
+		err = hold.Currency.CheckAmount(amount)
+		if err != nil {
+			return err
+		}
+		if amount.Cmp(hold.Amount) > 0 {
+			return model.ErrCaptureExceedsHold
+		}
+		var status string
+		err = tx.QueryRow(ctx, `UPDATE shares.balance SET balance = balance - $1::numeric, held = held - $2::numeric, version = version + 1
+			WHERE profile_id = $3 AND currency = $4 AND status = ANY($5)
+			RETURNING balance_id, profile_id, currency, balance, balance - held, credit_limit, status, version`,
+			amount, hold.Amount, hold.ProfileID, string(hold.Currency), debitStatuses).
+			Scan(&balance.BalanceID, &balance.ProfileID, (*string)(&balance.Currency), &balance.Balance, &balance.Available, &balance.CreditLimit, &status, &balance.Version)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return rejectedUpdateError(ctx, tx, hold.ProfileID, hold.Currency, true)
+		}
+		if err != nil {
+			return fmt.Errorf("QueryRow(): %w", dbError(err))
+		}
+		balance.Status = model.BalanceStatus(status)
+		delta, err := amount.Neg()
+		if err != nil {
+			return fmt.Errorf("Neg(): %w", err)
+		}
+		err = insertLedgerEntry(ctx, tx, &model.LedgerEntry{
+			ProfileID: hold.ProfileID,
+			Currency:  hold.Currency,
+			Delta:     delta,
+			Balance:   balance.Balance,
+			Reason:    model.ReasonHoldCapture,
+			Reference: hold.Reference,
+		})
+		if err != nil {
+			return fmt.Errorf("insertLedgerEntry: %w", err)
+		}
+		hold.Captured = amount
+		hold.Status = model.HoldCaptured
+		return updateHold(ctx, tx, hold)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return hold, &balance, nil
+}
+
+// ReleaseHold function cancels an active hold and returns its amount to the available balance
+func (db *PsqlConnection) ReleaseHold(ctx context.Context, holdID uuid.UUID) (*model.Hold, error) {
+	var hold *model.Hold
+	err := db.inTx(ctx, pgx.TxOptions{IsoLevel: "read committed"}, func(tx pgx.Tx) error {
+		var err error
+		hold, err = lockActiveHold(ctx, tx, holdID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "UPDATE shares.balance SET held = held - $1::numeric, version = version + 1 WHERE profile_id = $2 AND currency = $3",
+			hold.Amount, hold.ProfileID, string(hold.Currency))
+		if err != nil {
+			return fmt.Errorf("exec: %w", dbError(err))
+		}
+		hold.Status = model.HoldReleased
+		return updateHold(ctx, tx, hold)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hold, nil
+}
+
+// lockActiveHold selects a hold FOR UPDATE and checks that it is still active.
+// Holds are always locked before their balance row, which keeps the lock order deadlock free.
+func lockActiveHold(ctx context.Context, tx pgx.Tx, holdID uuid.UUID) (*model.Hold, error) {
+	hold := &model.Hold{}
+	var status string
+	err := tx.QueryRow(ctx, `SELECT hold_id, profile_id, currency, amount, captured, status, reference, created_at, updated_at
+		FROM shares.hold WHERE hold_id = $1 FOR UPDATE`, holdID).
+		Scan(&hold.HoldID, &hold.ProfileID, (*string)(&hold.Currency), &hold.Amount, &hold.Captured, &status, &hold.Reference, &hold.CreatedAt, &hold.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("QueryRow(): %w", dbError(err))
+	}
+	hold.Status = model.HoldStatus(status)
+	if hold.Status != model.HoldActive {
+		return nil, model.ErrHoldNotActive
+	}
+	return hold, nil
+}
+
+// updateHold stores the status and captured amount of a hold
+func updateHold(ctx context.Context, tx pgx.Tx, hold *model.Hold) error {
+	err := tx.QueryRow(ctx, "UPDATE shares.hold SET status = $1, captured = $2, updated_at = now() WHERE hold_id = $3 RETURNING updated_at",
+		string(hold.Status), hold.Captured, hold.HoldID).Scan(&hold.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("QueryRow(): %w", dbError(err))
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eugenshima/balance/internal/model"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// ReserveIdempotencyKey function stores record.Key with its request hash if the key is new. A reservation without a
+// response that is older than lease is taken over, the request that made it is assumed to have died.
+// It returns nil when the key was reserved by this call, otherwise the record stored earlier.
+func (db *PsqlConnection) ReserveIdempotencyKey(ctx context.Context, record *model.IdempotencyRecord, lease time.Duration) (*model.IdempotencyRecord, error) {
+	tag, err := db.exec(ctx, `INSERT INTO shares.idempotency_key AS k (key, request_hash) VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE SET request_hash = excluded.request_hash, reserved_at = now()
+		WHERE k.response IS NULL AND k.reserved_at < now() - $3::interval`, record.Key, record.RequestHash, lease)
+	if err != nil {
+		return nil, fmt.Errorf("exec: %w", dbError(err))
+	}
+	if tag.RowsAffected() == 1 {
+		return nil, nil
+	}
+	existing := &model.IdempotencyRecord{}
+	err = db.queryRow(ctx, "SELECT key, request_hash, response, created_at, reserved_at FROM shares.idempotency_key WHERE key = $1", record.Key).
+		Scan(&existing.Key, &existing.RequestHash, &existing.Response, &existing.CreatedAt, &existing.ReservedAt)
+	if err != nil {
+		return nil, fmt.Errorf("QueryRow(): %w", dbError(err))
+	}
+	return existing, nil
+}
+
+// CompleteIdempotencyKey function stores the response of the request that reserved the key
+func (db *PsqlConnection) CompleteIdempotencyKey(ctx context.Context, key string, response []byte) error {
+	tag, err := db.exec(ctx, "UPDATE shares.idempotency_key SET response = $1 WHERE key = $2 AND response IS NULL", response, key)
+	if err != nil {
+		return fmt.Errorf("exec: %w", dbError(err))
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("exec: %w", dbError(pgx.ErrNoRows))
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey function removes a reservation without a response, so that a failed request can be retried
+func (db *PsqlConnection) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	_, err := db.exec(ctx, "DELETE FROM shares.idempotency_key WHERE key = $1 AND response IS NULL", key)
+	if err != nil {
+		return fmt.Errorf("exec: %w", dbError(err))
+	}
+	return nil
+}
+
+// DeleteIdempotencyKeys function deletes the keys created more than retention ago and returns how many were deleted
+func (db *PsqlConnection) DeleteIdempotencyKeys(ctx context.Context, retention time.Duration) (int64, error) {
+	tag, err := db.exec(ctx, "DELETE FROM shares.idempotency_key WHERE created_at < now() - $1::interval", retention)
+	if err != nil {
+		return 0, fmt.Errorf("exec: %w", dbError(err))
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,496 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/eugenshima/balance/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// balanceOrders compares two positions in every sort order, like the ORDER BY clauses of the PostgreSQL repository
+var balanceOrders = map[model.BalanceSort]func(a, b *model.BalanceCursor) int{
+	model.SortByProfileID: func(a, b *model.BalanceCursor) int {
+		return compareKeys(a.ProfileID, a.Currency, b.ProfileID, b.Currency)
+	},
+	model.SortByBalanceAsc: compareBalances,
+	model.SortByBalanceDesc: func(a, b *model.BalanceCursor) int {
+		return compareBalances(b, a)
+	},
+}
+
+// compareBalances orders positions by balance, ties are broken by profile ID and currency
+func compareBalances(a, b *model.BalanceCursor) int {
+	c := a.Balance.Cmp(b.Balance)
+	if c != 0 {
+		return c
+	}
+	return compareKeys(a.ProfileID, a.Currency, b.ProfileID, b.Currency)
+}
+
+// GetUserByID function returns the balance of a profile in currency
+func (r *Repository) GetUserByID(ctx context.Context, profileID uuid.UUID, currency model.Currency) (*model.Balance, error) {
+	err := r.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.mu.Unlock()
+	balance, err := r.find(profileID, currency)
+	if err != nil {
+		return nil, err
+	}
+	return balance.model()
+}
+
+// GetAll function returns one page of balances matching filter, ordered and continued after filter.After
+// like the keyset pagination of the PostgreSQL repository
+func (r *Repository) GetAll(ctx context.Context, filter model.BalanceFilter) ([]*model.Balance, error) {
+	compare, ok := balanceOrders[filter.Sort]
+	if !ok {
+		return nil, fmt.Errorf("sort %q: %w", filter.Sort, model.ErrInvalidSort)
+	}
+	err := checkLimit(filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	err = r.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.mu.Unlock()
+	results, err := r.sorted(filter, compare)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) > filter.Limit {
+		results = results[:filter.Limit]
+	}
+	return results, nil
+}
+
+// sorted returns every balance matching filter in the order of compare, filter.Limit is not applied
+func (r *Repository) sorted(filter model.BalanceFilter, compare func(a, b *model.BalanceCursor) int) ([]*model.Balance, error) {
+	profileIDs := make(map[uuid.UUID]bool, len(filter.ProfileIDs))
+	for _, profileID := range filter.ProfileIDs {
+		profileIDs[profileID] = true
+	}
+	var results []*model.Balance
+	for _, stored := range r.balances {
+		switch {
+		case len(profileIDs) > 0 && !profileIDs[stored.profileID],
+			filter.MinBalance != nil && stored.balance.Cmp(*filter.MinBalance) < 0,
+			filter.MaxBalance != nil && stored.balance.Cmp(*filter.MaxBalance) > 0,
+			filter.Currency != "" && stored.currency != filter.Currency,
+			filter.After != nil && compare(position(stored), filter.After) <= 0:
+			continue
+		}
+		balance, err := stored.model()
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, balance)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return compare(cursor(results[i]), cursor(results[j])) < 0
+	})
+	return results, nil
+}
+
+// position returns the sort position of a stored balance
+func position(b *row) *model.BalanceCursor {
+	return &model.BalanceCursor{ProfileID: b.profileID, Currency: b.currency, Balance: b.balance}
+}
+
+// cursor returns the sort position of a balance
+func cursor(b *model.Balance) *model.BalanceCursor {
+	return &model.BalanceCursor{ProfileID: b.ProfileID, Currency: b.Currency, Balance: b.Balance}
+}
+
+// StreamAll function calls fn for every balance in profile ID and currency order. The balances are copied before
+// the first call, so they all come from the same snapshot however long fn takes and fn may call the repository.
+// It stops at the first error of fn or when ctx is done.
+func (r *Repository) StreamAll(ctx context.Context, fn func(*model.Balance) error) error {
+	err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	balances, err := r.sorted(model.BalanceFilter{}, balanceOrders[model.SortByProfileID])
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, balance := range balances {
+		err = ctx.Err()
+		if err != nil {
+			return err
+		}
+		err = fn(balance)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateBalance function updates user's balance information.
+// A non-zero balance.Version must match the stored version, otherwise model.ErrVersionConflict is returned.
+// On success balance holds the stored row including the new version.
+func (r *Repository) UpdateBalance(ctx context.Context, balance *model.Balance) error {
+	err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer r.mu.Unlock()
+	stored, err := r.find(balance.ProfileID, balance.Currency)
+	if err != nil {
+		return err
+	}
+	if balance.Version != 0 && balance.Version != stored.version {
+		return model.ErrVersionConflict
+	}
+	decrease := balance.Balance.Cmp(stored.balance) < 0
+	if decrease {
+		err = stored.status.CheckDebit()
+	} else {
+		err = stored.status.CheckCredit()
+	}
+	if err != nil {
+		return err
+	}
+	// a decrease may only spend the available balance and the credit limit, funds reserved by holds stay untouched
+	if decrease {
+		err = checkCreditLimit(balance.Balance, stored.held, stored.creditLimit)
+		if err != nil {
+			return err
+		}
+	}
+	delta, err := balance.Balance.Sub(stored.balance)
+	if err != nil {
+		return fmt.Errorf("Sub(): %w", err)
+	}
+	stored.balance = balance.Balance
+	stored.version++
+	updated, err := stored.model()
+	if err != nil {
+		return err
+	}
+	r.save(stored)
+	*balance = *updated
+	r.appendEntry(&model.LedgerEntry{
+		ProfileID: balance.ProfileID,
+		Currency:  balance.Currency,
+		Delta:     delta,
+		Balance:   balance.Balance,
+		Reason:    model.ReasonAdjustment,
+	})
+	return nil
+}
+
+// CreateBalance function creates user's balance in balance.Currency, a profile may own one balance per currency.
+// A new balance has no credit limit, so its opening balance must not be negative.
+func (r *Repository) CreateBalance(ctx context.Context, balance *model.Balance) error {
+	if balance.Balance.IsNegative() {
+		return model.ErrInsufficientFunds
+	}
+	err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer r.mu.Unlock()
+	k := key{profileID: balance.ProfileID, currency: balance.Currency}
+	if _, ok := r.balances[k]; ok {
+		return fmt.Errorf("balance of %s in %s: %w", balance.ProfileID, balance.Currency, model.ErrAlreadyExists)
+	}
+	if _, ok := r.balanceIDs[balance.BalanceID]; ok {
+		return fmt.Errorf("balance %s: %w", balance.BalanceID, model.ErrAlreadyExists)
+	}
+	balance.Status = model.StatusActive
+	balance.Available, balance.CreditLimit = balance.Balance, model.Money{}
+	balance.Version = 1
+	r.balances[k] = &row{
+		balanceID: balance.BalanceID,
+		profileID: balance.ProfileID,
+		currency:  balance.Currency,
+		balance:   balance.Balance,
+		status:    balance.Status,
+		version:   balance.Version,
+	}
+	r.balanceIDs[balance.BalanceID] = struct{}{}
+	r.appendEntry(&model.LedgerEntry{
+		ProfileID: balance.ProfileID,
+		Currency:  balance.Currency,
+		Delta:     balance.Balance,
+		Balance:   balance.Balance,
+		Reason:    model.ReasonOpening,
+	})
+	return nil
+}
+
+// ChangeStatus function moves a balance to change.Status and records who made the change and why.
+// Balances are never deleted: closing requires a zero balance without active holds and appends a closing ledger entry.
+func (r *Repository) ChangeStatus(ctx context.Context, change *model.StatusChange) (*model.Balance, error) {
+	err := r.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.mu.Unlock()
+	stored, err := r.find(change.ProfileID, change.Currency)
+	if err != nil {
+		return nil, err
+	}
+	err = stored.status.CheckTransition(change.Status)
+	if err != nil {
+		return nil, err
+	}
+	if change.Status == model.StatusClosed && (!stored.balance.IsZero() || !stored.held.IsZero()) {
+		return nil, model.ErrBalanceNotZero
+	}
+	stored.status = change.Status
+	stored.version++
+	result, err := stored.model()
+	if err != nil {
+		return nil, err
+	}
+	r.save(stored)
+	change.ChangeID = uuid.New()
+	change.CreatedAt = time.Now()
+	recorded := *change
+	r.statusChanges = append(r.statusChanges, &recorded)
+	if change.Status == model.StatusClosed {
+		r.appendEntry(&model.LedgerEntry{
+			ProfileID: change.ProfileID,
+			Currency:  change.Currency,
+			Reason:    model.ReasonClosing,
+			Reference: change.Reason,
+		})
+	}
+	return result, nil
+}
+
+// SetCreditLimit function sets how far the available balance of a profile in currency may go below zero
+// and returns the resulting balance. A limit lower than the overdraft already in use fails with model.ErrCreditLimitInUse
+// and closed balances keep their limit.
+func (r *Repository) SetCreditLimit(ctx context.Context, profileID uuid.UUID, currency model.Currency, creditLimit model.Money) (*model.Balance, error) {
+	err := r.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.mu.Unlock()
+	stored, err := r.find(profileID, currency)
+	if err != nil {
+		return nil, err
+	}
+	if stored.status == model.StatusClosed {
+		return nil, model.ErrBalanceClosed
+	}
+	err = checkCreditLimit(stored.balance, stored.held, creditLimit)
+	if errors.Is(err, model.ErrInsufficientFunds) {
+		return nil, model.ErrCreditLimitInUse
+	}
+	if err != nil {
+		return nil, err
+	}
+	stored.creditLimit = creditLimit
+	stored.version++
+	result, err := stored.model()
+	if err != nil {
+		return nil, err
+	}
+	r.save(stored)
+	return result, nil
+}
+
+// Deposit function adds a positive amount to user's balance in currency and returns the resulting balance
+func (r *Repository) Deposit(ctx context.Context, profileID uuid.UUID, currency model.Currency, amount model.Money, reference string) (*model.Balance, error) {
+	return r.applyDelta(ctx, &model.LedgerEntry{
+		ProfileID: profileID,
+		Currency:  currency,
+		Delta:     amount,
+		Reason:    model.ReasonDeposit,
+		Reference: reference,
+	})
+}
+
+// Withdraw function subtracts a positive amount from user's balance in currency and returns the resulting balance,
+// model.ErrInsufficientFunds is returned if the available balance would go below its credit limit
+func (r *Repository) Withdraw(ctx context.Context, profileID uuid.UUID, currency model.Currency, amount model.Money, reference string) (*model.Balance, error) {
+	delta, err := amount.Neg()
+	if err != nil {
+		return nil, fmt.Errorf("Neg(): %w", err)
+	}
+	return r.applyDelta(ctx, &model.LedgerEntry{
+		ProfileID: profileID,
+		Currency:  currency,
+		Delta:     delta,
+		Reason:    model.ReasonWithdrawal,
+		Reference: reference,
+	})
+}
+
+// applyDelta adds entry.Delta to the balance and records the entry in the ledger.
+// A negative delta may only spend the available balance and the credit limit, the status must allow the direction.
+func (r *Repository) applyDelta(ctx context.Context, entry *model.LedgerEntry) (*model.Balance, error) {
+	err := r.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.mu.Unlock()
+	stored, err := r.find(entry.ProfileID, entry.Currency)
+	if err != nil {
+		return nil, err
+	}
+	debit := entry.Delta.IsNegative()
+	if debit {
+		err = stored.status.CheckDebit()
+	} else {
+		err = stored.status.CheckCredit()
+	}
+	if err != nil {
+		return nil, err
+	}
+	stored.balance, err = stored.balance.Add(entry.Delta)
+	if err != nil {
+		return nil, fmt.Errorf("Add(): %w", err)
+	}
+	if debit {
+		err = checkCreditLimit(stored.balance, stored.held, stored.creditLimit)
+		if err != nil {
+			return nil, err
+		}
+	}
+	stored.version++
+	result, err := stored.model()
+	if err != nil {
+		return nil, err
+	}
+	r.save(stored)
+	entry.Balance = stored.balance
+	r.appendEntry(entry)
+	return result, nil
+}
+
+// ListTransactions function returns ledger entries of a profile ordered by sequence
+func (r *Repository) ListTransactions(ctx context.Context, filter model.LedgerFilter) ([]*model.LedgerEntry, error) {
+	err := checkLimit(filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	err = r.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.mu.Unlock()
+	var results []*model.LedgerEntry
+	for _, entry := range r.ledger {
+		if len(results) >= filter.Limit {
+			break
+		}
+		switch {
+		case entry.ProfileID != filter.ProfileID,
+			entry.Sequence <= filter.AfterSequence,
+			!filter.From.IsZero() && entry.CreatedAt.Before(filter.From),
+			!filter.To.IsZero() && !entry.CreatedAt.Before(filter.To),
+			filter.Currency != "" && entry.Currency != filter.Currency:
+			continue
+		}
+		copied := *entry
+		results = append(results, &copied)
+	}
+	return results, nil
+}
+
+// LatestSequence function returns the sequence of the newest ledger entry of a profile in currency, 0 if it has none
+func (r *Repository) LatestSequence(ctx context.Context, profileID uuid.UUID, currency model.Currency) (int64, error) {
+	err := r.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer r.mu.Unlock()
+	for i := len(r.ledger) - 1; i >= 0; i-- {
+		if r.ledger[i].ProfileID == profileID && r.ledger[i].Currency == currency {
+			return r.ledger[i].Sequence, nil
+		}
+	}
+	return 0, nil
+}
+
+// Transfer function moves funds between the transfer.Currency balances of two profiles at once
+// and returns both resulting balances
+func (r *Repository) Transfer(ctx context.Context, transfer *model.Transfer) (*model.Balance, *model.Balance, error) {
+	err := r.lock(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.mu.Unlock()
+	from, err := r.find(transfer.FromProfileID, transfer.Currency)
+	if err != nil {
+		return nil, nil, err
+	}
+	// a transfer to the same profile changes one balance, as it does with a single locked row in PostgreSQL
+	to := from
+	if transfer.ToProfileID != transfer.FromProfileID {
+		to, err = r.find(transfer.ToProfileID, transfer.Currency)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	err = from.status.CheckDebit()
+	if err != nil {
+		return nil, nil, err
+	}
+	err = to.status.CheckCredit()
+	if err != nil {
+		return nil, nil, err
+	}
+	from.balance, err = from.balance.Sub(transfer.Amount)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Sub(): %w", err)
+	}
+	err = checkCreditLimit(from.balance, from.held, from.creditLimit)
+	if err != nil {
+		return nil, nil, err
+	}
+	to.balance, err = to.balance.Add(transfer.Amount)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Add(): %w", err)
+	}
+	debit, err := transfer.Amount.Neg()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Neg(): %w", err)
+	}
+	from.version++
+	to.version++
+	fromResult, err := from.model()
+	if err != nil {
+		return nil, nil, err
+	}
+	toResult, err := to.model()
+	if err != nil {
+		return nil, nil, err
+	}
+	legs := []struct {
+		balance *row
+		delta   model.Money
+		reason  model.Reason
+	}{
+		{from, debit, model.ReasonTransferOut},
+		{to, transfer.Amount, model.ReasonTransferIn},
+	}
+	for _, leg := range legs {
+		r.save(leg.balance)
+		r.appendEntry(&model.LedgerEntry{
+			ProfileID:  leg.balance.profileID,
+			Currency:   transfer.Currency,
+			Delta:      leg.delta,
+			Balance:    leg.balance.balance,
+			Reason:     leg.reason,
+			Reference:  transfer.Reference,
+			TransferID: transfer.TransferID,
+		})
+	}
+	return fromResult, toResult, nil
+}
@@ -0,0 +1,156 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eugenshima/balance/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// CreateHold function reserves hold.Amount of the available balance and credit limit, a hold counts as a debit for the status check
+func (r *Repository) CreateHold(ctx context.Context, hold *model.Hold) error {
+	err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer r.mu.Unlock()
+	stored, err := r.find(hold.ProfileID, hold.Currency)
+	if err != nil {
+		return err
+	}
+	err = stored.status.CheckDebit()
+	if err != nil {
+		return err
+	}
+	stored.held, err = stored.held.Add(hold.Amount)
+	if err != nil {
+		return fmt.Errorf("Add(): %w", err)
+	}
+	err = checkCreditLimit(stored.balance, stored.held, stored.creditLimit)
+	if err != nil {
+		return err
+	}
+	if _, ok := r.holds[hold.HoldID]; ok {
+		return fmt.Errorf("hold %s: %w", hold.HoldID, model.ErrAlreadyExists)
+	}
+	stored.version++
+	r.save(stored)
+	hold.Status = model.HoldActive
+	hold.Captured = model.Money{}
+	hold.CreatedAt = time.Now()
+	hold.UpdatedAt = hold.CreatedAt
+	saved := *hold
+	r.holds[hold.HoldID] = &saved
+	return nil
+}
+
+// CaptureHold function debits amount from the balance and closes the hold, the uncaptured rest is released.
+// A zero amount captures the whole hold. The capture is refused while the balance is frozen.
+func (r *Repository) CaptureHold(ctx context.Context, holdID uuid.UUID, amount model.Money) (*model.Hold, *model.Balance, error) {
+	err := r.lock(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.mu.Unlock()
+	hold, err := r.findActiveHold(holdID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if amount.IsZero() {
+		amount = hold.Amount
+	}
+	err = hold.Currency.CheckAmount(amount)
+	if err != nil {
+		return nil, nil, err
+	}
+	if amount.Cmp(hold.Amount) > 0 {
+		return nil, nil, model.ErrCaptureExceedsHold
+	}
+	stored, err := r.find(hold.ProfileID, hold.Currency)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = stored.status.CheckDebit()
+	if err != nil {
+		return nil, nil, err
+	}
+	stored.balance, err = stored.balance.Sub(amount)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Sub(): %w", err)
+	}
+	stored.held, err = stored.held.Sub(hold.Amount)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Sub(): %w", err)
+	}
+	delta, err := amount.Neg()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Neg(): %w", err)
+	}
+	stored.version++
+	balance, err := stored.model()
+	if err != nil {
+		return nil, nil, err
+	}
+	r.save(stored)
+	r.appendEntry(&model.LedgerEntry{
+		ProfileID: hold.ProfileID,
+		Currency:  hold.Currency,
+		Delta:     delta,
+		Balance:   stored.balance,
+		Reason:    model.ReasonHoldCapture,
+		Reference: hold.Reference,
+	})
+	hold.Captured = amount
+	hold.Status = model.HoldCaptured
+	r.saveHold(hold)
+	return hold, balance, nil
+}
+
+// ReleaseHold function cancels an active hold and returns its amount to the available balance
+func (r *Repository) ReleaseHold(ctx context.Context, holdID uuid.UUID) (*model.Hold, error) {
+	err := r.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.mu.Unlock()
+	hold, err := r.findActiveHold(holdID)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := r.find(hold.ProfileID, hold.Currency)
+	if err != nil {
+		return nil, err
+	}
+	stored.held, err = stored.held.Sub(hold.Amount)
+	if err != nil {
+		return nil, fmt.Errorf("Sub(): %w", err)
+	}
+	stored.version++
+	r.save(stored)
+	hold.Status = model.HoldReleased
+	r.saveHold(hold)
+	return hold, nil
+}
+
+// findActiveHold returns a copy of a hold and checks that it is still active
+func (r *Repository) findActiveHold(holdID uuid.UUID) (*model.Hold, error) {
+	stored, ok := r.holds[holdID]
+	if !ok {
+		return nil, fmt.Errorf("hold %s: %w", holdID, model.ErrNotFound)
+	}
+	if stored.Status != model.HoldActive {
+		return nil, model.ErrHoldNotActive
+	}
+	hold := *stored
+	return &hold, nil
+}
+
+// saveHold stores the status and captured amount of a hold found with findActiveHold
+func (r *Repository) saveHold(hold *model.Hold) {
+	hold.UpdatedAt = time.Now()
+	saved := *hold
+	r.holds[hold.HoldID] = &saved
+}
@@ -0,0 +1,232 @@
+// Package memory keeps balances in memory with the semantics of the PostgreSQL repository,
+// it backs tests and local development that run without a database
+package memory
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eugenshima/balance/internal/model"
+	"github.com/eugenshima/balance/internal/service"
+
+	"github.com/google/uuid"
+)
+
+// key identifies a balance like the balance_profile_currency_key constraint of shares.balance
+type key struct {
+	profileID uuid.UUID
+	currency  model.Currency
+}
+
+// row is a stored balance, held is the sum of its active holds
+type row struct {
+	balanceID   uuid.UUID
+	profileID   uuid.UUID
+	currency    model.Currency
+	balance     model.Money
+	held        model.Money
+	creditLimit model.Money
+	status      model.BalanceStatus
+	version     int64
+}
+
+// Repository is a concurrency-safe in-memory BalanceRepository and BalanceNotifier.
+// Every method runs under one lock and checks everything before it changes anything,
+// so a failed call leaves no trace, like a rolled back transaction.
+// Domain events are not recorded, there is no outbox to publish them from.
+type Repository struct {
+	mu            sync.Mutex
+	balances      map[key]*row
+	balanceIDs    map[uuid.UUID]struct{}
+	holds         map[uuid.UUID]*model.Hold
+	ledger        []*model.LedgerEntry
+	statusChanges []*model.StatusChange
+	subscribers   map[uuid.UUID]map[chan struct{}]struct{}
+}
+
+// NewRepository constructor for an empty Repository
+func NewRepository() *Repository {
+	return &Repository{
+		balances:    map[key]*row{},
+		balanceIDs:  map[uuid.UUID]struct{}{},
+		holds:       map[uuid.UUID]*model.Hold{},
+		subscribers: map[uuid.UUID]map[chan struct{}]struct{}{},
+	}
+}
+
+// Subscribe returns a channel that receives a value after balance changes of profileID and a function that ends the subscription.
+// Changes made while a value is pending are coalesced into it, as with repository.BalanceWatcher.
+func (r *Repository) Subscribe(profileID uuid.UUID) (<-chan struct{}, func()) {
+	changed := make(chan struct{}, 1)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.subscribers[profileID] == nil {
+		r.subscribers[profileID] = map[chan struct{}]struct{}{}
+	}
+	r.subscribers[profileID][changed] = struct{}{}
+	return changed, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.subscribers[profileID], changed)
+		if len(r.subscribers[profileID]) == 0 {
+			delete(r.subscribers, profileID)
+		}
+	}
+}
+
+// StatusChanges returns the recorded status changes of a profile in the order they were made
+func (r *Repository) StatusChanges(profileID uuid.UUID) []*model.StatusChange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var changes []*model.StatusChange
+	for _, change := range r.statusChanges {
+		if change.ProfileID == profileID {
+			copied := *change
+			changes = append(changes, &copied)
+		}
+	}
+	return changes
+}
+
+// lock takes the repository lock unless ctx is already done, the caller unlocks
+func (r *Repository) lock(ctx context.Context) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	return nil
+}
+
+// find returns a copy of the balance of profileID in currency, changes to it are kept with save
+func (r *Repository) find(profileID uuid.UUID, currency model.Currency) (*row, error) {
+	stored, ok := r.balances[key{profileID: profileID, currency: currency}]
+	if !ok {
+		return nil, fmt.Errorf("balance of %s in %s: %w", profileID, currency, model.ErrNotFound)
+	}
+	copied := *stored
+	return &copied, nil
+}
+
+// save keeps the changes of a balance found with find
+func (r *Repository) save(balance *row) {
+	stored := *balance
+	r.balances[key{profileID: balance.profileID, currency: balance.currency}] = &stored
+}
+
+// InTx function runs fn with a copy of the repository and keeps the changes fn made only if it returns nil.
+// Other calls wait until fn returns, so a unit of work is serializable whatever isolation is asked for
+// and fn must only use the repository it is passed. Subscribers are signalled once the changes are kept.
+func (r *Repository) InTx(ctx context.Context, isolation model.IsolationLevel, fn func(rps service.BalanceRepository) error) error {
+	err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer r.mu.Unlock()
+	tx := r.clone()
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	for _, entry := range tx.ledger[len(r.ledger):] {
+		r.signal(entry.ProfileID)
+	}
+	r.balances, r.balanceIDs, r.holds, r.ledger, r.statusChanges = tx.balances, tx.balanceIDs, tx.holds, tx.ledger, tx.statusChanges
+	return nil
+}
+
+// clone returns a repository with a copy of the data of r and no subscribers.
+// Stored balances, holds and entries are replaced and never changed in place, so copying the maps is enough.
+func (r *Repository) clone() *Repository {
+	tx := NewRepository()
+	for k, balance := range r.balances {
+		tx.balances[k] = balance
+	}
+	for balanceID := range r.balanceIDs {
+		tx.balanceIDs[balanceID] = struct{}{}
+	}
+	for holdID, hold := range r.holds {
+		tx.holds[holdID] = hold
+	}
+	tx.ledger = append([]*model.LedgerEntry(nil), r.ledger...)
+	tx.statusChanges = append([]*model.StatusChange(nil), r.statusChanges...)
+	return tx
+}
+
+// appendEntry adds entry to the ledger, fills its ID, sequence and creation time and wakes up the subscribers of its profile
+func (r *Repository) appendEntry(entry *model.LedgerEntry) {
+	entry.EntryID = uuid.New()
+	entry.Sequence = int64(len(r.ledger)) + 1
+	entry.CreatedAt = time.Now()
+	stored := *entry
+	r.ledger = append(r.ledger, &stored)
+	r.signal(entry.ProfileID)
+}
+
+// signal wakes up the subscribers of profileID without blocking on the ones that already have a pending value
+func (r *Repository) signal(profileID uuid.UUID) {
+	for changed := range r.subscribers[profileID] {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// model returns the balance as the repository returns it
+func (b *row) model() (*model.Balance, error) {
+	available, err := b.balance.Sub(b.held)
+	if err != nil {
+		return nil, fmt.Errorf("Sub(): %w", err)
+	}
+	return &model.Balance{
+		BalanceID:   b.balanceID,
+		ProfileID:   b.profileID,
+		Currency:    b.currency,
+		Balance:     b.balance,
+		Available:   available,
+		CreditLimit: b.creditLimit,
+		Status:      b.status,
+		Version:     b.version,
+	}, nil
+}
+
+// checkCreditLimit returns model.ErrInsufficientFunds if balance minus held is below -creditLimit,
+// it mirrors the balance_within_credit_limit check constraint of shares.balance
+func checkCreditLimit(balance, held, creditLimit model.Money) error {
+	available, err := balance.Sub(held)
+	if err != nil {
+		return fmt.Errorf("Sub(): %w", err)
+	}
+	available, err = available.Add(creditLimit)
+	if err != nil {
+		return fmt.Errorf("Add(): %w", err)
+	}
+	if available.IsNegative() {
+		return model.ErrInsufficientFunds
+	}
+	return nil
+}
+
+// checkLimit rejects a negative limit like PostgreSQL rejects a negative LIMIT clause
+func checkLimit(limit int) error {
+	if limit < 0 {
+		return fmt.Errorf("limit %d: %w", limit, model.ErrInvalidPageSize)
+	}
+	return nil
+}
+
+// compareKeys orders balances by profile ID and currency like PostgreSQL orders (profile_id, currency)
+func compareKeys(profileID uuid.UUID, currency model.Currency, otherProfileID uuid.UUID, otherCurrency model.Currency) int {
+	c := bytes.Compare(profileID[:], otherProfileID[:])
+	if c != 0 {
+		return c
+	}
+	return strings.Compare(string(currency), string(otherCurrency))
+}
@@ -0,0 +1,91 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/balance/internal/model"
+	"github.com/eugenshima/balance/internal/repository/repositorytest"
+	"github.com/eugenshima/balance/internal/service"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	_ service.BalanceRepository = (*Repository)(nil)
+	_ service.BalanceNotifier   = (*Repository)(nil)
+)
+
+// TestConformance runs the conformance suite shared with the PostgreSQL repository
+func TestConformance(t *testing.T) {
+	repositorytest.Run(t, NewRepository())
+}
+
+// TestSubscribe tests that subscribers are signalled by ledger entries of their profile only
+func TestSubscribe(t *testing.T) {
+	repo := NewRepository()
+	balance := &model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: "USD", Balance: model.MustParseMoney("10")}
+	require.NoError(t, repo.CreateBalance(context.Background(), balance))
+	other := &model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: "USD"}
+	require.NoError(t, repo.CreateBalance(context.Background(), other))
+	changed, unsubscribe := repo.Subscribe(balance.ProfileID)
+
+	_, err := repo.Deposit(context.Background(), other.ProfileID, "USD", model.MustParseMoney("1"), "")
+	require.NoError(t, err)
+	_, err = repo.Withdraw(context.Background(), balance.ProfileID, "USD", model.MustParseMoney("11"), "")
+	require.ErrorIs(t, err, model.ErrInsufficientFunds)
+	select {
+	case <-changed:
+		t.Fatal("signalled without a change of the profile")
+	default:
+	}
+
+	_, err = repo.Deposit(context.Background(), balance.ProfileID, "USD", model.MustParseMoney("1"), "")
+	require.NoError(t, err)
+	_, err = repo.Deposit(context.Background(), balance.ProfileID, "USD", model.MustParseMoney("1"), "")
+	require.NoError(t, err)
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("not signalled")
+	}
+	select {
+	case <-changed:
+		t.Fatal("changes were not coalesced")
+	default:
+	}
+
+	unsubscribe()
+	_, err = repo.Deposit(context.Background(), balance.ProfileID, "USD", model.MustParseMoney("1"), "")
+	require.NoError(t, err)
+	require.Empty(t, changed)
+}
+
+// TestStatusChanges tests that status changes are recorded and failed ones are not
+func TestStatusChanges(t *testing.T) {
+	repo := NewRepository()
+	balance := &model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: "USD"}
+	require.NoError(t, repo.CreateBalance(context.Background(), balance))
+	change := &model.StatusChange{ProfileID: balance.ProfileID, Currency: "USD", Status: model.StatusDebitFrozen, Actor: "compliance", Reason: "review"}
+	_, err := repo.ChangeStatus(context.Background(), change)
+	require.NoError(t, err)
+	_, err = repo.ChangeStatus(context.Background(), &model.StatusChange{ProfileID: balance.ProfileID, Currency: "USD", Status: model.StatusClosed, Actor: "compliance"})
+	require.ErrorIs(t, err, model.ErrBalanceFrozen)
+
+	require.Equal(t, []*model.StatusChange{change}, repo.StatusChanges(balance.ProfileID))
+	require.Empty(t, repo.StatusChanges(uuid.New()))
+}
+
+// TestCanceledContext tests that calls fail without touching the balances once ctx is done
+func TestCanceledContext(t *testing.T) {
+	repo := NewRepository()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := repo.CreateBalance(ctx, &model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: "USD"})
+	require.ErrorIs(t, err, context.Canceled)
+	balances, err := repo.GetAll(context.Background(), model.BalanceFilter{Sort: model.SortByProfileID, Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, balances)
+}
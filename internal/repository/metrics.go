@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Outcomes of the transactions counted in balance_db_transactions_total
+const (
+	txCommitted    = "commit"
+	txCommitFailed = "commit_failed"
+	txRolledBack   = "rollback"
+)
+
+// collectTimeout bounds the time the balance queries may take on a scrape
+const collectTimeout = 5 * time.Second
+
+// Descriptors of the balance metrics PsqlConnection collects on every scrape
+var (
+	liabilitiesDesc = prometheus.NewDesc("balance_liabilities", "Sum of the positive balances by currency.", []string{"currency"}, nil)
+	nonPositiveDesc = prometheus.NewDesc("balance_non_positive_balances", "Open balances at zero or below by currency.", []string{"currency"}, nil)
+)
+
+// countedTx is a pgx.Tx that counts how it ended
+type countedTx struct {
+	pgx.Tx
+	transactions *prometheus.CounterVec
+}
+
+// Commit commits the transaction and counts whether it succeeded
+func (tx *countedTx) Commit(ctx context.Context) error {
+	err := tx.Tx.Commit(ctx)
+	if err != nil {
+		tx.transactions.WithLabelValues(txCommitFailed).Inc()
+		return err
+	}
+	tx.transactions.WithLabelValues(txCommitted).Inc()
+	return nil
+}
+
+// Rollback rolls the transaction back and counts it
+func (tx *countedTx) Rollback(ctx context.Context) error {
+	tx.transactions.WithLabelValues(txRolledBack).Inc()
+	return tx.Tx.Rollback(ctx)
+}
+
+// Describe sends the descriptors of the transaction counters and the balance metrics
+func (db *PsqlConnection) Describe(ch chan<- *prometheus.Desc) {
+	db.transactions.Describe(ch)
+	ch <- liabilitiesDesc
+	ch <- nonPositiveDesc
+}
+
+// Collect sends the transaction counters and, per currency, the total liabilities and the number of open balances
+// at zero or below. Liabilities are the sum of the positive balances, which the service owes its profiles.
+// A failed query is reported as an invalid metric, the transaction counters are sent anyway.
+func (db *PsqlConnection) Collect(ch chan<- prometheus.Metric) {
+	db.transactions.Collect(ch)
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+	err := db.collectBalances(ctx, ch)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(liabilitiesDesc, err)
+	}
+}
+
+// collectBalances sends the liabilities and non-positive balances of every currency
+func (db *PsqlConnection) collectBalances(ctx context.Context, ch chan<- prometheus.Metric) error {
+	rows, err := db.pool.Query(ctx, `SELECT currency,
+			COALESCE(sum(balance) FILTER (WHERE balance > 0), 0)::float8,
+			count(*) FILTER (WHERE balance <= 0 AND status <> $1)
+		FROM shares.balance GROUP BY currency ORDER BY currency`, "closed")
+	if err != nil {
+		return fmt.Errorf("Query: %w", dbError(err))
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var currency string
+		var total float64
+		var count int64
+		err = rows.Scan(&currency, &total, &count)
+		if err != nil {
+			return fmt.Errorf("Scan: %w", dbError(err))
+		}
+		ch <- prometheus.MustNewConstMetric(liabilitiesDesc, prometheus.GaugeValue, total, currency)
+		ch <- prometheus.MustNewConstMetric(nonPositiveDesc, prometheus.GaugeValue, float64(count), currency)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows.Err: %w", dbError(err))
+	}
+	return nil
+}
+
+// PoolCollector exposes the statistics of a connection pool
+type PoolCollector struct {
+	pool *pgxpool.Pool
+}
+
+// NewPoolCollector constructor for PoolCollector
+func NewPoolCollector(pool *pgxpool.Pool) *PoolCollector {
+	return &PoolCollector{pool: pool}
+}
+
+// poolMetric describes a statistic of the pool and reads it from a pgxpool.Stat
+type poolMetric struct {
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	value     func(stat *pgxpool.Stat) float64
+}
+
+// poolMetrics are the statistics PoolCollector exposes
+var poolMetrics = []poolMetric{
+	poolGauge("pgxpool_acquired_connections", "Connections currently in use.", func(s *pgxpool.Stat) float64 { return float64(s.AcquiredConns()) }),
+	poolGauge("pgxpool_idle_connections", "Connections currently idle.", func(s *pgxpool.Stat) float64 { return float64(s.IdleConns()) }),
+	poolGauge("pgxpool_constructing_connections", "Connections currently being established.", func(s *pgxpool.Stat) float64 { return float64(s.ConstructingConns()) }),
+	poolGauge("pgxpool_total_connections", "Connections currently open.", func(s *pgxpool.Stat) float64 { return float64(s.TotalConns()) }),
+	poolGauge("pgxpool_max_connections", "Maximum size of the pool.", func(s *pgxpool.Stat) float64 { return float64(s.MaxConns()) }),
+	poolCounter("pgxpool_acquires_total", "Connections acquired from the pool.", func(s *pgxpool.Stat) float64 { return float64(s.AcquireCount()) }),
+	poolCounter("pgxpool_empty_acquires_total", "Acquires that had to wait because no idle connection was available.", func(s *pgxpool.Stat) float64 { return float64(s.EmptyAcquireCount()) }),
+	poolCounter("pgxpool_canceled_acquires_total", "Acquires canceled by their context.", func(s *pgxpool.Stat) float64 { return float64(s.CanceledAcquireCount()) }),
+	poolCounter("pgxpool_acquire_wait_seconds_total", "Total time spent acquiring connections.", func(s *pgxpool.Stat) float64 { return s.AcquireDuration().Seconds() }),
+}
+
+func poolGauge(name, help string, value func(stat *pgxpool.Stat) float64) poolMetric {
+	return poolMetric{desc: prometheus.NewDesc(name, help, nil, nil), valueType: prometheus.GaugeValue, value: value}
+}
+
+func poolCounter(name, help string, value func(stat *pgxpool.Stat) float64) poolMetric {
+	return poolMetric{desc: prometheus.NewDesc(name, help, nil, nil), valueType: prometheus.CounterValue, value: value}
+}
+
+// Describe sends the descriptors of the pool statistics
+func (c *PoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, metric := range poolMetrics {
+		ch <- metric.desc
+	}
+}
+
+// Collect sends the current connections and the acquire counters of the pool
+func (c *PoolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+	for _, metric := range poolMetrics {
+		ch <- prometheus.MustNewConstMetric(metric.desc, metric.valueType, metric.value(stat))
+	}
+}
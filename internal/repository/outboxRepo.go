@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eugenshima/balance/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+// outboxLockID is the advisory lock key held while publishing the outbox, so only one relay publishes at a time
+const outboxLockID = 7_462_015
+
+// insertEvent writes an event of eventType about profileID to the outbox inside tx,
+// it is published only if tx commits. Operations insert events after taking their row locks,
+// so the events of a profile get sequences in the order its changes commit.
+func insertEvent(ctx context.Context, tx pgx.Tx, eventType model.EventType, profileID uuid.UUID, payload interface{}) error {
+	event, err := model.NewEvent(eventType, profileID, payload)
+	if err != nil {
+		return fmt.Errorf("NewEvent: %w", err)
+	}
+	_, err = tx.Exec(ctx, "INSERT INTO shares.outbox (event_id, event_type, profile_id, payload) VALUES ($1, $2, $3, $4)",
+		event.EventID, string(event.Type), event.ProfileID, []byte(event.Payload))
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", dbError(err))
+	}
+	return nil
+}
+
+// PublishOutbox function passes up to limit unpublished events in sequence order to publish and marks them published
+// once it returns nil, it returns the number of events published. While another relay holds the outbox lock nothing
+// is published and 0 is returned. Events stay in the outbox when publish fails or the transaction cannot commit
+// and are passed again on the next call, so every event is published at least once.
+func (db *PsqlConnection) PublishOutbox(ctx context.Context, limit int, publish func(context.Context, []*model.Event) error) (int, error) {
+	published := 0
+	err := db.inTx(ctx, pgx.TxOptions{IsoLevel: "read committed"}, func(tx pgx.Tx) error {
+		var locked bool
+		err := tx.QueryRow(ctx, "SELECT pg_try_advisory_xact_lock($1)", outboxLockID).Scan(&locked)
+		if err != nil {
+			return fmt.Errorf("QueryRow(): %w", dbError(err))
+		}
+		if !locked {
+			return nil
+		}
+		events, err := unpublishedEvents(ctx, tx, limit)
+		if err != nil || len(events) == 0 {
+			return err
+		}
+		err = publish(ctx, events)
+		if err != nil {
+			return err
+		}
+		sequences := make([]int64, 0, len(events))
+		for _, event := range events {
+			sequences = append(sequences, event.Sequence)
+		}
+		_, err = tx.Exec(ctx, "UPDATE shares.outbox SET published_at = now() WHERE sequence = ANY($1)", sequences)
+		if err != nil {
+			return fmt.Errorf("Exec(): %w", dbError(err))
+		}
+		published = len(events)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return published, nil
+}
+
+// unpublishedEvents returns up to limit unpublished events of the outbox in sequence order. Sequences are drawn
+// when an event is inserted, not when it commits, so a transaction still in flight may hold a lower sequence than
+// an event already visible. Only events written by transactions older than every running one are returned,
+// and none behind a visible event of a younger transaction, so an event of a profile is never published
+// after a later one of the same profile.
+func unpublishedEvents(ctx context.Context, tx pgx.Tx, limit int) ([]*model.Event, error) {
+	rows, err := tx.Query(ctx, `SELECT event_id, sequence, event_type, profile_id, payload, created_at FROM shares.outbox o
+		WHERE published_at IS NULL AND NOT EXISTS (SELECT 1 FROM shares.outbox y WHERE y.published_at IS NULL
+			AND y.sequence <= o.sequence AND y.txid >= pg_snapshot_xmin(pg_current_snapshot()))
+		ORDER BY sequence LIMIT $1`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Query(): %w", dbError(err))
+	}
+	defer rows.Close()
+	var events []*model.Event
+	for rows.Next() {
+		event := &model.Event{}
+		var eventType string
+		err = rows.Scan(&event.EventID, &event.Sequence, &eventType, &event.ProfileID, (*[]byte)(&event.Payload), &event.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("Scan(): %w", dbError(err))
+		}
+		event.Type = model.EventType(eventType)
+		events = append(events, event)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Rows(): %w", dbError(err))
+	}
+	return events, nil
+}
+
+// DeleteOutboxEvents function deletes the events published more than retention ago and returns how many were deleted
+func (db *PsqlConnection) DeleteOutboxEvents(ctx context.Context, retention time.Duration) (int64, error) {
+	tag, err := db.exec(ctx, "DELETE FROM shares.outbox WHERE published_at < now() - $1::interval", retention)
+	if err != nil {
+		return 0, fmt.Errorf("exec: %w", dbError(err))
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,659 @@
+// Package repositorytest holds the conformance suite every BalanceRepository implementation has to pass,
+// it runs against the PostgreSQL repository and the in-memory one so that they keep the same semantics
+package repositorytest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/balance/internal/model"
+	"github.com/eugenshima/balance/internal/service"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// currency is the currency of the balances the suite creates
+const currency = model.Currency("USD")
+
+// Run runs the conformance suite against repo. Every case works on balances of new profiles,
+// so repo may be shared with other tests and may already hold data.
+func Run(t *testing.T, repo service.BalanceRepository) {
+	cases := []struct {
+		name string
+		test func(t *testing.T, repo service.BalanceRepository)
+	}{
+		{"CreateAndGet", testCreateAndGet},
+		{"DuplicateBalance", testDuplicateBalance},
+		{"UpdateBalance", testUpdateBalance},
+		{"DepositWithdraw", testDepositWithdraw},
+		{"Statuses", testStatuses},
+		{"CreditLimit", testCreditLimit},
+		{"Holds", testHolds},
+		{"Transfer", testTransfer},
+		{"GetAll", testGetAll},
+		{"StreamAll", testStreamAll},
+		{"ListTransactions", testListTransactions},
+		{"ConcurrentDeposits", testConcurrentDeposits},
+		{"OppositeTransfers", testOppositeTransfers},
+		{"UnitOfWork", testUnitOfWork},
+		{"UnitOfWorkFailedOperation", testUnitOfWorkFailedOperation},
+		{"UnitOfWorkFailedQuery", testUnitOfWorkFailedQuery},
+		{"NestedUnitOfWork", testNestedUnitOfWork},
+		{"UnitOfWorkPanic", testUnitOfWorkPanic},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			c.test(t, repo)
+		})
+	}
+}
+
+// create creates a balance of a new profile in currency with amount
+func create(t *testing.T, repo service.BalanceRepository, amount string) *model.Balance {
+	t.Helper()
+	balance := &model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: currency, Balance: model.MustParseMoney(amount)}
+	require.NoError(t, repo.CreateBalance(context.Background(), balance))
+	return balance
+}
+
+// get returns the balance of profileID in currency
+func get(t *testing.T, repo service.BalanceRepository, profileID uuid.UUID) *model.Balance {
+	t.Helper()
+	balance, err := repo.GetUserByID(context.Background(), profileID, currency)
+	require.NoError(t, err)
+	return balance
+}
+
+// requireAmounts checks the balance and the available balance of profileID
+func requireAmounts(t *testing.T, repo service.BalanceRepository, profileID uuid.UUID, balance, available string) {
+	t.Helper()
+	result := get(t, repo, profileID)
+	require.Zero(t, result.Balance.Cmp(model.MustParseMoney(balance)), "balance %s, want %s", result.Balance, balance)
+	require.Zero(t, result.Available.Cmp(model.MustParseMoney(available)), "available %s, want %s", result.Available, available)
+}
+
+// entries returns the ledger entries of profileID
+func entries(t *testing.T, repo service.BalanceRepository, profileID uuid.UUID) []*model.LedgerEntry {
+	t.Helper()
+	result, err := repo.ListTransactions(context.Background(), model.LedgerFilter{ProfileID: profileID, Limit: 100})
+	require.NoError(t, err)
+	return result
+}
+
+// reasons returns the reasons of entries
+func reasons(entries []*model.LedgerEntry) []model.Reason {
+	result := make([]model.Reason, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, entry.Reason)
+	}
+	return result
+}
+
+func testCreateAndGet(t *testing.T, repo service.BalanceRepository) {
+	created := create(t, repo, "12.5")
+	result := get(t, repo, created.ProfileID)
+	require.Equal(t, created.BalanceID, result.BalanceID)
+	require.Equal(t, currency, result.Currency)
+	require.Equal(t, model.StatusActive, result.Status)
+	require.Equal(t, created.Version, result.Version)
+	requireAmounts(t, repo, created.ProfileID, "12.5", "12.5")
+
+	_, err := repo.GetUserByID(context.Background(), created.ProfileID, "EUR")
+	require.ErrorIs(t, err, model.ErrNotFound)
+	_, err = repo.GetUserByID(context.Background(), uuid.New(), currency)
+	require.ErrorIs(t, err, model.ErrNotFound)
+	require.Equal(t, []model.Reason{model.ReasonOpening}, reasons(entries(t, repo, created.ProfileID)))
+}
+
+func testDuplicateBalance(t *testing.T, repo service.BalanceRepository) {
+	created := create(t, repo, "1")
+	duplicate := &model.Balance{BalanceID: uuid.New(), ProfileID: created.ProfileID, Currency: currency}
+	require.ErrorIs(t, repo.CreateBalance(context.Background(), duplicate), model.ErrAlreadyExists)
+	sameID := &model.Balance{BalanceID: created.BalanceID, ProfileID: uuid.New(), Currency: currency}
+	require.ErrorIs(t, repo.CreateBalance(context.Background(), sameID), model.ErrAlreadyExists)
+	negative := &model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: currency, Balance: model.MustParseMoney("-1")}
+	require.ErrorIs(t, repo.CreateBalance(context.Background(), negative), model.ErrInsufficientFunds)
+
+	// rejected balances leave nothing behind
+	for _, profileID := range []uuid.UUID{sameID.ProfileID, negative.ProfileID} {
+		_, err := repo.GetUserByID(context.Background(), profileID, currency)
+		require.ErrorIs(t, err, model.ErrNotFound)
+		require.Empty(t, entries(t, repo, profileID))
+	}
+	require.Len(t, entries(t, repo, created.ProfileID), 1)
+
+	other := &model.Balance{BalanceID: uuid.New(), ProfileID: created.ProfileID, Currency: "EUR"}
+	require.NoError(t, repo.CreateBalance(context.Background(), other))
+}
+
+func testUpdateBalance(t *testing.T, repo service.BalanceRepository) {
+	created := create(t, repo, "10")
+	first := get(t, repo, created.ProfileID)
+	stale := *first
+	first.Balance = model.MustParseMoney("7.25")
+	require.NoError(t, repo.UpdateBalance(context.Background(), first))
+	require.Equal(t, stale.Version+1, first.Version)
+	require.Zero(t, first.Available.Cmp(model.MustParseMoney("7.25")))
+
+	stale.Balance = model.MustParseMoney("1")
+	require.ErrorIs(t, repo.UpdateBalance(context.Background(), &stale), model.ErrVersionConflict)
+	requireAmounts(t, repo, created.ProfileID, "7.25", "7.25")
+	stale.Version = 0
+	require.NoError(t, repo.UpdateBalance(context.Background(), &stale))
+	requireAmounts(t, repo, created.ProfileID, "1", "1")
+
+	missing := &model.Balance{ProfileID: uuid.New(), Currency: currency, Balance: model.MustParseMoney("1")}
+	require.ErrorIs(t, repo.UpdateBalance(context.Background(), missing), model.ErrNotFound)
+	negative := &model.Balance{ProfileID: created.ProfileID, Currency: currency, Balance: model.MustParseMoney("-1")}
+	require.ErrorIs(t, repo.UpdateBalance(context.Background(), negative), model.ErrInsufficientFunds)
+
+	ledger := entries(t, repo, created.ProfileID)
+	require.Equal(t, []model.Reason{model.ReasonOpening, model.ReasonAdjustment, model.ReasonAdjustment}, reasons(ledger))
+	require.Zero(t, ledger[1].Delta.Cmp(model.MustParseMoney("-2.75")))
+	require.Zero(t, ledger[2].Balance.Cmp(model.MustParseMoney("1")))
+}
+
+func testDepositWithdraw(t *testing.T, repo service.BalanceRepository) {
+	created := create(t, repo, "10")
+	result, err := repo.Deposit(context.Background(), created.ProfileID, currency, model.MustParseMoney("2.5"), "deposit-1")
+	require.NoError(t, err)
+	require.Zero(t, result.Balance.Cmp(model.MustParseMoney("12.5")))
+	require.Equal(t, created.Version+1, result.Version)
+	latest, err := repo.LatestSequence(context.Background(), created.ProfileID, currency)
+	require.NoError(t, err)
+
+	_, err = repo.Withdraw(context.Background(), created.ProfileID, currency, model.MustParseMoney("12.51"), "withdrawal-1")
+	require.ErrorIs(t, err, model.ErrInsufficientFunds)
+	requireAmounts(t, repo, created.ProfileID, "12.5", "12.5")
+	unchanged, err := repo.LatestSequence(context.Background(), created.ProfileID, currency)
+	require.NoError(t, err)
+	require.Equal(t, latest, unchanged)
+
+	result, err = repo.Withdraw(context.Background(), created.ProfileID, currency, model.MustParseMoney("12.5"), "withdrawal-2")
+	require.NoError(t, err)
+	require.True(t, result.Balance.IsZero())
+	ledger := entries(t, repo, created.ProfileID)
+	require.Equal(t, []model.Reason{model.ReasonOpening, model.ReasonDeposit, model.ReasonWithdrawal}, reasons(ledger))
+	require.Equal(t, "withdrawal-2", ledger[2].Reference)
+	require.Zero(t, ledger[2].Delta.Cmp(model.MustParseMoney("-12.5")))
+
+	_, err = repo.Deposit(context.Background(), uuid.New(), currency, model.MustParseMoney("1"), "")
+	require.ErrorIs(t, err, model.ErrNotFound)
+	_, err = repo.Withdraw(context.Background(), created.ProfileID, "EUR", model.MustParseMoney("1"), "")
+	require.ErrorIs(t, err, model.ErrNotFound)
+	sequence, err := repo.LatestSequence(context.Background(), uuid.New(), currency)
+	require.NoError(t, err)
+	require.Zero(t, sequence)
+}
+
+func testStatuses(t *testing.T, repo service.BalanceRepository) {
+	created := create(t, repo, "10")
+	other := create(t, repo, "10")
+	change := func(status model.BalanceStatus) error {
+		_, err := repo.ChangeStatus(context.Background(), &model.StatusChange{ProfileID: created.ProfileID, Currency: currency, Status: status, Actor: "compliance"})
+		return err
+	}
+
+	freeze := &model.StatusChange{ProfileID: created.ProfileID, Currency: currency, Status: model.StatusDebitFrozen, Actor: "compliance", Reason: "review"}
+	result, err := repo.ChangeStatus(context.Background(), freeze)
+	require.NoError(t, err)
+	require.Equal(t, model.StatusDebitFrozen, result.Status)
+	require.NotEqual(t, uuid.Nil, freeze.ChangeID)
+	require.False(t, freeze.CreatedAt.IsZero())
+	require.ErrorIs(t, change(model.StatusDebitFrozen), model.ErrInvalidStatusTransition)
+	_, err = repo.Withdraw(context.Background(), created.ProfileID, currency, model.MustParseMoney("1"), "")
+	require.ErrorIs(t, err, model.ErrBalanceFrozen)
+	err = repo.CreateHold(context.Background(), &model.Hold{HoldID: uuid.New(), ProfileID: created.ProfileID, Currency: currency, Amount: model.MustParseMoney("1")})
+	require.ErrorIs(t, err, model.ErrBalanceFrozen)
+	_, _, err = repo.Transfer(context.Background(), &model.Transfer{TransferID: uuid.New(), FromProfileID: created.ProfileID, ToProfileID: other.ProfileID,
+		Currency: currency, Amount: model.MustParseMoney("1")})
+	require.ErrorIs(t, err, model.ErrBalanceFrozen)
+	_, err = repo.Deposit(context.Background(), created.ProfileID, currency, model.MustParseMoney("1"), "")
+	require.NoError(t, err)
+
+	require.NoError(t, change(model.StatusFullyFrozen))
+	_, err = repo.Deposit(context.Background(), created.ProfileID, currency, model.MustParseMoney("1"), "")
+	require.ErrorIs(t, err, model.ErrBalanceFrozen)
+	update := &model.Balance{ProfileID: created.ProfileID, Currency: currency, Balance: model.MustParseMoney("20")}
+	require.ErrorIs(t, repo.UpdateBalance(context.Background(), update), model.ErrBalanceFrozen)
+	require.ErrorIs(t, change(model.StatusClosed), model.ErrBalanceFrozen)
+
+	require.NoError(t, change(model.StatusActive))
+	require.ErrorIs(t, change(model.StatusClosed), model.ErrBalanceNotZero)
+	_, err = repo.Withdraw(context.Background(), created.ProfileID, currency, model.MustParseMoney("11"), "")
+	require.NoError(t, err)
+	require.NoError(t, change(model.StatusClosed))
+	requireAmounts(t, repo, created.ProfileID, "0", "0")
+	require.Equal(t, model.StatusClosed, get(t, repo, created.ProfileID).Status)
+
+	_, err = repo.Deposit(context.Background(), created.ProfileID, currency, model.MustParseMoney("1"), "")
+	require.ErrorIs(t, err, model.ErrBalanceClosed)
+	_, err = repo.SetCreditLimit(context.Background(), created.ProfileID, currency, model.MustParseMoney("1"))
+	require.ErrorIs(t, err, model.ErrBalanceClosed)
+	require.ErrorIs(t, change(model.StatusActive), model.ErrBalanceClosed)
+	ledger := entries(t, repo, created.ProfileID)
+	require.Equal(t, model.ReasonClosing, ledger[len(ledger)-1].Reason)
+
+	_, err = repo.ChangeStatus(context.Background(), &model.StatusChange{ProfileID: uuid.New(), Currency: currency, Status: model.StatusClosed, Actor: "compliance"})
+	require.ErrorIs(t, err, model.ErrNotFound)
+}
+
+func testCreditLimit(t *testing.T, repo service.BalanceRepository) {
+	created := create(t, repo, "10")
+	_, err := repo.Withdraw(context.Background(), created.ProfileID, currency, model.MustParseMoney("10.01"), "")
+	require.ErrorIs(t, err, model.ErrInsufficientFunds)
+
+	result, err := repo.SetCreditLimit(context.Background(), created.ProfileID, currency, model.MustParseMoney("50"))
+	require.NoError(t, err)
+	require.Zero(t, result.CreditLimit.Cmp(model.MustParseMoney("50")))
+	result, err = repo.Withdraw(context.Background(), created.ProfileID, currency, model.MustParseMoney("40"), "")
+	require.NoError(t, err)
+	require.Zero(t, result.Balance.Cmp(model.MustParseMoney("-30")))
+
+	_, err = repo.SetCreditLimit(context.Background(), created.ProfileID, currency, model.MustParseMoney("29.99"))
+	require.ErrorIs(t, err, model.ErrCreditLimitInUse)
+	require.Zero(t, get(t, repo, created.ProfileID).CreditLimit.Cmp(model.MustParseMoney("50")))
+	_, err = repo.Withdraw(context.Background(), created.ProfileID, currency, model.MustParseMoney("20.01"), "")
+	require.ErrorIs(t, err, model.ErrInsufficientFunds)
+	_, err = repo.SetCreditLimit(context.Background(), uuid.New(), currency, model.MustParseMoney("1"))
+	require.ErrorIs(t, err, model.ErrNotFound)
+}
+
+func testHolds(t *testing.T, repo service.BalanceRepository) {
+	created := create(t, repo, "100")
+	hold := &model.Hold{HoldID: uuid.New(), ProfileID: created.ProfileID, Currency: currency, Amount: model.MustParseMoney("60"), Reference: "order-1"}
+	require.NoError(t, repo.CreateHold(context.Background(), hold))
+	require.Equal(t, model.HoldActive, hold.Status)
+	requireAmounts(t, repo, created.ProfileID, "100", "40")
+
+	// a failed hold is rolled back as a whole, including the funds it reserved
+	duplicate := &model.Hold{HoldID: hold.HoldID, ProfileID: created.ProfileID, Currency: currency, Amount: model.MustParseMoney("1")}
+	require.ErrorIs(t, repo.CreateHold(context.Background(), duplicate), model.ErrAlreadyExists)
+	requireAmounts(t, repo, created.ProfileID, "100", "40")
+	err := repo.CreateHold(context.Background(), &model.Hold{HoldID: uuid.New(), ProfileID: created.ProfileID, Currency: currency, Amount: model.MustParseMoney("40.01")})
+	require.ErrorIs(t, err, model.ErrInsufficientFunds)
+	err = repo.CreateHold(context.Background(), &model.Hold{HoldID: uuid.New(), ProfileID: uuid.New(), Currency: currency, Amount: model.MustParseMoney("1")})
+	require.ErrorIs(t, err, model.ErrNotFound)
+	_, err = repo.Withdraw(context.Background(), created.ProfileID, currency, model.MustParseMoney("40.01"), "")
+	require.ErrorIs(t, err, model.ErrInsufficientFunds)
+
+	_, _, err = repo.CaptureHold(context.Background(), hold.HoldID, model.MustParseMoney("60.5"))
+	require.ErrorIs(t, err, model.ErrCaptureExceedsHold)
+	captured, balance, err := repo.CaptureHold(context.Background(), hold.HoldID, model.MustParseMoney("45.5"))
+	require.NoError(t, err)
+	require.Equal(t, model.HoldCaptured, captured.Status)
+	require.Zero(t, captured.Captured.Cmp(model.MustParseMoney("45.5")))
+	require.Zero(t, balance.Balance.Cmp(model.MustParseMoney("54.5")))
+	require.Zero(t, balance.Available.Cmp(model.MustParseMoney("54.5")))
+	_, err = repo.ReleaseHold(context.Background(), hold.HoldID)
+	require.ErrorIs(t, err, model.ErrHoldNotActive)
+	_, _, err = repo.CaptureHold(context.Background(), hold.HoldID, model.Money{})
+	require.ErrorIs(t, err, model.ErrHoldNotActive)
+	_, err = repo.ReleaseHold(context.Background(), uuid.New())
+	require.ErrorIs(t, err, model.ErrNotFound)
+
+	second := &model.Hold{HoldID: uuid.New(), ProfileID: created.ProfileID, Currency: currency, Amount: model.MustParseMoney("4.5")}
+	require.NoError(t, repo.CreateHold(context.Background(), second))
+	requireAmounts(t, repo, created.ProfileID, "54.5", "50")
+	released, err := repo.ReleaseHold(context.Background(), second.HoldID)
+	require.NoError(t, err)
+	require.Equal(t, model.HoldReleased, released.Status)
+	requireAmounts(t, repo, created.ProfileID, "54.5", "54.5")
+
+	ledger := entries(t, repo, created.ProfileID)
+	require.Equal(t, []model.Reason{model.ReasonOpening, model.ReasonHoldCapture}, reasons(ledger))
+	require.Equal(t, "order-1", ledger[1].Reference)
+}
+
+func testTransfer(t *testing.T, repo service.BalanceRepository) {
+	from := create(t, repo, "100")
+	to := create(t, repo, "5")
+	transfer := &model.Transfer{TransferID: uuid.New(), FromProfileID: from.ProfileID, ToProfileID: to.ProfileID,
+		Currency: currency, Amount: model.MustParseMoney("30.5"), Reference: "invoice-1"}
+	fromResult, toResult, err := repo.Transfer(context.Background(), transfer)
+	require.NoError(t, err)
+	require.Zero(t, fromResult.Balance.Cmp(model.MustParseMoney("69.5")))
+	require.Zero(t, toResult.Balance.Cmp(model.MustParseMoney("35.5")))
+	require.Equal(t, from.Version+1, fromResult.Version)
+	for _, leg := range []struct {
+		profileID uuid.UUID
+		reason    model.Reason
+	}{{from.ProfileID, model.ReasonTransferOut}, {to.ProfileID, model.ReasonTransferIn}} {
+		ledger := entries(t, repo, leg.profileID)
+		require.Len(t, ledger, 2)
+		require.Equal(t, leg.reason, ledger[1].Reason)
+		require.Equal(t, transfer.TransferID, ledger[1].TransferID)
+		require.Equal(t, "invoice-1", ledger[1].Reference)
+	}
+
+	// a failed transfer changes neither balance and leaves no ledger entries
+	transfer.TransferID, transfer.Amount = uuid.New(), model.MustParseMoney("69.51")
+	_, _, err = repo.Transfer(context.Background(), transfer)
+	require.ErrorIs(t, err, model.ErrInsufficientFunds)
+	transfer.ToProfileID = uuid.New()
+	transfer.Amount = model.MustParseMoney("1")
+	_, _, err = repo.Transfer(context.Background(), transfer)
+	require.ErrorIs(t, err, model.ErrNotFound)
+	transfer.FromProfileID, transfer.ToProfileID = uuid.New(), to.ProfileID
+	_, _, err = repo.Transfer(context.Background(), transfer)
+	require.ErrorIs(t, err, model.ErrNotFound)
+	requireAmounts(t, repo, from.ProfileID, "69.5", "69.5")
+	requireAmounts(t, repo, to.ProfileID, "35.5", "35.5")
+	require.Len(t, entries(t, repo, from.ProfileID), 2)
+	require.Len(t, entries(t, repo, to.ProfileID), 2)
+}
+
+func testGetAll(t *testing.T, repo service.BalanceRepository) {
+	amounts := []string{"5", "1.5", "5", "20", "0"}
+	var created []*model.Balance
+	var profileIDs []uuid.UUID
+	for _, amount := range amounts {
+		balance := create(t, repo, amount)
+		created = append(created, balance)
+		profileIDs = append(profileIDs, balance.ProfileID)
+	}
+	byKey := func(a, b *model.Balance) bool { return bytes.Compare(a.ProfileID[:], b.ProfileID[:]) < 0 }
+	byBalance := func(a, b *model.Balance) bool {
+		if c := a.Balance.Cmp(b.Balance); c != 0 {
+			return c < 0
+		}
+		return byKey(a, b)
+	}
+	orders := map[model.BalanceSort]func(a, b *model.Balance) bool{
+		model.SortByProfileID:   byKey,
+		model.SortByBalanceAsc:  byBalance,
+		model.SortByBalanceDesc: func(a, b *model.Balance) bool { return byBalance(b, a) },
+	}
+	for sortOrder, less := range orders {
+		want := append([]*model.Balance(nil), created...)
+		sort.Slice(want, func(i, j int) bool { return less(want[i], want[j]) })
+		filter := model.BalanceFilter{ProfileIDs: profileIDs, Sort: sortOrder, Limit: 2}
+		var got []uuid.UUID
+		for pages := 0; pages < len(created); pages++ {
+			page, err := repo.GetAll(context.Background(), filter)
+			require.NoError(t, err)
+			if len(page) == 0 {
+				break
+			}
+			require.LessOrEqual(t, len(page), 2)
+			for _, balance := range page {
+				got = append(got, balance.ProfileID)
+			}
+			last := page[len(page)-1]
+			filter.After = &model.BalanceCursor{ProfileID: last.ProfileID, Currency: last.Currency, Balance: last.Balance}
+		}
+		var wantIDs []uuid.UUID
+		for _, balance := range want {
+			wantIDs = append(wantIDs, balance.ProfileID)
+		}
+		require.Equal(t, wantIDs, got, sortOrder)
+	}
+
+	minBalance, maxBalance := model.MustParseMoney("1.5"), model.MustParseMoney("5")
+	page, err := repo.GetAll(context.Background(), model.BalanceFilter{ProfileIDs: profileIDs, MinBalance: &minBalance, MaxBalance: &maxBalance,
+		Currency: currency, Sort: model.SortByBalanceAsc, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, page, 3)
+	page, err = repo.GetAll(context.Background(), model.BalanceFilter{ProfileIDs: profileIDs, Currency: "EUR", Sort: model.SortByProfileID, Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, page)
+	_, err = repo.GetAll(context.Background(), model.BalanceFilter{Sort: "volume", Limit: 1})
+	require.ErrorIs(t, err, model.ErrInvalidSort)
+}
+
+func testStreamAll(t *testing.T, repo service.BalanceRepository) {
+	created := map[uuid.UUID]bool{}
+	for i := 0; i < 3; i++ {
+		created[create(t, repo, "1").ProfileID] = true
+	}
+	var previous *model.Balance
+	seen := 0
+	err := repo.StreamAll(context.Background(), func(balance *model.Balance) error {
+		if previous != nil {
+			c := bytes.Compare(previous.ProfileID[:], balance.ProfileID[:])
+			require.True(t, c < 0 || (c == 0 && previous.Currency < balance.Currency), "stream is not ordered")
+		}
+		previous = balance
+		if created[balance.ProfileID] {
+			seen++
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, len(created), seen)
+
+	stop := errors.New("stop")
+	err = repo.StreamAll(context.Background(), func(*model.Balance) error {
+		return stop
+	})
+	require.ErrorIs(t, err, stop)
+}
+
+func testListTransactions(t *testing.T, repo service.BalanceRepository) {
+	created := create(t, repo, "1")
+	other := &model.Balance{BalanceID: uuid.New(), ProfileID: created.ProfileID, Currency: "EUR", Balance: model.MustParseMoney("2")}
+	require.NoError(t, repo.CreateBalance(context.Background(), other))
+	for _, reference := range []string{"dep-1", "dep-2"} {
+		_, err := repo.Deposit(context.Background(), created.ProfileID, currency, model.MustParseMoney("1"), reference)
+		require.NoError(t, err)
+	}
+
+	all := entries(t, repo, created.ProfileID)
+	require.Len(t, all, 4)
+	for i := 1; i < len(all); i++ {
+		require.Greater(t, all[i].Sequence, all[i-1].Sequence)
+	}
+	latest, err := repo.LatestSequence(context.Background(), created.ProfileID, currency)
+	require.NoError(t, err)
+	require.Equal(t, all[3].Sequence, latest)
+
+	page, err := repo.ListTransactions(context.Background(), model.LedgerFilter{ProfileID: created.ProfileID, AfterSequence: all[0].Sequence, Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []int64{all[1].Sequence, all[2].Sequence}, []int64{page[0].Sequence, page[1].Sequence})
+	page, err = repo.ListTransactions(context.Background(), model.LedgerFilter{ProfileID: created.ProfileID, Currency: "EUR", Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	require.Equal(t, model.Currency("EUR"), page[0].Currency)
+	page, err = repo.ListTransactions(context.Background(), model.LedgerFilter{ProfileID: created.ProfileID, From: all[3].CreatedAt.Add(time.Hour), Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, page)
+	page, err = repo.ListTransactions(context.Background(), model.LedgerFilter{ProfileID: created.ProfileID, To: all[0].CreatedAt.Add(-time.Hour), Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, page)
+}
+
+func testConcurrentDeposits(t *testing.T, repo service.BalanceRepository) {
+	created := create(t, repo, "0")
+	const deposits = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, deposits)
+	for i := 0; i < deposits; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repo.Deposit(context.Background(), created.ProfileID, currency, model.MustParseMoney("0.1"), "")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	requireAmounts(t, repo, created.ProfileID, "2", "2")
+	require.Equal(t, created.Version+deposits, get(t, repo, created.ProfileID).Version)
+	require.Len(t, entries(t, repo, created.ProfileID), deposits+1)
+}
+
+func testOppositeTransfers(t *testing.T, repo service.BalanceRepository) {
+	a := create(t, repo, "100")
+	b := create(t, repo, "100")
+	const transfers = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*transfers)
+	for i := 0; i < transfers; i++ {
+		for _, pair := range [][2]uuid.UUID{{a.ProfileID, b.ProfileID}, {b.ProfileID, a.ProfileID}} {
+			wg.Add(1)
+			go func(from, to uuid.UUID) {
+				defer wg.Done()
+				_, _, err := repo.Transfer(context.Background(), &model.Transfer{TransferID: uuid.New(), FromProfileID: from, ToProfileID: to,
+					Currency: currency, Amount: model.MustParseMoney("1")})
+				errs <- err
+			}(pair[0], pair[1])
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	requireAmounts(t, repo, a.ProfileID, "100", "100")
+	requireAmounts(t, repo, b.ProfileID, "100", "100")
+}
+
+func testUnitOfWork(t *testing.T, repo service.BalanceRepository) {
+	funded := create(t, repo, "10")
+	opened := &model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: currency}
+	transfer := &model.Transfer{TransferID: uuid.New(), FromProfileID: funded.ProfileID, ToProfileID: opened.ProfileID,
+		Currency: currency, Amount: model.MustParseMoney("4")}
+	openAndFund := func(rps service.BalanceRepository) error {
+		err := rps.CreateBalance(context.Background(), opened)
+		if err != nil {
+			return err
+		}
+		_, _, err = rps.Transfer(context.Background(), transfer)
+		if err != nil {
+			return err
+		}
+		// reads inside the unit of work see its changes
+		balance, err := rps.GetUserByID(context.Background(), opened.ProfileID, currency)
+		if err != nil {
+			return err
+		}
+		require.Zero(t, balance.Balance.Cmp(model.MustParseMoney("4")))
+		require.Len(t, entries(t, rps, opened.ProfileID), 2)
+		return nil
+	}
+
+	abort := errors.New("abort")
+	err := repo.InTx(context.Background(), model.Serializable, func(rps service.BalanceRepository) error {
+		require.NoError(t, openAndFund(rps))
+		return abort
+	})
+	require.ErrorIs(t, err, abort)
+	_, err = repo.GetUserByID(context.Background(), opened.ProfileID, currency)
+	require.ErrorIs(t, err, model.ErrNotFound)
+	require.Empty(t, entries(t, repo, opened.ProfileID))
+	requireAmounts(t, repo, funded.ProfileID, "10", "10")
+	require.Len(t, entries(t, repo, funded.ProfileID), 1)
+
+	require.NoError(t, repo.InTx(context.Background(), model.RepeatableRead, openAndFund))
+	requireAmounts(t, repo, opened.ProfileID, "4", "4")
+	requireAmounts(t, repo, funded.ProfileID, "6", "6")
+	require.Equal(t, []model.Reason{model.ReasonOpening, model.ReasonTransferIn}, reasons(entries(t, repo, opened.ProfileID)))
+}
+
+func testUnitOfWorkFailedOperation(t *testing.T, repo service.BalanceRepository) {
+	created := create(t, repo, "10")
+	err := repo.InTx(context.Background(), model.ReadCommitted, func(rps service.BalanceRepository) error {
+		_, err := rps.Withdraw(context.Background(), created.ProfileID, currency, model.MustParseMoney("11"), "")
+		require.ErrorIs(t, err, model.ErrInsufficientFunds)
+		// the failed operation is undone on its own and the unit of work goes on
+		_, err = rps.Withdraw(context.Background(), created.ProfileID, currency, model.MustParseMoney("3"), "")
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 2; i++ {
+			streamed := 0
+			err = rps.StreamAll(context.Background(), func(balance *model.Balance) error {
+				if balance.ProfileID == created.ProfileID {
+					streamed++
+					require.Zero(t, balance.Balance.Cmp(model.MustParseMoney("7")))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			require.Equal(t, 1, streamed)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	requireAmounts(t, repo, created.ProfileID, "7", "7")
+	require.Equal(t, []model.Reason{model.ReasonOpening, model.ReasonWithdrawal}, reasons(entries(t, repo, created.ProfileID)))
+}
+
+func testUnitOfWorkFailedQuery(t *testing.T, repo service.BalanceRepository) {
+	created := create(t, repo, "10")
+	err := repo.InTx(context.Background(), model.ReadCommitted, func(rps service.BalanceRepository) error {
+		// failed reads are undone on their own like failed writes, the unit of work goes on
+		_, err := rps.GetAll(context.Background(), model.BalanceFilter{Sort: model.SortByProfileID, Limit: -1})
+		require.Error(t, err)
+		_, err = rps.ListTransactions(context.Background(), model.LedgerFilter{ProfileID: created.ProfileID, Limit: -1})
+		require.Error(t, err)
+		_, err = rps.Deposit(context.Background(), created.ProfileID, currency, model.MustParseMoney("5"), "")
+		if err != nil {
+			return err
+		}
+		sequence, err := rps.LatestSequence(context.Background(), created.ProfileID, currency)
+		if err != nil {
+			return err
+		}
+		listed := entries(t, rps, created.ProfileID)
+		require.Len(t, listed, 2)
+		require.Equal(t, listed[1].Sequence, sequence)
+		return nil
+	})
+	require.NoError(t, err)
+	requireAmounts(t, repo, created.ProfileID, "15", "15")
+	require.Equal(t, []model.Reason{model.ReasonOpening, model.ReasonDeposit}, reasons(entries(t, repo, created.ProfileID)))
+}
+
+func testNestedUnitOfWork(t *testing.T, repo service.BalanceRepository) {
+	created := create(t, repo, "10")
+	abort := errors.New("abort")
+	err := repo.InTx(context.Background(), "", func(rps service.BalanceRepository) error {
+		_, err := rps.Deposit(context.Background(), created.ProfileID, currency, model.MustParseMoney("1"), "outer")
+		if err != nil {
+			return err
+		}
+		err = rps.InTx(context.Background(), model.Serializable, func(nested service.BalanceRepository) error {
+			_, err := nested.Deposit(context.Background(), created.ProfileID, currency, model.MustParseMoney("100"), "inner")
+			require.NoError(t, err)
+			return abort
+		})
+		require.ErrorIs(t, err, abort)
+		return rps.InTx(context.Background(), "", func(nested service.BalanceRepository) error {
+			_, err := nested.Deposit(context.Background(), created.ProfileID, currency, model.MustParseMoney("2"), "kept")
+			return err
+		})
+	})
+	require.NoError(t, err)
+	requireAmounts(t, repo, created.ProfileID, "13", "13")
+	var references []string
+	for _, entry := range entries(t, repo, created.ProfileID) {
+		references = append(references, entry.Reference)
+	}
+	require.Equal(t, []string{"", "outer", "kept"}, references)
+}
+
+func testUnitOfWorkPanic(t *testing.T, repo service.BalanceRepository) {
+	created := create(t, repo, "10")
+	require.PanicsWithValue(t, "boom", func() {
+		_ = repo.InTx(context.Background(), "", func(rps service.BalanceRepository) error {
+			_, err := rps.Deposit(context.Background(), created.ProfileID, currency, model.MustParseMoney("1"), "")
+			require.NoError(t, err)
+			panic("boom")
+		})
+	})
+	requireAmounts(t, repo, created.ProfileID, "10", "10")
+	require.Len(t, entries(t, repo, created.ProfileID), 1)
+}
@@ -7,6 +7,8 @@ import (
 	"os"
 	"testing"
 
+	"github.com/eugenshima/balance/internal/migrations"
+
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/ory/dockertest"
 )
@@ -49,11 +51,19 @@ func SetupTestPgx() (*pgxpool.Pool, func(), error) {
 	return dbpool, cleanup, nil
 }
 
-// TestMain execute all tests
+// TestMain execute all tests, the database tests are skipped when the test database cannot be started
 func TestMain(m *testing.M) {
 	dbpool, cleanupPgx, err := SetupTestPgx()
 	if err != nil {
-		fmt.Println("Could not construct the pool: ", err)
+		fmt.Println("Could not construct the pool, skipping the database tests: ", err)
+		os.Exit(m.Run())
+	}
+	migrator, err := migrations.NewMigrator(dbpool, "USD")
+	if err == nil {
+		_, err = migrator.Up(context.Background())
+	}
+	if err != nil {
+		fmt.Println("Could not migrate the database: ", err)
 		cleanupPgx()
 		os.Exit(1)
 	}
@@ -63,3 +73,11 @@ func TestMain(m *testing.M) {
 	cleanupPgx()
 	os.Exit(exitVal)
 }
+
+// skipWithoutDB skips a test that needs the database when TestMain could not start it
+func skipWithoutDB(t *testing.T) {
+	t.Helper()
+	if rps == nil {
+		t.Skip("no test database, Docker is not available")
+	}
+}
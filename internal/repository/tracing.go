@@ -0,0 +1,280 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of transactions and statements
+var tracer = otel.Tracer("github.com/eugenshima/balance/internal/repository")
+
+// dbSystem identifies PostgreSQL in the attributes of database spans
+var dbSystem = attribute.String("db.system", "postgresql")
+
+// beginTx starts a transaction that is traced and whose commit or rollback is counted. The transaction span has a
+// BeginTx child covering the wait for a connection and every statement of the transaction as children.
+// Inside a unit of work it starts a traced savepoint of the unit's transaction instead.
+func (db *PsqlConnection) beginTx(ctx context.Context, options pgx.TxOptions) (pgx.Tx, error) {
+	if db.tx != nil {
+		ctx, span := tracer.Start(ctx, "Savepoint", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(dbSystem))
+		tx, err := db.tx.Begin(ctx)
+		if err != nil {
+			endSpan(span, err)
+			return nil, err
+		}
+		return &tracedTx{Tx: tx, span: span}, nil
+	}
+	ctx, span := tracer.Start(ctx, "Transaction", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(dbSystem, attribute.String("db.isolation_level", string(options.IsoLevel))))
+	_, beginSpan := tracer.Start(ctx, "BeginTx", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(dbSystem))
+	tx, err := db.pool.BeginTx(ctx, options)
+	endSpan(beginSpan, err)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	return &tracedTx{Tx: &countedTx{Tx: tx, transactions: db.transactions}, span: span}, nil
+}
+
+// query runs a traced query outside of a transaction. Inside a unit of work it runs in a savepoint that is released
+// when the rows are closed or read to the end and rolled back if the query failed, so the unit can go on.
+func (db *PsqlConnection) query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	if db.tx != nil {
+		tx, err := db.beginTx(ctx, pgx.TxOptions{})
+		if err != nil {
+			return nil, err
+		}
+		rows, err := tx.Query(ctx, sql, args...)
+		if err != nil {
+			rollback(ctx, tx)
+			return nil, err
+		}
+		return &savepointRows{Rows: rows, ctx: ctx, tx: tx}, nil
+	}
+	ctx, span := startStatement(ctx, sql)
+	rows, err := db.pool.Query(ctx, sql, args...)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	return &tracedRows{Rows: rows, span: span}, nil
+}
+
+// queryRow runs a traced single row query outside of a transaction, or in a savepoint of the transaction of a unit of work
+func (db *PsqlConnection) queryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	if db.tx != nil {
+		return &savepointRow{db: db, ctx: ctx, sql: sql, args: args}
+	}
+	ctx, span := startStatement(ctx, sql)
+	return &tracedRow{Row: db.pool.QueryRow(ctx, sql, args...), span: span}
+}
+
+// exec runs a traced statement outside of a transaction, or in a savepoint of the transaction of a unit of work
+func (db *PsqlConnection) exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	if db.tx != nil {
+		var tag pgconn.CommandTag
+		err := db.inSavepoint(ctx, func(tx pgx.Tx) error {
+			var err error
+			tag, err = tx.Exec(ctx, sql, args...)
+			return err
+		})
+		return tag, err
+	}
+	ctx, span := startStatement(ctx, sql)
+	tag, err := db.pool.Exec(ctx, sql, args...)
+	endSpan(span, err)
+	return tag, err
+}
+
+// inSavepoint runs fn in a savepoint of the transaction of a unit of work, which is rolled back when fn fails,
+// so a failed statement is undone on its own instead of aborting the whole transaction
+func (db *PsqlConnection) inSavepoint(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	tx, err := db.beginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	return runTx(ctx, tx, fn)
+}
+
+// savepointRow runs its query in a savepoint when it is scanned
+type savepointRow struct {
+	db   *PsqlConnection
+	ctx  context.Context
+	sql  string
+	args []interface{}
+}
+
+// Scan runs the query in a savepoint and reads the row
+func (r *savepointRow) Scan(dest ...interface{}) error {
+	return r.db.inSavepoint(r.ctx, func(tx pgx.Tx) error {
+		return tx.QueryRow(r.ctx, r.sql, r.args...).Scan(dest...)
+	})
+}
+
+// savepointRows ends the savepoint of its query when the rows are closed or read to the end,
+// the savepoint is rolled back if reading the rows failed and released otherwise
+type savepointRows struct {
+	pgx.Rows
+	ctx  context.Context
+	tx   pgx.Tx
+	err  error
+	once sync.Once
+}
+
+// Next advances to the next row and ends the savepoint after the last one
+func (r *savepointRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.end()
+	return false
+}
+
+// Close closes the rows and ends the savepoint
+func (r *savepointRows) Close() {
+	r.Rows.Close()
+	r.end()
+}
+
+// Err returns the error of the rows or, after they were read without one, the error of releasing the savepoint
+func (r *savepointRows) Err() error {
+	err := r.Rows.Err()
+	if err != nil {
+		return err
+	}
+	return r.err
+}
+
+func (r *savepointRows) end() {
+	r.once.Do(func() {
+		if r.Rows.Err() != nil {
+			rollback(r.ctx, r.tx)
+			return
+		}
+		r.err = r.tx.Commit(r.ctx)
+	})
+}
+
+// tracedTx is a pgx.Tx whose statements are children of the transaction span, which ends with the transaction
+type tracedTx struct {
+	pgx.Tx
+	span trace.Span
+}
+
+// Commit commits the transaction and ends its span
+func (tx *tracedTx) Commit(ctx context.Context) error {
+	err := tx.Tx.Commit(ctx)
+	endSpan(tx.span, err)
+	return err
+}
+
+// Rollback rolls the transaction back and ends its span
+func (tx *tracedTx) Rollback(ctx context.Context) error {
+	err := tx.Tx.Rollback(ctx)
+	tx.span.SetAttributes(attribute.Bool("db.rolled_back", true))
+	endSpan(tx.span, err)
+	return err
+}
+
+// Exec runs a traced statement in the transaction
+func (tx *tracedTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	ctx, span := startStatement(tx.spanContext(ctx), sql)
+	tag, err := tx.Tx.Exec(ctx, sql, args...)
+	endSpan(span, err)
+	return tag, err
+}
+
+// Query runs a traced query in the transaction
+func (tx *tracedTx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	ctx, span := startStatement(tx.spanContext(ctx), sql)
+	rows, err := tx.Tx.Query(ctx, sql, args...)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	return &tracedRows{Rows: rows, span: span}, nil
+}
+
+// QueryRow runs a traced single row query in the transaction
+func (tx *tracedTx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	ctx, span := startStatement(tx.spanContext(ctx), sql)
+	return &tracedRow{Row: tx.Tx.QueryRow(ctx, sql, args...), span: span}
+}
+
+// spanContext returns ctx with the transaction span as the parent of statement spans
+func (tx *tracedTx) spanContext(ctx context.Context) context.Context {
+	return trace.ContextWithSpan(ctx, tx.span)
+}
+
+// tracedRows ends the span of its query when the rows are closed or read to the end
+type tracedRows struct {
+	pgx.Rows
+	span trace.Span
+	once sync.Once
+}
+
+// Next advances to the next row and ends the span after the last one
+func (r *tracedRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.end()
+	return false
+}
+
+// Close closes the rows and ends the span
+func (r *tracedRows) Close() {
+	r.Rows.Close()
+	r.end()
+}
+
+func (r *tracedRows) end() {
+	r.once.Do(func() {
+		endSpan(r.span, r.Rows.Err())
+	})
+}
+
+// tracedRow ends the span of its query when the row is scanned
+type tracedRow struct {
+	pgx.Row
+	span trace.Span
+}
+
+// Scan reads the row and ends the span, pgx.ErrNoRows is an expected outcome and not recorded as an error
+func (r *tracedRow) Scan(dest ...interface{}) error {
+	err := r.Row.Scan(dest...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		endSpan(r.span, nil)
+		return err
+	}
+	endSpan(r.span, err)
+	return err
+}
+
+// startStatement starts the span of a statement, it is named after the statement's first keyword
+func startStatement(ctx context.Context, sql string) (context.Context, trace.Span) {
+	name := "SQL"
+	if fields := strings.Fields(sql); len(fields) > 0 {
+		name = strings.ToUpper(fields[0])
+	}
+	return tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(dbSystem, attribute.String("db.statement", sql)))
+}
+
+// endSpan records err on span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
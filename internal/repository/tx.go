@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/eugenshima/balance/internal/model"
+	"github.com/eugenshima/balance/internal/service"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// InTx function runs fn with a repository whose operations all belong to one transaction at isolation,
+// they are committed together when fn returns nil and rolled back together when it fails or panics.
+// Every operation runs in a savepoint, so one that fails is undone on its own and fn may go on.
+// The repository passed to fn must not be used concurrently or after fn returns.
+// Inside another unit of work fn runs in a savepoint of its transaction and isolation is ignored.
+func (db *PsqlConnection) InTx(ctx context.Context, isolation model.IsolationLevel, fn func(rps service.BalanceRepository) error) error {
+	return db.inTx(ctx, pgx.TxOptions{IsoLevel: pgx.TxIsoLevel(isolation)}, func(tx pgx.Tx) error {
+		return fn(&PsqlConnection{pool: db.pool, transactions: db.transactions, tx: tx})
+	})
+}
+
+// inTx runs fn in a transaction started with options, or in a savepoint when db belongs to a unit of work
+func (db *PsqlConnection) inTx(ctx context.Context, options pgx.TxOptions, fn func(tx pgx.Tx) error) error {
+	tx, err := db.beginTx(ctx, options)
+	if err != nil {
+		return fmt.Errorf("BeginTx: %w", dbError(err))
+	}
+	return runTx(ctx, tx, fn)
+}
+
+// runTx calls fn with tx and commits tx when fn returns nil. A failed commit is returned, so a caller never
+// mistakes a lost transaction for a success. tx is rolled back when fn fails or panics, the error of fn is returned
+// and a failed rollback is only logged because the transaction is discarded either way.
+func runTx(ctx context.Context, tx pgx.Tx, fn func(tx pgx.Tx) error) (err error) {
+	committed := false
+	defer func() {
+		if !committed {
+			rollback(ctx, tx)
+		}
+	}()
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+	// Commit ends the transaction even when it fails, there is nothing left to roll back
+	committed = true
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("Commit: %w", dbError(err))
+	}
+	return nil
+}
+
+// rollback rolls tx back and only logs a failure, the transaction is discarded either way
+func rollback(ctx context.Context, tx pgx.Tx) {
+	err := tx.Rollback(ctx)
+	if err != nil {
+		logrus.Errorf("Rollback: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/eugenshima/balance/internal/model"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// faultyTx is a pgx.Tx whose commit and rollback fail with the injected errors and that records how it ended
+type faultyTx struct {
+	pgx.Tx
+	commitErr   error
+	rollbackErr error
+	committed   bool
+	rolledBack  bool
+}
+
+func (tx *faultyTx) Commit(context.Context) error {
+	tx.committed = true
+	return tx.commitErr
+}
+
+func (tx *faultyTx) Rollback(context.Context) error {
+	tx.rolledBack = true
+	return tx.rollbackErr
+}
+
+// TestRunTxCommits tests that a transaction is committed once when fn succeeds
+func TestRunTxCommits(t *testing.T) {
+	tx := &faultyTx{}
+	require.NoError(t, runTx(context.Background(), tx, func(pgx.Tx) error { return nil }))
+	require.True(t, tx.committed)
+	require.False(t, tx.rolledBack)
+}
+
+// TestRunTxReturnsCommitFailure tests that a failed commit reaches the caller translated like other database errors
+func TestRunTxReturnsCommitFailure(t *testing.T) {
+	tx := &faultyTx{commitErr: &pgconn.PgError{Code: serializationFailure}}
+	err := runTx(context.Background(), tx, func(pgx.Tx) error { return nil })
+	require.ErrorIs(t, err, model.ErrConflict)
+	require.False(t, tx.rolledBack)
+
+	tx = &faultyTx{commitErr: pgx.ErrTxCommitRollback}
+	err = runTx(context.Background(), tx, func(pgx.Tx) error { return nil })
+	require.ErrorIs(t, err, pgx.ErrTxCommitRollback)
+}
+
+// TestRunTxRollsBack tests that the error of fn is returned and the transaction rolled back even if the rollback fails
+func TestRunTxRollsBack(t *testing.T) {
+	for _, rollbackErr := range []error{nil, errors.New("connection lost")} {
+		tx := &faultyTx{rollbackErr: rollbackErr}
+		err := runTx(context.Background(), tx, func(pgx.Tx) error { return model.ErrInsufficientFunds })
+		require.Equal(t, model.ErrInsufficientFunds, err)
+		require.True(t, tx.rolledBack)
+		require.False(t, tx.committed)
+	}
+}
+
+// TestRunTxRollsBackOnPanic tests that a panic of fn rolls the transaction back and is passed on
+func TestRunTxRollsBackOnPanic(t *testing.T) {
+	tx := &faultyTx{}
+	require.PanicsWithValue(t, "boom", func() {
+		_ = runTx(context.Background(), tx, func(pgx.Tx) error { panic("boom") })
+	})
+	require.True(t, tx.rolledBack)
+	require.False(t, tx.committed)
+}
+
+// failedRows is a pgx.Rows without rows that reports err after it was read
+type failedRows struct {
+	pgx.Rows
+	err error
+}
+
+func (r *failedRows) Next() bool { return false }
+
+func (r *failedRows) Close() {}
+
+func (r *failedRows) Err() error { return r.err }
+
+// TestSavepointRows tests that the savepoint of a query is rolled back when the query failed and released otherwise
+func TestSavepointRows(t *testing.T) {
+	tx := &faultyTx{}
+	rows := &savepointRows{Rows: &failedRows{err: &pgconn.PgError{Code: "2201W"}}, ctx: context.Background(), tx: tx}
+	require.False(t, rows.Next())
+	rows.Close()
+	require.Error(t, rows.Err())
+	require.True(t, tx.rolledBack)
+	require.False(t, tx.committed)
+
+	tx = &faultyTx{commitErr: errors.New("connection lost")}
+	rows = &savepointRows{Rows: &failedRows{}, ctx: context.Background(), tx: tx}
+	rows.Close()
+	require.EqualError(t, rows.Err(), "connection lost")
+	require.True(t, tx.committed)
+	require.False(t, tx.rolledBack)
+}
@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/sirupsen/logrus"
+)
+
+// balanceChannel is the LISTEN/NOTIFY channel insertLedgerEntry notifies with the profile ID of every ledger entry
+const balanceChannel = "balance_changed"
+
+// watchRetryDelay is how long BalanceWatcher waits before listening again after losing its connection
+const watchRetryDelay = time.Second
+
+// BalanceWatcher listens for balance change notifications on a single connection and fans them out to subscribers,
+// so a replica holds one listening connection however many watchers it serves
+type BalanceWatcher struct {
+	pool        *pgxpool.Pool
+	mu          sync.Mutex
+	subscribers map[uuid.UUID]map[chan struct{}]struct{}
+}
+
+// NewBalanceWatcher constructor for BalanceWatcher
+func NewBalanceWatcher(pool *pgxpool.Pool) *BalanceWatcher {
+	return &BalanceWatcher{pool: pool, subscribers: map[uuid.UUID]map[chan struct{}]struct{}{}}
+}
+
+// Subscribe returns a channel that receives a value after balance changes of profileID and a function that ends the subscription.
+// Changes made while a value is pending are coalesced into it, subscribers re-read everything after the last entry they saw.
+func (w *BalanceWatcher) Subscribe(profileID uuid.UUID) (<-chan struct{}, func()) {
+	changed := make(chan struct{}, 1)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.subscribers[profileID] == nil {
+		w.subscribers[profileID] = map[chan struct{}]struct{}{}
+	}
+	w.subscribers[profileID][changed] = struct{}{}
+	return changed, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.subscribers[profileID], changed)
+		if len(w.subscribers[profileID]) == 0 {
+			delete(w.subscribers, profileID)
+		}
+	}
+}
+
+// Run listens for notifications until ctx is done, listening again after connection errors.
+// Every subscriber is signalled whenever listening starts because notifications sent in between are lost.
+func (w *BalanceWatcher) Run(ctx context.Context) {
+	for {
+		err := w.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		logrus.Errorf("listen: %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchRetryDelay):
+		}
+	}
+}
+
+// listen holds one connection listening on balanceChannel until it fails or ctx is done
+func (w *BalanceWatcher) listen(ctx context.Context) error {
+	pooled, err := w.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("Acquire: %w", dbError(err))
+	}
+	// The connection is taken out of the pool and closed afterwards, so no pooled connection keeps listening
+	conn := pooled.Hijack()
+	defer func() {
+		err := conn.Close(context.Background())
+		if err != nil {
+			logrus.Errorf("Close: %v", err)
+		}
+	}()
+	_, err = conn.Exec(ctx, "LISTEN "+balanceChannel)
+	if err != nil {
+		return fmt.Errorf("Exec(): %w", dbError(err))
+	}
+	w.signalAll()
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return fmt.Errorf("WaitForNotification: %w", dbError(err))
+		}
+		profileID, err := uuid.Parse(notification.Payload)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"Payload": notification.Payload}).Errorf("Parse: %v", err)
+			continue
+		}
+		w.signal(profileID)
+	}
+}
+
+// signal wakes up the subscribers of profileID without blocking on the ones that already have a pending value
+func (w *BalanceWatcher) signal(profileID uuid.UUID) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for changed := range w.subscribers[profileID] {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// signalAll wakes up every subscriber
+func (w *BalanceWatcher) signalAll() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, subscribers := range w.subscribers {
+		for changed := range subscribers {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
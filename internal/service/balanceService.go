@@ -11,44 +11,277 @@ import (
 
 // BalanceService struct represents a Balance Service
 type BalanceService struct {
-	rps BalanceRepository
+	rps      BalanceRepository
+	notifier BalanceNotifier
 }
 
 // NewBalanceService function creates a new Balance Service
-func NewBalanceService(rps BalanceRepository) *BalanceService {
-	return &BalanceService{rps: rps}
+func NewBalanceService(rps BalanceRepository, notifier BalanceNotifier) *BalanceService {
+	return &BalanceService{rps: rps, notifier: notifier}
 }
 
-// BalanceRepository represents a Balance Repository methods
+// BalanceNotifier signals balance changes of a profile, the returned function ends the subscription
+type BalanceNotifier interface {
+	Subscribe(profileID uuid.UUID) (<-chan struct{}, func())
+}
+
+// BalanceRepository represents a Balance Repository methods.
+// InTx runs fn with a repository whose operations commit together when fn returns nil and are rolled back together
+// otherwise, an operation that fails inside fn is undone on its own.
 type BalanceRepository interface {
-	GetAll(ctx context.Context) ([]*model.Balance, error)
+	InTx(ctx context.Context, isolation model.IsolationLevel, fn func(rps BalanceRepository) error) error
+	GetAll(ctx context.Context, filter model.BalanceFilter) ([]*model.Balance, error)
+	StreamAll(ctx context.Context, fn func(*model.Balance) error) error
 	UpdateBalance(ctx context.Context, user *model.Balance) error
-	GetUserByID(ctx context.Context, profile_id uuid.UUID) (*model.Balance, error)
+	GetUserByID(ctx context.Context, profile_id uuid.UUID, currency model.Currency) (*model.Balance, error)
 	CreateBalance(ctx context.Context, user *model.Balance) error
-	DeleteBalance(ctx context.Context, userID uuid.UUID) error
+	ChangeStatus(ctx context.Context, change *model.StatusChange) (*model.Balance, error)
+	SetCreditLimit(ctx context.Context, profileID uuid.UUID, currency model.Currency, creditLimit model.Money) (*model.Balance, error)
+	Deposit(ctx context.Context, profileID uuid.UUID, currency model.Currency, amount model.Money, reference string) (*model.Balance, error)
+	Withdraw(ctx context.Context, profileID uuid.UUID, currency model.Currency, amount model.Money, reference string) (*model.Balance, error)
+	ListTransactions(ctx context.Context, filter model.LedgerFilter) ([]*model.LedgerEntry, error)
+	LatestSequence(ctx context.Context, profileID uuid.UUID, currency model.Currency) (int64, error)
+	Transfer(ctx context.Context, transfer *model.Transfer) (*model.Balance, *model.Balance, error)
+	CreateHold(ctx context.Context, hold *model.Hold) error
+	CaptureHold(ctx context.Context, holdID uuid.UUID, amount model.Money) (*model.Hold, *model.Balance, error)
+	ReleaseHold(ctx context.Context, holdID uuid.UUID) (*model.Hold, error)
+}
+
+// Atomically function runs fn with a BalanceService whose operations all take effect when fn returns nil
+// and none of them otherwise, e.g. to open a balance and fund it with a transfer in one step.
+// The service passed to fn must not be used concurrently or after fn returns.
+func (s *BalanceService) Atomically(ctx context.Context, isolation model.IsolationLevel, fn func(ctx context.Context, srv *BalanceService) error) (err error) {
+	ctx, span := startSpan(ctx, "Atomically")
+	defer endSpan(span, &err)
+	return s.rps.InTx(ctx, isolation, func(rps BalanceRepository) error {
+		return fn(ctx, &BalanceService{rps: rps, notifier: s.notifier})
+	})
 }
 
-// GetAllBalances function returns Get All repository method
-func (s *BalanceService) GetAllBalances(ctx context.Context) ([]*model.Balance, error) {
-	return s.rps.GetAll(ctx)
+// GetAllBalances function returns a page of balances and the cursor to continue after,
+// the returned cursor is nil when there are no more balances. An empty sort order means SortByProfileID.
+func (s *BalanceService) GetAllBalances(ctx context.Context, filter model.BalanceFilter) (balances []*model.Balance, next *model.BalanceCursor, err error) {
+	ctx, span := startSpan(ctx, "GetAllBalances")
+	defer endSpan(span, &err)
+	limit := filter.Limit
+	if limit <= 0 {
+		return nil, nil, model.ErrInvalidPageSize
+	}
+	if filter.Sort == "" {
+		filter.Sort = model.SortByProfileID
+	}
+	filter.Limit = limit + 1
+	balances, err = s.rps.GetAll(ctx, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(balances) <= limit {
+		return balances, nil, nil
+	}
+	balances = balances[:limit]
+	last := balances[limit-1]
+	return balances, &model.BalanceCursor{ProfileID: last.ProfileID, Currency: last.Currency, Balance: last.Balance}, nil
+}
+
+// StreamAllBalances function calls fn for every balance of one consistent snapshot, it stops at the first error of fn
+func (s *BalanceService) StreamAllBalances(ctx context.Context, fn func(*model.Balance) error) (err error) {
+	ctx, span := startSpan(ctx, "StreamAllBalances")
+	defer endSpan(span, &err)
+	return s.rps.StreamAll(ctx, fn)
 }
 
 // UpdateBalance function returns Update repository method
-func (s *BalanceService) UpdateBalance(ctx context.Context, user *model.Balance) error {
+func (s *BalanceService) UpdateBalance(ctx context.Context, user *model.Balance) (err error) {
+	ctx, span := startSpan(ctx, "UpdateBalance")
+	defer endSpan(span, &err)
 	return s.rps.UpdateBalance(ctx, user)
 }
 
 // GetUserByID function returns Get By ID repository method
-func (s *BalanceService) GetUserByID(ctx context.Context, userID uuid.UUID) (*model.Balance, error) {
-	return s.rps.GetUserByID(ctx, userID)
+func (s *BalanceService) GetUserByID(ctx context.Context, userID uuid.UUID, currency model.Currency) (balance *model.Balance, err error) {
+	ctx, span := startSpan(ctx, "GetUserByID")
+	defer endSpan(span, &err)
+	return s.rps.GetUserByID(ctx, userID, currency)
 }
 
 // CreateBalance function returns Create repository method
-func (s *BalanceService) CreateBalance(ctx context.Context, user *model.Balance) error {
+func (s *BalanceService) CreateBalance(ctx context.Context, user *model.Balance) (err error) {
+	ctx, span := startSpan(ctx, "CreateBalance")
+	defer endSpan(span, &err)
 	return s.rps.CreateBalance(ctx, user)
 }
 
-// DeleteBalance function returns Delete repository method
-func (s *BalanceService) DeleteBalance(ctx context.Context, userID uuid.UUID) error {
-	return s.rps.DeleteBalance(ctx, userID)
+// FreezeBalance function moves a balance to a debit-frozen or fully frozen status
+func (s *BalanceService) FreezeBalance(ctx context.Context, change *model.StatusChange) (balance *model.Balance, err error) {
+	ctx, span := startSpan(ctx, "FreezeBalance")
+	defer endSpan(span, &err)
+	if change.Status != model.StatusDebitFrozen && change.Status != model.StatusFullyFrozen {
+		return nil, model.ErrInvalidStatusTransition
+	}
+	return s.changeStatus(ctx, change)
+}
+
+// UnfreezeBalance function returns a frozen balance to the active status
+func (s *BalanceService) UnfreezeBalance(ctx context.Context, change *model.StatusChange) (balance *model.Balance, err error) {
+	ctx, span := startSpan(ctx, "UnfreezeBalance")
+	defer endSpan(span, &err)
+	change.Status = model.StatusActive
+	return s.changeStatus(ctx, change)
+}
+
+// CloseBalance function closes a zero balance, closed balances are kept for history but accept no operations
+func (s *BalanceService) CloseBalance(ctx context.Context, change *model.StatusChange) (balance *model.Balance, err error) {
+	ctx, span := startSpan(ctx, "CloseBalance")
+	defer endSpan(span, &err)
+	change.Status = model.StatusClosed
+	return s.changeStatus(ctx, change)
+}
+
+// SetCreditLimit function validates the limit and returns SetCreditLimit repository method, a zero limit disables overdrafts
+func (s *BalanceService) SetCreditLimit(ctx context.Context, profileID uuid.UUID, currency model.Currency, creditLimit model.Money) (balance *model.Balance, err error) {
+	ctx, span := startSpan(ctx, "SetCreditLimit")
+	defer endSpan(span, &err)
+	if creditLimit.IsNegative() {
+		return nil, model.ErrInvalidCreditLimit
+	}
+	return s.rps.SetCreditLimit(ctx, profileID, currency, creditLimit)
+}
+
+// changeStatus checks that the change says who made it and returns ChangeStatus repository method
+func (s *BalanceService) changeStatus(ctx context.Context, change *model.StatusChange) (*model.Balance, error) {
+	if change.Actor == "" {
+		return nil, model.ErrActorRequired
+	}
+	return s.rps.ChangeStatus(ctx, change)
+}
+
+// Deposit function validates the amount and returns Deposit repository method
+func (s *BalanceService) Deposit(ctx context.Context, profileID uuid.UUID, currency model.Currency, amount model.Money, reference string) (balance *model.Balance, err error) {
+	ctx, span := startSpan(ctx, "Deposit")
+	defer endSpan(span, &err)
+	if amount.IsZero() || amount.IsNegative() {
+		return nil, model.ErrInvalidAmount
+	}
+	return s.rps.Deposit(ctx, profileID, currency, amount, reference)
+}
+
+// Withdraw function validates the amount and returns Withdraw repository method
+func (s *BalanceService) Withdraw(ctx context.Context, profileID uuid.UUID, currency model.Currency, amount model.Money, reference string) (balance *model.Balance, err error) {
+	ctx, span := startSpan(ctx, "Withdraw")
+	defer endSpan(span, &err)
+	if amount.IsZero() || amount.IsNegative() {
+		return nil, model.ErrInvalidAmount
+	}
+	return s.rps.Withdraw(ctx, profileID, currency, amount, reference)
+}
+
+// ListTransactions function returns a page of ledger entries and the sequence to continue after,
+// the returned sequence is 0 when there are no more entries
+func (s *BalanceService) ListTransactions(ctx context.Context, filter model.LedgerFilter) (entries []*model.LedgerEntry, next int64, err error) {
+	ctx, span := startSpan(ctx, "ListTransactions")
+	defer endSpan(span, &err)
+	limit := filter.Limit
+	if limit <= 0 {
+		return nil, 0, model.ErrInvalidPageSize
+	}
+	filter.Limit = limit + 1
+	entries, err = s.rps.ListTransactions(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(entries) <= limit {
+		return entries, 0, nil
+	}
+	entries = entries[:limit]
+	return entries, entries[limit-1].Sequence, nil
+}
+
+// watchBatchSize is the number of ledger entries WatchBalance reads at a time
+const watchBatchSize = 100
+
+// WatchBalance function calls fn with every ledger entry of a profile's balance in currency after afterSequence,
+// first the stored ones and then new ones as they are written, until ctx is done or fn fails.
+// An afterSequence of 0 starts with the latest entry. Entries of one balance are written while its row is locked,
+// so their sequences grow in commit order and reading after the last seen sequence never skips one.
+func (s *BalanceService) WatchBalance(ctx context.Context, profileID uuid.UUID, currency model.Currency, afterSequence int64,
+	fn func(*model.LedgerEntry) error) (err error) {
+	ctx, span := startSpan(ctx, "WatchBalance")
+	defer endSpan(span, &err)
+	_, err = s.rps.GetUserByID(ctx, profileID, currency)
+	if err != nil {
+		return err
+	}
+	changed, unsubscribe := s.notifier.Subscribe(profileID)
+	defer unsubscribe()
+	if afterSequence == 0 {
+		latest, err := s.rps.LatestSequence(ctx, profileID, currency)
+		if err != nil {
+			return err
+		}
+		if latest > 0 {
+			afterSequence = latest - 1
+		}
+	}
+	for {
+		filter := model.LedgerFilter{ProfileID: profileID, Currency: currency, AfterSequence: afterSequence, Limit: watchBatchSize}
+		entries, err := s.rps.ListTransactions(ctx, filter)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			err = fn(entry)
+			if err != nil {
+				return err
+			}
+			afterSequence = entry.Sequence
+		}
+		if len(entries) == watchBatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Transfer function validates the transfer and returns Transfer repository method
+func (s *BalanceService) Transfer(ctx context.Context, transfer *model.Transfer) (from, to *model.Balance, err error) {
+	ctx, span := startSpan(ctx, "Transfer")
+	defer endSpan(span, &err)
+	if transfer.Amount.IsZero() || transfer.Amount.IsNegative() {
+		return nil, nil, model.ErrInvalidAmount
+	}
+	if transfer.FromProfileID == transfer.ToProfileID {
+		return nil, nil, model.ErrSameProfileTransfer
+	}
+	return s.rps.Transfer(ctx, transfer)
+}
+
+// CreateHold function validates the amount and returns CreateHold repository method
+func (s *BalanceService) CreateHold(ctx context.Context, hold *model.Hold) (err error) {
+	ctx, span := startSpan(ctx, "CreateHold")
+	defer endSpan(span, &err)
+	if hold.Amount.IsZero() || hold.Amount.IsNegative() {
+		return model.ErrInvalidAmount
+	}
+	return s.rps.CreateHold(ctx, hold)
+}
+
+// CaptureHold function validates the amount and returns CaptureHold repository method, a zero amount captures the whole hold
+func (s *BalanceService) CaptureHold(ctx context.Context, holdID uuid.UUID, amount model.Money) (hold *model.Hold, balance *model.Balance, err error) {
+	ctx, span := startSpan(ctx, "CaptureHold")
+	defer endSpan(span, &err)
+	if amount.IsNegative() {
+		return nil, nil, model.ErrInvalidAmount
+	}
+	return s.rps.CaptureHold(ctx, holdID, amount)
+}
+
+// ReleaseHold function returns ReleaseHold repository method
+func (s *BalanceService) ReleaseHold(ctx context.Context, holdID uuid.UUID) (hold *model.Hold, err error) {
+	ctx, span := startSpan(ctx, "ReleaseHold")
+	defer endSpan(span, &err)
+	return s.rps.ReleaseHold(ctx, holdID)
 }
@@ -0,0 +1,43 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/eugenshima/balance/internal/model"
+	"github.com/eugenshima/balance/internal/repository/memory"
+	"github.com/eugenshima/balance/internal/service"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// TestAtomically tests that the operations of a unit of work take effect together, validation failures included
+func TestAtomically(t *testing.T) {
+	repo := memory.NewRepository()
+	srv := service.NewBalanceService(repo, repo)
+	funded := &model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: "EUR", Balance: model.MustParseMoney("10")}
+	require.NoError(t, srv.CreateBalance(context.Background(), funded))
+	opened := &model.Balance{BalanceID: uuid.New(), ProfileID: uuid.New(), Currency: "EUR"}
+	openAndFund := func(amount model.Money) func(ctx context.Context, tx *service.BalanceService) error {
+		return func(ctx context.Context, tx *service.BalanceService) error {
+			err := tx.CreateBalance(ctx, opened)
+			if err != nil {
+				return err
+			}
+			_, _, err = tx.Transfer(ctx, &model.Transfer{TransferID: uuid.New(), FromProfileID: funded.ProfileID, ToProfileID: opened.ProfileID,
+				Currency: "EUR", Amount: amount})
+			return err
+		}
+	}
+
+	err := srv.Atomically(context.Background(), model.Serializable, openAndFund(model.MustParseMoney("0")))
+	require.ErrorIs(t, err, model.ErrInvalidAmount)
+	_, err = srv.GetUserByID(context.Background(), opened.ProfileID, "EUR")
+	require.ErrorIs(t, err, model.ErrNotFound)
+
+	require.NoError(t, srv.Atomically(context.Background(), model.Serializable, openAndFund(model.MustParseMoney("2.5"))))
+	balance, err := srv.GetUserByID(context.Background(), opened.ProfileID, "EUR")
+	require.NoError(t, err)
+	require.Zero(t, balance.Balance.Cmp(model.MustParseMoney("2.5")))
+}
@@ -0,0 +1,27 @@
+package service
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of service methods
+var tracer = otel.Tracer("github.com/eugenshima/balance/internal/service")
+
+// startSpan starts the span of a service method
+func startSpan(ctx context.Context, method string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "BalanceService."+method)
+}
+
+// endSpan records the error the method returned in *err on span and ends it, it is deferred with the address of
+// the method's named error result
+func endSpan(span trace.Span, err *error) {
+	if *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
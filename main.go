@@ -3,63 +3,31 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"net"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/eugenshima/balance/internal/app"
 	cfgrtn "github.com/eugenshima/balance/internal/config"
-	"github.com/eugenshima/balance/internal/handlers"
-	"github.com/eugenshima/balance/internal/repository"
-	"github.com/eugenshima/balance/internal/service"
-	proto "github.com/eugenshima/balance/proto"
 
-	"github.com/go-playground/validator"
-	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/sirupsen/logrus"
-	"google.golang.org/grpc"
 )
 
-// NewDBPsql function provides Connection with PostgreSQL database
-func NewDBPsql(env string) (*pgxpool.Pool, error) {
-	// Initialization a connect configuration for a PostgreSQL using pgx driver
-	config, err := pgxpool.ParseConfig(env)
-	if err != nil {
-		return nil, fmt.Errorf("error connection to PostgreSQL: %v", err)
-	}
-
-	// Establishing a new connection to a PostgreSQL database using the pgx driver
-	pool, err := pgxpool.ConnectConfig(context.Background(), config)
-	if err != nil {
-		return nil, fmt.Errorf("error connection to PostgreSQL: %v", err)
-	}
-	// Output to console
-	fmt.Println("Connected to PostgreSQL!")
-
-	return pool, nil
-}
-
-// main function of our microservice
+// main function of our microservice, it runs until SIGINT or SIGTERM.
+// "balance migrate up | down [steps] | status" manages the database schema instead.
 func main() {
 	cfg, err := cfgrtn.NewConfig()
 	if err != nil {
-		fmt.Printf("Error extracting env variables: %v", err)
-		return
-	}
-	pool, err := NewDBPsql(cfg.PgxDBAddr)
-	if err != nil {
-		logrus.WithFields(logrus.Fields{"PgxDBAddr: ": cfg.PgxDBAddr}).Errorf("NewDBPsql: %v", err)
+		logrus.Fatalf("Error extracting env variables: %v", err)
 	}
-	pgx := repository.NewPsqlConnection(pool)
-	srv := service.NewBalanceService(pgx)
-	hndl := handlers.NewBalancehandler(srv, validator.New())
-	lis, err := net.Listen("tcp", "127.0.0.1:8081")
-	if err != nil {
-		logrus.Fatalf("cannot create listener: %s", err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err = app.Migrate(ctx, cfg, os.Args[2:], os.Stdout)
+	} else {
+		err = app.New(cfg).Run(ctx)
 	}
-
-	serverRegistrar := grpc.NewServer()
-	proto.RegisterBalanceServiceServer(serverRegistrar, hndl)
-	err = serverRegistrar.Serve(lis)
+	stop()
 	if err != nil {
-		logrus.Fatalf("cannot start server: %s", err)
+		logrus.Fatalf("Run: %v", err)
 	}
 }
@@ -25,8 +25,25 @@ type BalanceServiceClient interface {
 	UpdateUserBalance(ctx context.Context, in *UserUpdateRequest, opts ...grpc.CallOption) (*UserUpdateResponse, error)
 	GetUserByID(ctx context.Context, in *UserGetByIDRequest, opts ...grpc.CallOption) (*UserGetByIDResponse, error)
 	CreateUserBalance(ctx context.Context, in *CreateBalanceRequest, opts ...grpc.CallOption) (*CreateBalanceResponse, error)
+	// DeleteUserBalance is kept for old clients and closes the balance like CloseBalance, balances are never deleted
 	DeleteUserBalance(ctx context.Context, in *DeleteBalanceRequest, opts ...grpc.CallOption) (*DeleteBalanceResponse, error)
 	GetAllUserBalances(ctx context.Context, in *GetAllBalanceRequest, opts ...grpc.CallOption) (*GetAllBalanceResponse, error)
+	// StreamAllBalances sends every balance in ProfileID order, all of them read from one consistent snapshot
+	StreamAllBalances(ctx context.Context, in *StreamAllBalancesRequest, opts ...grpc.CallOption) (BalanceService_StreamAllBalancesClient, error)
+	Deposit(ctx context.Context, in *DepositRequest, opts ...grpc.CallOption) (*DepositResponse, error)
+	Withdraw(ctx context.Context, in *WithdrawRequest, opts ...grpc.CallOption) (*WithdrawResponse, error)
+	ListTransactions(ctx context.Context, in *ListTransactionsRequest, opts ...grpc.CallOption) (*ListTransactionsResponse, error)
+	// WatchBalance sends the ledger entries of a profile as its balance changes until the client cancels the stream
+	WatchBalance(ctx context.Context, in *WatchBalanceRequest, opts ...grpc.CallOption) (BalanceService_WatchBalanceClient, error)
+	Transfer(ctx context.Context, in *TransferRequest, opts ...grpc.CallOption) (*TransferResponse, error)
+	CreateHold(ctx context.Context, in *CreateHoldRequest, opts ...grpc.CallOption) (*CreateHoldResponse, error)
+	CaptureHold(ctx context.Context, in *CaptureHoldRequest, opts ...grpc.CallOption) (*CaptureHoldResponse, error)
+	ReleaseHold(ctx context.Context, in *ReleaseHoldRequest, opts ...grpc.CallOption) (*ReleaseHoldResponse, error)
+	FreezeBalance(ctx context.Context, in *FreezeBalanceRequest, opts ...grpc.CallOption) (*FreezeBalanceResponse, error)
+	UnfreezeBalance(ctx context.Context, in *UnfreezeBalanceRequest, opts ...grpc.CallOption) (*UnfreezeBalanceResponse, error)
+	CloseBalance(ctx context.Context, in *CloseBalanceRequest, opts ...grpc.CallOption) (*CloseBalanceResponse, error)
+	// SetCreditLimit lets the available balance go negative up to the limit, a zero limit disables overdrafts
+	SetCreditLimit(ctx context.Context, in *SetCreditLimitRequest, opts ...grpc.CallOption) (*SetCreditLimitResponse, error)
 }
 
 type balanceServiceClient struct {
@@ -82,6 +99,169 @@ func (c *balanceServiceClient) GetAllUserBalances(ctx context.Context, in *GetAl
 	return out, nil
 }
 
+func (c *balanceServiceClient) StreamAllBalances(ctx context.Context, in *StreamAllBalancesRequest, opts ...grpc.CallOption) (BalanceService_StreamAllBalancesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &BalanceService_ServiceDesc.Streams[0], "/BalanceService/StreamAllBalances", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &balanceServiceStreamAllBalancesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type BalanceService_StreamAllBalancesClient interface {
+	Recv() (*Balance, error)
+	grpc.ClientStream
+}
+
+type balanceServiceStreamAllBalancesClient struct {
+	grpc.ClientStream
+}
+
+func (x *balanceServiceStreamAllBalancesClient) Recv() (*Balance, error) {
+	m := new(Balance)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *balanceServiceClient) Deposit(ctx context.Context, in *DepositRequest, opts ...grpc.CallOption) (*DepositResponse, error) {
+	out := new(DepositResponse)
+	err := c.cc.Invoke(ctx, "/BalanceService/Deposit", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *balanceServiceClient) Withdraw(ctx context.Context, in *WithdrawRequest, opts ...grpc.CallOption) (*WithdrawResponse, error) {
+	out := new(WithdrawResponse)
+	err := c.cc.Invoke(ctx, "/BalanceService/Withdraw", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *balanceServiceClient) ListTransactions(ctx context.Context, in *ListTransactionsRequest, opts ...grpc.CallOption) (*ListTransactionsResponse, error) {
+	out := new(ListTransactionsResponse)
+	err := c.cc.Invoke(ctx, "/BalanceService/ListTransactions", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *balanceServiceClient) WatchBalance(ctx context.Context, in *WatchBalanceRequest, opts ...grpc.CallOption) (BalanceService_WatchBalanceClient, error) {
+	stream, err := c.cc.NewStream(ctx, &BalanceService_ServiceDesc.Streams[1], "/BalanceService/WatchBalance", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &balanceServiceWatchBalanceClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type BalanceService_WatchBalanceClient interface {
+	Recv() (*WatchBalanceResponse, error)
+	grpc.ClientStream
+}
+
+type balanceServiceWatchBalanceClient struct {
+	grpc.ClientStream
+}
+
+func (x *balanceServiceWatchBalanceClient) Recv() (*WatchBalanceResponse, error) {
+	m := new(WatchBalanceResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *balanceServiceClient) Transfer(ctx context.Context, in *TransferRequest, opts ...grpc.CallOption) (*TransferResponse, error) {
+	out := new(TransferResponse)
+	err := c.cc.Invoke(ctx, "/BalanceService/Transfer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *balanceServiceClient) CreateHold(ctx context.Context, in *CreateHoldRequest, opts ...grpc.CallOption) (*CreateHoldResponse, error) {
+	out := new(CreateHoldResponse)
+	err := c.cc.Invoke(ctx, "/BalanceService/CreateHold", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *balanceServiceClient) CaptureHold(ctx context.Context, in *CaptureHoldRequest, opts ...grpc.CallOption) (*CaptureHoldResponse, error) {
+	out := new(CaptureHoldResponse)
+	err := c.cc.Invoke(ctx, "/BalanceService/CaptureHold", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *balanceServiceClient) ReleaseHold(ctx context.Context, in *ReleaseHoldRequest, opts ...grpc.CallOption) (*ReleaseHoldResponse, error) {
+	out := new(ReleaseHoldResponse)
+	err := c.cc.Invoke(ctx, "/BalanceService/ReleaseHold", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *balanceServiceClient) FreezeBalance(ctx context.Context, in *FreezeBalanceRequest, opts ...grpc.CallOption) (*FreezeBalanceResponse, error) {
+	out := new(FreezeBalanceResponse)
+	err := c.cc.Invoke(ctx, "/BalanceService/FreezeBalance", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *balanceServiceClient) UnfreezeBalance(ctx context.Context, in *UnfreezeBalanceRequest, opts ...grpc.CallOption) (*UnfreezeBalanceResponse, error) {
+	out := new(UnfreezeBalanceResponse)
+	err := c.cc.Invoke(ctx, "/BalanceService/UnfreezeBalance", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *balanceServiceClient) CloseBalance(ctx context.Context, in *CloseBalanceRequest, opts ...grpc.CallOption) (*CloseBalanceResponse, error) {
+	out := new(CloseBalanceResponse)
+	err := c.cc.Invoke(ctx, "/BalanceService/CloseBalance", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *balanceServiceClient) SetCreditLimit(ctx context.Context, in *SetCreditLimitRequest, opts ...grpc.CallOption) (*SetCreditLimitResponse, error) {
+	out := new(SetCreditLimitResponse)
+	err := c.cc.Invoke(ctx, "/BalanceService/SetCreditLimit", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // BalanceServiceServer is the server API for BalanceService service.
 // All implementations must embed UnimplementedBalanceServiceServer
 // for forward compatibility
@@ -89,8 +269,25 @@ type BalanceServiceServer interface {
 	UpdateUserBalance(context.Context, *UserUpdateRequest) (*UserUpdateResponse, error)
 	GetUserByID(context.Context, *UserGetByIDRequest) (*UserGetByIDResponse, error)
 	CreateUserBalance(context.Context, *CreateBalanceRequest) (*CreateBalanceResponse, error)
+	// DeleteUserBalance is kept for old clients and closes the balance like CloseBalance, balances are never deleted
 	DeleteUserBalance(context.Context, *DeleteBalanceRequest) (*DeleteBalanceResponse, error)
 	GetAllUserBalances(context.Context, *GetAllBalanceRequest) (*GetAllBalanceResponse, error)
+	// StreamAllBalances sends every balance in ProfileID order, all of them read from one consistent snapshot
+	StreamAllBalances(*StreamAllBalancesRequest, BalanceService_StreamAllBalancesServer) error
+	Deposit(context.Context, *DepositRequest) (*DepositResponse, error)
+	Withdraw(context.Context, *WithdrawRequest) (*WithdrawResponse, error)
+	ListTransactions(context.Context, *ListTransactionsRequest) (*ListTransactionsResponse, error)
+	// WatchBalance sends the ledger entries of a profile as its balance changes until the client cancels the stream
+	WatchBalance(*WatchBalanceRequest, BalanceService_WatchBalanceServer) error
+	Transfer(context.Context, *TransferRequest) (*TransferResponse, error)
+	CreateHold(context.Context, *CreateHoldRequest) (*CreateHoldResponse, error)
+	CaptureHold(context.Context, *CaptureHoldRequest) (*CaptureHoldResponse, error)
+	ReleaseHold(context.Context, *ReleaseHoldRequest) (*ReleaseHoldResponse, error)
+	FreezeBalance(context.Context, *FreezeBalanceRequest) (*FreezeBalanceResponse, error)
+	UnfreezeBalance(context.Context, *UnfreezeBalanceRequest) (*UnfreezeBalanceResponse, error)
+	CloseBalance(context.Context, *CloseBalanceRequest) (*CloseBalanceResponse, error)
+	// SetCreditLimit lets the available balance go negative up to the limit, a zero limit disables overdrafts
+	SetCreditLimit(context.Context, *SetCreditLimitRequest) (*SetCreditLimitResponse, error)
 	mustEmbedUnimplementedBalanceServiceServer()
 }
 
@@ -113,6 +310,45 @@ func (UnimplementedBalanceServiceServer) DeleteUserBalance(context.Context, *Del
 func (UnimplementedBalanceServiceServer) GetAllUserBalances(context.Context, *GetAllBalanceRequest) (*GetAllBalanceResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetAllUserBalances not implemented")
 }
+func (UnimplementedBalanceServiceServer) StreamAllBalances(*StreamAllBalancesRequest, BalanceService_StreamAllBalancesServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamAllBalances not implemented")
+}
+func (UnimplementedBalanceServiceServer) Deposit(context.Context, *DepositRequest) (*DepositResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Deposit not implemented")
+}
+func (UnimplementedBalanceServiceServer) Withdraw(context.Context, *WithdrawRequest) (*WithdrawResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Withdraw not implemented")
+}
+func (UnimplementedBalanceServiceServer) ListTransactions(context.Context, *ListTransactionsRequest) (*ListTransactionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTransactions not implemented")
+}
+func (UnimplementedBalanceServiceServer) WatchBalance(*WatchBalanceRequest, BalanceService_WatchBalanceServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchBalance not implemented")
+}
+func (UnimplementedBalanceServiceServer) Transfer(context.Context, *TransferRequest) (*TransferResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Transfer not implemented")
+}
+func (UnimplementedBalanceServiceServer) CreateHold(context.Context, *CreateHoldRequest) (*CreateHoldResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateHold not implemented")
+}
+func (UnimplementedBalanceServiceServer) CaptureHold(context.Context, *CaptureHoldRequest) (*CaptureHoldResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CaptureHold not implemented")
+}
+func (UnimplementedBalanceServiceServer) ReleaseHold(context.Context, *ReleaseHoldRequest) (*ReleaseHoldResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReleaseHold not implemented")
+}
+func (UnimplementedBalanceServiceServer) FreezeBalance(context.Context, *FreezeBalanceRequest) (*FreezeBalanceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FreezeBalance not implemented")
+}
+func (UnimplementedBalanceServiceServer) UnfreezeBalance(context.Context, *UnfreezeBalanceRequest) (*UnfreezeBalanceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UnfreezeBalance not implemented")
+}
+func (UnimplementedBalanceServiceServer) CloseBalance(context.Context, *CloseBalanceRequest) (*CloseBalanceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CloseBalance not implemented")
+}
+func (UnimplementedBalanceServiceServer) SetCreditLimit(context.Context, *SetCreditLimitRequest) (*SetCreditLimitResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetCreditLimit not implemented")
+}
 func (UnimplementedBalanceServiceServer) mustEmbedUnimplementedBalanceServiceServer() {}
 
 // UnsafeBalanceServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -216,6 +452,246 @@ func _BalanceService_GetAllUserBalances_Handler(srv interface{}, ctx context.Con
 	return interceptor(ctx, in, info, handler)
 }
 
+func _BalanceService_StreamAllBalances_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamAllBalancesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BalanceServiceServer).StreamAllBalances(m, &balanceServiceStreamAllBalancesServer{stream})
+}
+
+type BalanceService_StreamAllBalancesServer interface {
+	Send(*Balance) error
+	grpc.ServerStream
+}
+
+type balanceServiceStreamAllBalancesServer struct {
+	grpc.ServerStream
+}
+
+func (x *balanceServiceStreamAllBalancesServer) Send(m *Balance) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _BalanceService_Deposit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DepositRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BalanceServiceServer).Deposit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/BalanceService/Deposit",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BalanceServiceServer).Deposit(ctx, req.(*DepositRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BalanceService_Withdraw_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WithdrawRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BalanceServiceServer).Withdraw(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/BalanceService/Withdraw",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BalanceServiceServer).Withdraw(ctx, req.(*WithdrawRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BalanceService_ListTransactions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTransactionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BalanceServiceServer).ListTransactions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/BalanceService/ListTransactions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BalanceServiceServer).ListTransactions(ctx, req.(*ListTransactionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BalanceService_WatchBalance_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchBalanceRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BalanceServiceServer).WatchBalance(m, &balanceServiceWatchBalanceServer{stream})
+}
+
+type BalanceService_WatchBalanceServer interface {
+	Send(*WatchBalanceResponse) error
+	grpc.ServerStream
+}
+
+type balanceServiceWatchBalanceServer struct {
+	grpc.ServerStream
+}
+
+func (x *balanceServiceWatchBalanceServer) Send(m *WatchBalanceResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _BalanceService_Transfer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TransferRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BalanceServiceServer).Transfer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/BalanceService/Transfer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BalanceServiceServer).Transfer(ctx, req.(*TransferRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BalanceService_CreateHold_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateHoldRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BalanceServiceServer).CreateHold(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/BalanceService/CreateHold",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BalanceServiceServer).CreateHold(ctx, req.(*CreateHoldRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BalanceService_CaptureHold_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CaptureHoldRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BalanceServiceServer).CaptureHold(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/BalanceService/CaptureHold",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BalanceServiceServer).CaptureHold(ctx, req.(*CaptureHoldRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BalanceService_ReleaseHold_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReleaseHoldRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BalanceServiceServer).ReleaseHold(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/BalanceService/ReleaseHold",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BalanceServiceServer).ReleaseHold(ctx, req.(*ReleaseHoldRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BalanceService_FreezeBalance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FreezeBalanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BalanceServiceServer).FreezeBalance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/BalanceService/FreezeBalance",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BalanceServiceServer).FreezeBalance(ctx, req.(*FreezeBalanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BalanceService_UnfreezeBalance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnfreezeBalanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BalanceServiceServer).UnfreezeBalance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/BalanceService/UnfreezeBalance",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BalanceServiceServer).UnfreezeBalance(ctx, req.(*UnfreezeBalanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BalanceService_CloseBalance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CloseBalanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BalanceServiceServer).CloseBalance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/BalanceService/CloseBalance",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BalanceServiceServer).CloseBalance(ctx, req.(*CloseBalanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BalanceService_SetCreditLimit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetCreditLimitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BalanceServiceServer).SetCreditLimit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/BalanceService/SetCreditLimit",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BalanceServiceServer).SetCreditLimit(ctx, req.(*SetCreditLimitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // BalanceService_ServiceDesc is the grpc.ServiceDesc for BalanceService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -243,7 +719,62 @@ var BalanceService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetAllUserBalances",
 			Handler:    _BalanceService_GetAllUserBalances_Handler,
 		},
+		{
+			MethodName: "Deposit",
+			Handler:    _BalanceService_Deposit_Handler,
+		},
+		{
+			MethodName: "Withdraw",
+			Handler:    _BalanceService_Withdraw_Handler,
+		},
+		{
+			MethodName: "ListTransactions",
+			Handler:    _BalanceService_ListTransactions_Handler,
+		},
+		{
+			MethodName: "Transfer",
+			Handler:    _BalanceService_Transfer_Handler,
+		},
+		{
+			MethodName: "CreateHold",
+			Handler:    _BalanceService_CreateHold_Handler,
+		},
+		{
+			MethodName: "CaptureHold",
+			Handler:    _BalanceService_CaptureHold_Handler,
+		},
+		{
+			MethodName: "ReleaseHold",
+			Handler:    _BalanceService_ReleaseHold_Handler,
+		},
+		{
+			MethodName: "FreezeBalance",
+			Handler:    _BalanceService_FreezeBalance_Handler,
+		},
+		{
+			MethodName: "UnfreezeBalance",
+			Handler:    _BalanceService_UnfreezeBalance_Handler,
+		},
+		{
+			MethodName: "CloseBalance",
+			Handler:    _BalanceService_CloseBalance_Handler,
+		},
+		{
+			MethodName: "SetCreditLimit",
+			Handler:    _BalanceService_SetCreditLimit_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamAllBalances",
+			Handler:       _BalanceService_StreamAllBalances_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchBalance",
+			Handler:       _BalanceService_WatchBalance_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "balance.proto",
 }